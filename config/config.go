@@ -5,10 +5,12 @@ import (
 	"flag"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sync"
 
 	"github.com/BurntSushi/toml"
@@ -46,6 +48,23 @@ var Handlers = map[string]func(logger *slog.Logger, u, cacheControle string) htt
 	"p": handlers.ReverseProxy,
 }
 
+// The initial Handlers, to find the entries changed at init.
+var handlersInit = maps.Clone(Handlers)
+
+// Map of config handlers with the options of the handler config. It is used
+// before Handlers, except for the entries of Handlers changed at init.
+//
+// You can add your cutom handler to this map at init.
+var HandlersOptions = map[string]func(logger *slog.Logger, u, cacheControle string, opt handlers.Options) http.Handler{
+	"f": handlers.FileOptions,
+	"m": handlers.CacheOptions,
+	"a": handlers.Archive,
+	"g": handlers.Git,
+	"w": handlers.WebDAV,
+	"u": handlers.Upload,
+	"p": handlers.ReverseProxyOptions,
+}
+
 type Config struct {
 	// Log output directory
 	Log string
@@ -59,6 +78,8 @@ type Mux struct {
 	Cert []Cert
 	// Handlers config, indexed by domain and path
 	Handlers map[string]Handler `toml:"h"`
+	// Default custom error pages of the handlers, by status code.
+	Errors map[string]string `toml:"errors"`
 }
 
 type Cert struct {
@@ -80,6 +101,8 @@ type Handler struct {
 	URL string `toml:"u"`
 	// Cache control instruction.
 	Cache string `toml:"c"`
+	// Other options, the fields are inlined in the handler config.
+	handlers.Options
 }
 
 // Decode toml file into a Config structure.
@@ -114,14 +137,28 @@ func Listen(configFile string) {
 	}
 }
 
+// Create the handler of a config, with Handlers if the entry was changed at
+// init, else with HandlersOptions, else with Handlers.
+func newHandler(logger *slog.Logger, config Handler, opt handlers.Options) (http.Handler, error) {
+	n := Handlers[config.Type]
+	if n != nil && reflect.ValueOf(n).Pointer() != reflect.ValueOf(handlersInit[config.Type]).Pointer() {
+		return n(logger, config.URL, config.Cache), nil
+	} else if o := HandlersOptions[config.Type]; o != nil {
+		return o(logger, config.URL, config.Cache, opt), nil
+	} else if n != nil {
+		return n(logger, config.URL, config.Cache), nil
+	}
+	return nil, fmt.Errorf("unknown handler type: %q", config.Type)
+}
+
 func (mux *Mux) Listen(logger *slog.Logger, address string) error {
 	muxServer := http.NewServeMux()
 	for pattern, config := range mux.Handlers {
-		n := Handlers[config.Type]
-		if n == nil {
-			return fmt.Errorf("unknown handler type: %q", config.Type)
+		h, err := newHandler(logger, config, mux.options(config))
+		if err != nil {
+			return err
 		}
-		muxServer.Handle(pattern, n(logger, config.URL, config.Cache))
+		muxServer.Handle(pattern, h)
 	}
 
 	logger.Info("listen", "address", address)
@@ -143,6 +180,22 @@ func (mux *Mux) Listen(logger *slog.Logger, address string) error {
 	}).Serve(listener)
 }
 
+// Get the handler options, with the mux default error pages.
+func (mux *Mux) options(config Handler) handlers.Options {
+	opt := config.Options
+	if len(mux.Errors) == 0 {
+		return opt
+	}
+	opt.Errors = make(map[string]string, len(mux.Errors)+len(config.Errors))
+	for status, file := range mux.Errors {
+		opt.Errors[status] = file
+	}
+	for status, file := range config.Errors {
+		opt.Errors[status] = file
+	}
+	return opt
+}
+
 func (mux Mux) LoadTLS() (*tls.Config, error) {
 	if len(mux.Cert) == 0 {
 		return nil, nil
@@ -1,8 +1,13 @@
 package config
 
 import (
+	"io"
+	"log/slog"
+	"net/http"
 	"testing"
+	"time"
 
+	"github.com/HuguesGuilleus/servHTTP/handlers"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,6 +22,7 @@ func TestReadFile(t *testing.T) {
 					"/":             {Type: "s"},
 					"/.well-known/": {Type: "f", URL: "/var/letsencrypt/"},
 				},
+				Errors: map[string]string{"404": "/var/www/errors/404.html"},
 			},
 			":443": {
 				Cert: []Cert{
@@ -26,8 +32,57 @@ func TestReadFile(t *testing.T) {
 				Handlers: map[string]Handler{
 					"example.org/": {Type: "f", URL: "/var/www/example.org/", Cache: "max-age=60"},
 					"example.com/": {Type: "f", URL: "/var/www/example.com/", Cache: "max-age=60"},
+					"example.org/assets/": {Type: "m", URL: "/var/www/assets/", Options: handlers.Options{
+						Interval: time.Minute,
+					}},
 				},
 			},
 		},
 	}, myCongig)
 }
+
+func TestMuxOptions(t *testing.T) {
+	mux := Mux{Errors: map[string]string{"404": "mux404.html", "500": "mux500.html"}}
+	assert.Equal(t, handlers.Options{
+		Interval: time.Minute,
+		Errors:   map[string]string{"404": "h404.html", "500": "mux500.html"},
+	}, mux.options(Handler{Options: handlers.Options{
+		Interval: time.Minute,
+		Errors:   map[string]string{"404": "h404.html"},
+	}}))
+
+	mux = Mux{}
+	assert.Equal(t, handlers.Options{Interval: time.Minute}, mux.options(Handler{Options: handlers.Options{
+		Interval: time.Minute,
+	}}))
+}
+
+func TestNewHandler(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	called := ""
+	defer func(f func(*slog.Logger, string, string, handlers.Options) http.Handler) { HandlersOptions["f"] = f }(HandlersOptions["f"])
+	HandlersOptions["f"] = func(_ *slog.Logger, _, _ string, opt handlers.Options) http.Handler {
+		called = "options " + opt.Ref
+		return http.NotFoundHandler()
+	}
+
+	_, err := newHandler(logger, Handler{Type: "f"}, handlers.Options{Ref: "x"})
+	assert.NoError(t, err)
+	assert.Equal(t, "options x", called)
+
+	// A Handlers entry changed at init is used first.
+	defer func(f func(*slog.Logger, string, string) http.Handler) { Handlers["f"] = f }(Handlers["f"])
+	Handlers["f"] = func(*slog.Logger, string, string) http.Handler {
+		called = "override"
+		return http.NotFoundHandler()
+	}
+	_, err = newHandler(logger, Handler{Type: "f"}, handlers.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "override", called)
+
+	h, err := newHandler(logger, Handler{Type: "r", URL: "https://example.com/"}, handlers.Options{})
+	assert.NoError(t, err)
+	assert.NotNil(t, h)
+	_, err = newHandler(logger, Handler{Type: "unknown"}, handlers.Options{})
+	assert.Error(t, err)
+}
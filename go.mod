@@ -6,6 +6,8 @@ require github.com/BurntSushi/toml v1.3.2
 
 require github.com/HuguesGuilleus/go-logoutput v0.0.0-20200628151522-2e361139dcd9
 
+require golang.org/x/crypto v0.31.0
+
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+)
+
+// Formats of the directory archives, selected with the query "download".
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// The default maximum total size of the files in a directory archive.
+const archiveDefaultMax = 1 << 30
+
+// The directory download as an archive.
+type archivePolicy struct {
+	// The download is enabled.
+	enabled bool
+	// Maximum total size of the files, negative for no limit.
+	max int64
+}
+
+func newArchivePolicy(opt Options) archivePolicy {
+	max := opt.ArchiveMax
+	if max == 0 {
+		max = archiveDefaultMax
+	}
+	return archivePolicy{
+		enabled: opt.Archive,
+		max:     max,
+	}
+}
+
+// Get the archive format of the request, "" if it's not a download.
+func (policy *archivePolicy) format(r *http.Request) string {
+	if !policy.enabled {
+		return ""
+	}
+	switch format := r.URL.Query().Get("download"); format {
+	case ArchiveZip, ArchiveTarGz:
+		return format
+	}
+	return ""
+}
+
+// A file or a directory in an archive.
+type archiveEntry struct {
+	// The path from the archived directory, the directories end with a slash.
+	name string
+	info fs.FileInfo
+}
+
+// Serve the directory dir (ending with a slash) as an archive.
+// The entries are listed first to check the total size, then the files
+// are streamed.
+func (hand *common) serveArchive(w http.ResponseWriter, r *http.Request, fsys http.FileSystem, dir string) {
+	entries, size, err := hand.archiveList(fsys, dir, "", nil)
+	if err != nil {
+		LogRequest(hand.Logger.With("err", err.Error()), http.StatusInternalServerError, r)
+		hand.servError(w, r, http.StatusInternalServerError)
+		return
+	} else if hand.archive.max >= 0 && size > hand.archive.max {
+		LogRequest(hand.Logger.With("err", "archive too large", "size", size), http.StatusRequestEntityTooLarge, r)
+		hand.servError(w, r, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	format := hand.archive.format(r)
+	name := path.Base(dir)
+	if name == "/" {
+		name = "root"
+	}
+	contentType := "application/zip"
+	if format == ArchiveTarGz {
+		contentType = "application/gzip"
+	}
+	w.Header().Set(headerContentType, contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + "." + format}))
+	LogRequest(hand.Logger, http.StatusOK, r)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if format == ArchiveZip {
+		err = archiveZip(w, fsys, dir, name, entries)
+	} else {
+		err = archiveTarGz(w, fsys, dir, name, entries)
+	}
+	if err != nil {
+		// The status is sent, abort the response to not send a truncated archive.
+		hand.Logger.Warn("archive", "path", r.URL.Path, "err", err.Error())
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// List recursively the regular files and the directories of dir+sub,
+// without the denied names. The symbolic links to a directory are ignored
+// to avoid loops, the broken links are ignored.
+func (hand *common) archiveList(fsys http.FileSystem, dir, sub string, entries []archiveEntry) ([]archiveEntry, int64, error) {
+	file, err := fsys.Open(path.Clean(dir + sub))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	size := int64(0)
+	for {
+		infos, err := file.Readdir(indexReadSize)
+		for _, info := range infos {
+			name := sub + info.Name()
+			if hand.deny.denied(dir + name) {
+				continue
+			}
+			if info.Mode()&fs.ModeSymlink != 0 {
+				_, target, err := open(fsys, dir+name)
+				if err != nil || target.IsDir() {
+					continue
+				}
+				info = target
+			}
+
+			if info.IsDir() {
+				entries = append(entries, archiveEntry{name + "/", info})
+				subEntries, subSize, subErr := hand.archiveList(fsys, dir, name+"/", entries)
+				if subErr != nil {
+					return nil, 0, subErr
+				}
+				entries = subEntries
+				size += subSize
+			} else if info.Mode().IsRegular() {
+				entries = append(entries, archiveEntry{name, info})
+				size += info.Size()
+			}
+		}
+		if err == io.EOF {
+			return entries, size, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+// Write the zip archive, the files are in the directory name.
+func archiveZip(w io.Writer, fsys http.FileSystem, dir, name string, entries []archiveEntry) error {
+	zw := zip.NewWriter(w)
+	for _, entry := range entries {
+		header, err := zip.FileInfoHeader(entry.info)
+		if err != nil {
+			return err
+		}
+		header.Name = name + "/" + entry.name
+		if entry.info.IsDir() {
+			header.Method = zip.Store
+		} else {
+			header.Method = zip.Deflate
+		}
+		dst, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		} else if entry.info.IsDir() {
+			continue
+		}
+		if err := archiveCopy(dst, fsys, dir+entry.name, entry.info.Size()); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// Write the gzip compressed tar archive, the files are in the directory name.
+func archiveTarGz(w io.Writer, fsys http.FileSystem, dir, name string, entries []archiveEntry) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		header, err := tar.FileInfoHeader(entry.info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name + "/" + entry.name
+		// Do not publish the owner on the server.
+		header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		} else if entry.info.IsDir() {
+			continue
+		}
+		if err := archiveCopy(tw, fsys, dir+entry.name, entry.info.Size()); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Copy size bytes of the file p, an error if the file is shorter.
+func archiveCopy(w io.Writer, fsys http.FileSystem, p string, size int64) error {
+	file, err := fsys.Open(p)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.CopyN(w, file, size)
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A read only http.FileSystem of a zip or tar archive.
+// The paths are prefixed by prefix (without ending slash).
+type archiveFS struct {
+	prefix string
+	// The entries by cleaned path without leading slash, "" for the root.
+	entries map[string]*archiveEntryFS
+}
+
+// A file or a directory of an archive.
+type archiveEntryFS struct {
+	info archiveInfo
+	// The children names of a directory, sorted.
+	children []string
+
+	// The content of a stored file (not compressed).
+	section *io.SectionReader
+	// The zip file if compressed.
+	zip *zip.File
+	// The raw deflate content of a zip file, nil for other methods.
+	deflate *io.SectionReader
+}
+
+// Open a zip archive of size bytes.
+func newZipFS(r io.ReaderAt, size int64) (*archiveFS, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := newArchiveFS()
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			if dir := fsys.add(f.Name, nil); dir != nil {
+				dir.info.modTime = f.Modified
+			}
+			continue
+		}
+		entry := fsys.add(f.Name, &archiveInfo{size: int64(f.UncompressedSize64), modTime: f.Modified})
+		if entry == nil {
+			continue
+		}
+		offset, err := f.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		switch f.Method {
+		case zip.Store:
+			entry.section = io.NewSectionReader(r, offset, int64(f.CompressedSize64))
+		case zip.Deflate:
+			entry.deflate = io.NewSectionReader(r, offset, int64(f.CompressedSize64))
+			entry.zip = f
+		default:
+			entry.zip = f
+		}
+	}
+	return fsys, nil
+}
+
+// Open a tar archive (not compressed) of size bytes.
+// Only the directories and the regular files are used.
+func newTarFS(r io.ReaderAt, size int64) (*archiveFS, error) {
+	section := io.NewSectionReader(r, 0, size)
+	tr := tar.NewReader(section)
+	fsys := newArchiveFS()
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return fsys, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if dir := fsys.add(header.Name, nil); dir != nil {
+				dir.info.modTime = header.ModTime
+			}
+		case tar.TypeReg:
+			offset, _ := section.Seek(0, io.SeekCurrent)
+			entry := fsys.add(header.Name, &archiveInfo{size: header.Size, modTime: header.ModTime})
+			if entry != nil {
+				entry.section = io.NewSectionReader(r, offset, header.Size)
+			}
+		}
+	}
+}
+
+func newArchiveFS() *archiveFS {
+	return &archiveFS{entries: map[string]*archiveEntryFS{
+		"": {info: archiveInfo{name: "/", dir: true}},
+	}}
+}
+
+// Add a file, or a directory if info is nil, and its parent directories.
+// Return nil if the name is invalid or if the file already exists.
+func (fsys *archiveFS) add(name string, info *archiveInfo) *archiveEntryFS {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if entry := fsys.entries[name]; entry != nil {
+		if info == nil && entry.info.dir {
+			return entry
+		}
+		return nil
+	} else if name == "" {
+		return nil
+	}
+
+	parent := fsys.add(path.Dir("/"+name), nil)
+	if parent == nil {
+		return nil
+	}
+	parent.children = append(parent.children, path.Base(name))
+	slices.Sort(parent.children)
+
+	entry := &archiveEntryFS{}
+	if info != nil {
+		entry.info = *info
+	} else {
+		entry.info.dir = true
+	}
+	entry.info.name = path.Base(name)
+	fsys.entries[name] = entry
+	return entry
+}
+
+func (fsys *archiveFS) Open(name string) (http.File, error) {
+	p := path.Clean("/" + name)
+	if fsys.prefix != "" {
+		if p != fsys.prefix && !strings.HasPrefix(p, fsys.prefix+"/") {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		p = "/" + strings.TrimPrefix(p, fsys.prefix)
+	}
+	key := strings.Trim(p, "/")
+	entry := fsys.entries[key]
+	if entry == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &archiveFile{fsys: fsys, key: key, entry: entry}, nil
+}
+
+// An opened file or directory of an archive.
+type archiveFile struct {
+	fsys  *archiveFS
+	key   string
+	entry *archiveEntryFS
+	// The read position.
+	offset int64
+	// The decompressor of a compressed file, and its position.
+	reader   io.ReadCloser
+	position int64
+	// The position in the children for Readdir.
+	dirOffset int
+}
+
+func (f *archiveFile) Stat() (fs.FileInfo, error) { return &f.entry.info, nil }
+
+func (f *archiveFile) Close() error {
+	if f.reader != nil {
+		return f.reader.Close()
+	}
+	return nil
+}
+
+func (f *archiveFile) Read(p []byte) (int, error) {
+	if f.entry.info.dir {
+		return 0, errors.New("is a directory")
+	} else if f.entry.section != nil {
+		n, err := f.entry.section.ReadAt(p, f.offset)
+		f.offset += int64(n)
+		if err == io.EOF && n > 0 {
+			err = nil
+		}
+		return n, err
+	}
+
+	// Compressed file: restart the decompression if the position is before.
+	if f.reader == nil || f.position > f.offset {
+		if f.reader != nil {
+			f.reader.Close()
+		}
+		reader, err := f.entry.zip.Open()
+		if err != nil {
+			return 0, err
+		}
+		f.reader, f.position = reader, 0
+	}
+	if f.position < f.offset {
+		n, err := io.CopyN(io.Discard, f.reader, f.offset-f.position)
+		f.position += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.reader.Read(p)
+	f.position += int64(n)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *archiveFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.entry.info.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *archiveFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if !f.entry.info.dir {
+		return nil, errors.New("not a directory")
+	}
+	children := f.entry.children[f.dirOffset:]
+	if count > 0 {
+		if len(children) == 0 {
+			return nil, io.EOF
+		}
+		children = children[:min(count, len(children))]
+	}
+	f.dirOffset += len(children)
+
+	infos := make([]fs.FileInfo, len(children))
+	for i, name := range children {
+		infos[i] = &f.fsys.entries[strings.TrimPrefix(f.key+"/"+name, "/")].info
+	}
+	return infos, nil
+}
+
+// The raw deflate content of a zip file, with the CRC-32 of the content.
+// ok is false if the file is not compressed with deflate.
+func (f *archiveFile) rawDeflate() (raw *io.SectionReader, crc uint32, ok bool) {
+	if f.entry.deflate == nil {
+		return nil, 0, false
+	}
+	return f.entry.deflate, f.entry.zip.CRC32, true
+}
+
+// The fs.FileInfo of an archive entry.
+type archiveInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (info *archiveInfo) Name() string       { return info.name }
+func (info *archiveInfo) Size() int64        { return info.size }
+func (info *archiveInfo) ModTime() time.Time { return info.modTime }
+func (info *archiveInfo) IsDir() bool        { return info.dir }
+func (info *archiveInfo) Sys() any           { return nil }
+func (info *archiveInfo) Mode() fs.FileMode {
+	if info.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
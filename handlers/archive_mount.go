@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Serve the files of a zip or a tar (not compressed) archive, like the file
+// handler for a root. The URL path is the path in the archive.
+// The archive is read again when it's modified.
+//
+// The files compressed with deflate are sent without decompression
+// if the client accept the gzip encoding.
+func Archive(logger *slog.Logger, u, cacheControl string, opt Options) http.Handler {
+	theme := loadTheme(logger, opt.Theme)
+	parse := newZipFS
+	if strings.HasSuffix(strings.ToLower(u), ".tar") {
+		parse = newTarFS
+	}
+	return &archiveHandler{
+		path: u,
+		mount: archiveMount{
+			parse: parse,
+			base: &fileHandler{
+				common: common{
+					Logger:       logger,
+					CacheControl: cacheControl,
+					deny:         newDenyPolicy(opt),
+					spa:          newSPAFallback(opt),
+					pages:        newErrorPages(logger, nil, theme, opt),
+					theme:        theme,
+					pageSize:     opt.PageSize,
+					archive:      newArchivePolicy(opt),
+				},
+				etagMode: opt.ETag,
+			},
+		},
+	}
+}
+
+type archiveHandler struct {
+	// Path of the archive file.
+	path  string
+	mount archiveMount
+}
+
+func (hand *archiveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	file, err := os.Open(hand.path)
+	var info fs.FileInfo
+	if err == nil {
+		info, err = file.Stat()
+	}
+	var opened *archiveOpened
+	if err == nil {
+		opened, err = hand.mount.handler(file, info)
+	} else if file != nil {
+		file.Close()
+	}
+	if err != nil {
+		base := hand.mount.base
+		LogRequest(base.Logger.With("err", err.Error()), http.StatusInternalServerError, r)
+		base.servError(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer hand.mount.release(opened)
+	opened.hand.ServeHTTP(w, r)
+}
+
+// Serve a path in a zip file mounted as a directory, return false if the
+// zip file does not exist.
+func (hand *fileHandler) serveMount(w http.ResponseWriter, r *http.Request, p string) bool {
+	file, info, err := open(hand.fsys, p)
+	if err != nil {
+		return false
+	} else if info.IsDir() {
+		file.Close()
+		return false
+	}
+
+	mount := hand.mounts.get(p, func() *archiveMount {
+		common := hand.common
+		common.spa = spaFallback{}
+		return &archiveMount{
+			prefix: p,
+			parse:  newZipFS,
+			base:   &fileHandler{common: common, etagMode: hand.etagMode},
+		}
+	})
+	opened, err := mount.handler(file, info)
+	if err != nil {
+		hand.mounts.remove(mount)
+		LogRequest(hand.Logger.With("err", err.Error()), http.StatusInternalServerError, r)
+		hand.servError(w, r, http.StatusInternalServerError)
+		return true
+	}
+	defer mount.release(opened)
+	opened.hand.serve(w, r)
+	return true
+}
+
+// Maximum number of zip files mounted by each file handler, the least
+// recently used is closed after its last request.
+var MountMaxOpen = 64
+
+// The mounted zip files by URL path, limited to MountMaxOpen.
+type archiveMounts struct {
+	mutex  sync.Mutex
+	lru    list.List
+	mounts map[string]*list.Element
+}
+
+// Get the mount of the URL path p, or add the mount created by newMount.
+func (mounts *archiveMounts) get(p string, newMount func() *archiveMount) *archiveMount {
+	mounts.mutex.Lock()
+	defer mounts.mutex.Unlock()
+	if element := mounts.mounts[p]; element != nil {
+		mounts.lru.MoveToFront(element)
+		return element.Value.(*archiveMount)
+	}
+
+	if mounts.mounts == nil {
+		mounts.mounts = make(map[string]*list.Element)
+	}
+	mount := newMount()
+	mounts.mounts[p] = mounts.lru.PushFront(mount)
+	for mounts.lru.Len() > max(1, MountMaxOpen) {
+		mounts.drop(mounts.lru.Back().Value.(*archiveMount))
+	}
+	return mount
+}
+
+// Remove the mount, for example when its file can not be parsed.
+func (mounts *archiveMounts) remove(mount *archiveMount) {
+	mounts.mutex.Lock()
+	defer mounts.mutex.Unlock()
+	mounts.drop(mount)
+}
+
+func (mounts *archiveMounts) drop(mount *archiveMount) {
+	if element := mounts.mounts[mount.prefix]; element != nil && element.Value == mount {
+		mounts.lru.Remove(element)
+		delete(mounts.mounts, mount.prefix)
+		mount.close()
+	}
+}
+
+// An archive file, parsed again when it's modified.
+type archiveMount struct {
+	// The URL path of the archive, "" for the archive handler.
+	prefix string
+	parse  func(r io.ReaderAt, size int64) (*archiveFS, error)
+	// The handler options, without filesystem.
+	base *fileHandler
+
+	mutex   sync.Mutex
+	modTime time.Time
+	size    int64
+	current *archiveOpened
+	// The mount is removed, its handlers are not kept.
+	closed bool
+}
+
+// A parsed archive, its file is closed after its last request when a newer
+// version replaces it.
+type archiveOpened struct {
+	file http.File
+	hand *fileHandler
+	// The requests in progress, guarded by the mount mutex.
+	refs     int
+	replaced bool
+}
+
+// Get the handler of the opened archive file, to release after the request.
+// If the archive is not modified, the file is closed and the current
+// handler is returned. Else the file is kept open by the new handler.
+func (mount *archiveMount) handler(file http.File, info fs.FileInfo) (*archiveOpened, error) {
+	mount.mutex.Lock()
+	defer mount.mutex.Unlock()
+	if current := mount.current; current != nil && mount.modTime.Equal(info.ModTime()) && mount.size == info.Size() {
+		file.Close()
+		current.refs++
+		return current, nil
+	}
+
+	r, ok := file.(io.ReaderAt)
+	if !ok {
+		r = &seekReaderAt{file: file}
+	}
+	fsys, err := mount.parse(r, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	fsys.prefix = mount.prefix
+
+	opened := &archiveOpened{
+		file: file,
+		hand: &fileHandler{
+			common:   mount.base.common,
+			fsys:     fsys,
+			etagMode: mount.base.etagMode,
+		},
+		refs:     1,
+		replaced: mount.closed,
+	}
+	if !mount.closed {
+		mount.replace()
+		mount.modTime, mount.size = info.ModTime(), info.Size()
+		mount.current = opened
+	}
+	return opened, nil
+}
+
+// Close the mount, the file of the current handler is closed after its last
+// request.
+func (mount *archiveMount) close() {
+	mount.mutex.Lock()
+	defer mount.mutex.Unlock()
+	mount.closed = true
+	mount.replace()
+}
+
+// Replace the current handler, the mutex is locked.
+func (mount *archiveMount) replace() {
+	if old := mount.current; old != nil {
+		old.replaced = true
+		if old.refs == 0 {
+			old.file.Close()
+		}
+	}
+	mount.current = nil
+}
+
+// Release the archive after a request.
+func (mount *archiveMount) release(opened *archiveOpened) {
+	mount.mutex.Lock()
+	defer mount.mutex.Unlock()
+	opened.refs--
+	if opened.replaced && opened.refs == 0 {
+		opened.file.Close()
+	}
+}
+
+// An io.ReaderAt of a file without ReadAt method, the reads are serialized.
+type seekReaderAt struct {
+	mutex sync.Mutex
+	file  io.ReadSeeker
+}
+
+func (r *seekReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, err := r.file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r.file, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Serve the raw deflate content of a zip file with the gzip encoding,
+// without decompression. Return false if the client does not accept gzip.
+// The HTTP deflate encoding is zlib, that needs an Adler-32 checksum unknown
+// from the zip file, so it's not used.
+func (hand *fileHandler) serveDeflate(w http.ResponseWriter, r *http.Request, stat fs.FileInfo, raw *io.SectionReader, crc uint32) bool {
+	if negotiateEncoding(r.Header.Get(headerAcceptEncoding), []string{gzipEncoding}) == "" {
+		return false
+	}
+
+	// A gzip member: header, the deflate content, the CRC-32 and the size.
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	trailer := binary.LittleEndian.AppendUint32(nil, crc)
+	trailer = binary.LittleEndian.AppendUint32(trailer, uint32(stat.Size()))
+	parts := concatReaderAt{
+		io.NewSectionReader(bytes.NewReader(header), 0, int64(len(header))),
+		raw,
+		io.NewSectionReader(bytes.NewReader(trailer), 0, int64(len(trailer))),
+	}
+
+	w.Header().Set(headerContentEncoding, gzipEncoding)
+	if etag := w.Header().Get(headerETag); etag != "" {
+		w.Header().Set(headerETag, encodingETag(etag, gzipEncoding))
+	}
+	http.ServeContent(w, r, stat.Name(), stat.ModTime(), io.NewSectionReader(parts, 0, parts.size()))
+	return true
+}
+
+// An io.ReaderAt of the concatenated sections.
+type concatReaderAt []*io.SectionReader
+
+func (parts concatReaderAt) size() (size int64) {
+	for _, part := range parts {
+		size += part.Size()
+	}
+	return
+}
+
+func (parts concatReaderAt) ReadAt(p []byte, offset int64) (n int, err error) {
+	for _, part := range parts {
+		if n == len(p) {
+			return n, nil
+		} else if offset >= part.Size() {
+			offset -= part.Size()
+			continue
+		}
+		m, err := part.ReadAt(p[n:], offset)
+		n += m
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		offset = 0
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testArchiveModTime = time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)
+
+// The content of the archives, the file "big.txt" is compressible.
+var testArchiveFiles = map[string]string{
+	"index/index.html": "the index",
+	"dir/big.txt":      strings.Repeat("0123456789", 200),
+	"dir/raw.bin":      "raw content",
+	"top.txt":          "top",
+}
+
+// Write a zip file, "raw.bin" is stored, the other files are compressed.
+func testZip(t *testing.T, p string) {
+	buff := bytes.Buffer{}
+	zw := zip.NewWriter(&buff)
+	for _, name := range []string{"dir/", "dir/big.txt", "dir/raw.bin", "index/index.html", "top.txt", "../escape.txt"} {
+		method := zip.Deflate
+		if strings.HasSuffix(name, ".bin") || strings.HasSuffix(name, "/") {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: testArchiveModTime})
+		assert.NoError(t, err)
+		io.WriteString(w, testArchiveFiles[name])
+	}
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, os.WriteFile(p, buff.Bytes(), 0o644))
+}
+
+func testTar(t *testing.T, p string) {
+	buff := bytes.Buffer{}
+	tw := tar.NewWriter(&buff)
+	tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: testArchiveModTime})
+	for _, name := range []string{"dir/big.txt", "dir/raw.bin", "index/index.html", "top.txt"} {
+		content := testArchiveFiles[name]
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content)), ModTime: testArchiveModTime})
+		io.WriteString(tw, content)
+	}
+	tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"})
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, os.WriteFile(p, buff.Bytes(), 0o644))
+}
+
+func TestArchiveHandler(t *testing.T) {
+	dir := t.TempDir()
+	testZip(t, filepath.Join(dir, "docs.zip"))
+	testTar(t, filepath.Join(dir, "docs.tar"))
+
+	for _, name := range []string{"docs.zip", "docs.tar"} {
+		t.Run(name, func(t *testing.T) {
+			logger, _ := testLoggerOne()
+			hand := Archive(logger, filepath.Join(dir, name), "", Options{})
+			serve := func(url string, header ...string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest("GET", "http://example.com"+url, nil)
+				for i := 0; i+1 < len(header); i += 2 {
+					r.Header.Set(header[i], header[i+1])
+				}
+				w := httptest.NewRecorder()
+				hand.ServeHTTP(w, r)
+				return w
+			}
+
+			for p, content := range testArchiveFiles {
+				if strings.HasSuffix(p, "/index.html") {
+					continue
+				}
+				w := serve("/" + p)
+				assert.Equal(t, 200, w.Code, p)
+				assert.Equal(t, content, w.Body.String(), p)
+			}
+			assert.Equal(t, 200, serve("/index/").Code)
+			assert.Equal(t, "the index", serve("/index/").Body.String())
+			assert.Equal(t, 301, serve("/dir").Code)
+			assert.Equal(t, 404, serve("/link").Code)
+			if name == "docs.zip" {
+				// The zip entry "../escape.txt" stays in the root.
+				assert.Equal(t, 200, serve("/escape.txt").Code)
+			}
+
+			w := serve("/dir/")
+			assert.Equal(t, 200, w.Code)
+			assert.Contains(t, w.Body.String(), `<a href="big.txt">big.txt</a>`)
+			assert.Contains(t, w.Body.String(), `<a href="raw.bin">raw.bin</a>`)
+			w = serve("/")
+			assert.Contains(t, w.Body.String(), `<a href="dir/">dir/</a>`)
+
+			w = serve("/dir/big.txt", "Range", "bytes=1005-1009")
+			assert.Equal(t, 206, w.Code)
+			assert.Equal(t, "56789", w.Body.String())
+			assert.Equal(t, testArchiveModTime.Format(http.TimeFormat), w.Header().Get("Last-Modified"))
+		})
+	}
+}
+
+func TestArchiveDeflate(t *testing.T) {
+	dir := t.TempDir()
+	testZip(t, filepath.Join(dir, "docs.zip"))
+	logger, _ := testLoggerOne()
+	hand := Archive(logger, filepath.Join(dir, "docs.zip"), "", Options{})
+	big := testArchiveFiles["dir/big.txt"]
+
+	serve := func(url, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "http://example.com"+url, nil)
+		r.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("/dir/big.txt", "gzip, deflate")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, big, gunzip(t, w.Body.Bytes()))
+
+	w = serve("/dir/big.txt", "deflate")
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, big, w.Body.String())
+
+	w = serve("/dir/big.txt", "br")
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, big, w.Body.String())
+
+	w = serve("/dir/raw.bin", "gzip")
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "raw content", w.Body.String())
+}
+
+func TestArchiveReload(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "docs.zip")
+	testZip(t, p)
+	logger, _ := testLoggerOne()
+	hand := Archive(logger, p, "", Options{})
+
+	serve := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+url, nil))
+		return w
+	}
+	assert.Equal(t, "top", serve("/top.txt").Body.String())
+
+	buff := bytes.Buffer{}
+	zw := zip.NewWriter(&buff)
+	w, _ := zw.Create("top.txt")
+	io.WriteString(w, "new top")
+	zw.Close()
+	assert.NoError(t, os.WriteFile(p, buff.Bytes(), 0o644))
+	os.Chtimes(p, time.Now(), time.Now().Add(time.Minute))
+	assert.Equal(t, "new top", serve("/top.txt").Body.String())
+
+	os.Remove(p)
+	assert.Equal(t, 500, serve("/top.txt").Code)
+}
+
+func TestFileMountZip(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "pub"), 0o755)
+	testZip(t, filepath.Join(root, "pub", "docs.zip"))
+	os.Mkdir(filepath.Join(root, "pub", "dir.zip"), 0o755)
+	os.WriteFile(filepath.Join(root, "pub", "dir.zip", "file.txt"), []byte("in dir"), 0o644)
+
+	logger, _ := testLoggerOne()
+	hand := FileOptions(logger, root, "max-age=60", Options{MountZip: true})
+	serve := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+url, nil))
+		return w
+	}
+
+	w := serve("/pub/docs.zip/dir/raw.bin")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "raw content", w.Body.String())
+	assert.Equal(t, []string{"max-age=60"}, w.Header().Values("Cache-Control"))
+	assert.Equal(t, "the index", serve("/pub/docs.zip/index/").Body.String())
+	assert.Contains(t, serve("/pub/docs.zip/").Body.String(), `<a href="top.txt">top.txt</a>`)
+	assert.Equal(t, 404, serve("/pub/docs.zip/missing").Code)
+	assert.Equal(t, 301, serve("/pub/docs.zip/dir").Code)
+	assert.Equal(t, 200, serve("/pub/docs.zip").Code)
+	assert.Equal(t, "in dir", serve("/pub/dir.zip/file.txt").Body.String())
+
+	hand = FileOptions(logger, root, "", Options{})
+	assert.Equal(t, 404, serve("/pub/docs.zip/top.txt").Code)
+}
+
+func TestFileMountLimit(t *testing.T) {
+	defer func(n int) { MountMaxOpen = n }(MountMaxOpen)
+	MountMaxOpen = 1
+
+	root := t.TempDir()
+	testZip(t, filepath.Join(root, "a.zip"))
+	testZip(t, filepath.Join(root, "b.zip"))
+	os.WriteFile(filepath.Join(root, "bad.zip"), []byte("not a zip"), 0o644)
+
+	logger, _ := testLoggerOne()
+	hand := FileOptions(logger, root, "", Options{MountZip: true}).(*fileHandler)
+	serve := func(url string) int {
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+url, nil))
+		return w.Code
+	}
+
+	assert.Equal(t, 200, serve("/a.zip/top.txt"))
+	first := hand.mounts.mounts["/a.zip"].Value.(*archiveMount)
+	assert.NotNil(t, first.current)
+
+	assert.Equal(t, 200, serve("/b.zip/top.txt"))
+	assert.Equal(t, 1, hand.mounts.lru.Len())
+	assert.Nil(t, hand.mounts.mounts["/a.zip"])
+	assert.True(t, first.closed)
+	assert.Nil(t, first.current)
+
+	assert.Equal(t, 500, serve("/bad.zip/top.txt"))
+	assert.Equal(t, 0, hand.mounts.lru.Len())
+	assert.Empty(t, hand.mounts.mounts)
+}
+
+// A file counting its Close calls.
+type testCloseFile struct {
+	*os.File
+	closed *int
+}
+
+func (f testCloseFile) Close() error {
+	*f.closed++
+	return f.File.Close()
+}
+
+func TestArchiveMountClose(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "docs.zip")
+	testZip(t, p)
+	mount := &archiveMount{parse: newZipFS, base: &fileHandler{}}
+	closed := [3]int{}
+	open := func(i int) (*archiveOpened, error) {
+		file, err := os.Open(p)
+		assert.NoError(t, err)
+		info, _ := file.Stat()
+		return mount.handler(testCloseFile{file, &closed[i]}, info)
+	}
+
+	first, err := open(0)
+	assert.NoError(t, err)
+	os.Chtimes(p, time.Now(), time.Now().Add(time.Minute))
+	second, err := open(1)
+	assert.NoError(t, err)
+	assert.Equal(t, [3]int{0, 0, 0}, closed, "the first is in use")
+	mount.release(first)
+	assert.Equal(t, [3]int{1, 0, 0}, closed)
+
+	same, err := open(2)
+	assert.NoError(t, err)
+	assert.Same(t, second, same)
+	assert.Equal(t, [3]int{1, 0, 1}, closed)
+	mount.release(second)
+	mount.release(same)
+	assert.Equal(t, [3]int{1, 0, 1}, closed, "the current is kept")
+
+	// A closed mount closes its files after their last request.
+	last, err := open(0)
+	assert.NoError(t, err)
+	assert.Same(t, second, last)
+	mount.close()
+	assert.Equal(t, [3]int{2, 0, 1}, closed, "the current is in use")
+	mount.release(last)
+	assert.Equal(t, [3]int{2, 1, 1}, closed)
+
+	os.Chtimes(p, time.Now(), time.Now().Add(2*time.Minute))
+	last, err = open(0)
+	assert.NoError(t, err)
+	assert.Nil(t, mount.current)
+	mount.release(last)
+	assert.Equal(t, [3]int{3, 1, 1}, closed)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchive(t *testing.T) {
+	logger, _ := testLoggerOne()
+	opt := Options{Deny: []string{"*.bak", "*~"}, Archive: true}
+	cache := &cacheHandler{common: common{Logger: logger, deny: newDenyPolicy(opt), archive: newArchivePolicy(opt)}}
+	cache.Update(testDenyFS, time.Now())
+	handlers := map[string]http.Handler{
+		"file": &fileHandler{
+			common: common{Logger: logger, deny: newDenyPolicy(opt), archive: newArchivePolicy(opt)},
+			fsys:   http.FS(testDenyFS),
+		},
+		"cache": cache,
+	}
+
+	expected := map[string]string{
+		"root/.well-known/":         "",
+		"root/.well-known/security": "contact",
+		"root/file.txt":             "file",
+		"root/public/":              "",
+	}
+
+	for name, hand := range handlers {
+		t.Run(name, func(t *testing.T) {
+			serve := func(url string) *httptest.ResponseRecorder {
+				w := httptest.NewRecorder()
+				hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+url, nil))
+				return w
+			}
+
+			w := serve("/?download=zip")
+			assert.Equal(t, 200, w.Code)
+			assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+			assert.Equal(t, "attachment; filename=root.zip", w.Header().Get("Content-Disposition"))
+			zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+			if !assert.NoError(t, err) {
+				return
+			}
+			files := make(map[string]string)
+			for _, f := range zr.File {
+				r, err := f.Open()
+				assert.NoError(t, err)
+				data, _ := io.ReadAll(r)
+				files[f.Name] = string(data)
+			}
+			assert.Equal(t, expected, files)
+
+			w = serve("/?download=tar.gz")
+			assert.Equal(t, 200, w.Code)
+			assert.Equal(t, "application/gzip", w.Header().Get("Content-Type"))
+			assert.Equal(t, "attachment; filename=root.tar.gz", w.Header().Get("Content-Disposition"))
+			gz, err := gzip.NewReader(w.Body)
+			if !assert.NoError(t, err) {
+				return
+			}
+			tr := tar.NewReader(gz)
+			files = make(map[string]string)
+			for {
+				header, err := tr.Next()
+				if err == io.EOF || !assert.NoError(t, err) {
+					break
+				}
+				assert.Equal(t, "", header.Uname)
+				data, _ := io.ReadAll(tr)
+				files[header.Name] = string(data)
+			}
+			assert.Equal(t, expected, files)
+
+			w = serve("/public/?download=zip")
+			assert.Equal(t, 200, w.Code)
+			assert.Equal(t, "attachment; filename=public.zip", w.Header().Get("Content-Disposition"))
+
+			w = serve("/?download=rar")
+			assert.Equal(t, 200, w.Code)
+			assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+			assert.Contains(t, w.Body.String(), `<a href="?download=zip">zip</a>`)
+		})
+	}
+}
+
+func TestArchivePolicy(t *testing.T) {
+	logger, log := testLoggerOne()
+	hand := &fileHandler{
+		common: common{Logger: logger, archive: newArchivePolicy(Options{Archive: true, ArchiveMax: 10})},
+		fsys:   http.FS(testDenyFS),
+	}
+	w := httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/?download=zip", nil))
+	assert.Equal(t, 413, w.Code)
+	assert.Contains(t, log.String(), "s=413")
+
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/.well-known/?download=zip", nil))
+	assert.Equal(t, 200, w.Code)
+
+	hand.archive = newArchivePolicy(Options{})
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/?download=zip", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.NotContains(t, w.Body.String(), "download=")
+}
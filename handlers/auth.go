@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The users of the basic authentication, with the bcrypt hash of their
+// password.
+type basicUsers struct {
+	hashes map[string][]byte
+	// A hash of a random password with the highest cost of the users, checked
+	// for the unknown users to answer in the same time.
+	dummy []byte
+
+	// The checked credentials, by their HMAC with key, to run bcrypt
+	// only once by user and password.
+	key      [32]byte
+	verified sync.Map
+}
+
+// Check the users password hashes, the invalid ones are ignored.
+func newBasicUsers(logger *slog.Logger, users map[string]string) *basicUsers {
+	basic := &basicUsers{hashes: make(map[string][]byte, len(users))}
+	rand.Read(basic.key[:])
+	dummyCost := 0
+	for user, hash := range users {
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			logger.Warn("user-invalid", "user", user, "err", err.Error())
+			continue
+		}
+		basic.hashes[user] = []byte(hash)
+		dummyCost = max(dummyCost, cost)
+	}
+	if dummyCost > 0 {
+		password := make([]byte, 32)
+		rand.Read(password)
+		basic.dummy, _ = bcrypt.GenerateFromPassword(password, dummyCost)
+	}
+	return basic
+}
+
+// Test the basic authentication of the request.
+func (users *basicUsers) authorized(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok || len(users.hashes) == 0 {
+		return false
+	}
+	hash, exists := users.hashes[user]
+	if !exists {
+		bcrypt.CompareHashAndPassword(users.dummy, []byte(password))
+		return false
+	}
+
+	mac := hmac.New(sha256.New, users.key[:])
+	mac.Write([]byte(user))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	key := string(mac.Sum(nil))
+	if _, ok := users.verified.Load(key); ok {
+		return true
+	} else if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+	users.verified.Store(key, struct{}{})
+	return true
+}
+
+// Ask the basic authentication.
+func (hand *common) serveUnauthorized(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="servHTTP", charset="UTF-8"`)
+	hand.serveStatus(w, r, http.StatusUnauthorized)
+}
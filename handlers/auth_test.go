@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicUsers(t *testing.T) {
+	logger, log := testLoggerOne()
+	users := testWebDAVUsers()
+	users["bob"] = "sha256:5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8"
+	basic := newBasicUsers(logger, users)
+	assert.Len(t, basic.hashes, 1)
+	assert.Contains(t, log.String(), `msg=user-invalid user=bob`)
+
+	tf := func(user, password string) bool {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.SetBasicAuth(user, password)
+		return basic.authorized(r)
+	}
+	assert.True(t, tf("alice", "secret"))
+	assert.True(t, tf("alice", "secret"))
+	assert.False(t, tf("alice", "wrong"))
+	assert.False(t, tf("bob", "password"))
+	assert.False(t, basic.authorized(httptest.NewRequest("GET", "http://example.com/", nil)))
+
+	// The unknown users are checked with a dummy hash of the same cost.
+	cost, err := bcrypt.Cost(basic.dummy)
+	assert.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost, cost)
+	assert.False(t, tf("carol", ""))
+	assert.False(t, newBasicUsers(logger, nil).authorized(httptest.NewRequest("GET", "http://example.com/", nil)))
+}
@@ -2,26 +2,110 @@ package handlers
 
 import (
 	"bytes"
-	"compress/flate"
+	"container/list"
 	"crypto/sha256"
+	"embed"
 	"encoding/base64"
+	"errors"
 	"io/fs"
 	"log/slog"
+	"maps"
 	"mime"
 	"net/http"
 	"os"
 	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-	"unicode"
 
 	"github.com/HuguesGuilleus/servHTTP/handlers/template"
 )
 
 type cacheHandler struct {
 	common
+
+	// The current state, replaced on each update.
+	snapshot atomic.Pointer[cacheSnapshot]
+	// Only one update at the same time.
+	updateMutex sync.Mutex
+
+	// Memory budget of the file contents, zero for no limit.
+	maxMemory int64
+	// Bigger files are served from the filesystem, zero for no limit.
+	maxFileSize int64
+	// Save the ETags and the compressed versions, can be nil.
+	persist *cachePersist
+	// Serve the files before the first update, can be nil.
+	fallback http.Handler
+	// Size of the file contents in memory of the published snapshot
+	// (indexes excluded).
+	memory atomic.Int64
+	// Guard the loaded contents, the live flags and the LRU list.
+	memoryMutex sync.Mutex
+	// The live loaded files, the least recently used at the back.
+	// Only used with a memory budget.
+	lru    list.List
+	hits   atomic.Int64
+	misses atomic.Int64
+
+	statsMutex sync.Mutex
+	lastUpdate time.Duration
+	lastError  error
+}
+
+// An immutable state of the cache.
+type cacheSnapshot struct {
+	// The filesystem used to read evicted or big files.
+	fsys  fs.FS
 	files map[string]*cacheFile
+	// The entries of each directory, used to regenerate the indexes.
+	dirs map[string][]fs.FileInfo
+	// Size of the generated indexes
+	indexBytes int64
+}
+
+func newCacheSnapshot(files map[string]*cacheFile, dirs map[string][]fs.FileInfo) *cacheSnapshot {
+	snapshot := &cacheSnapshot{files: files, dirs: dirs}
+	for _, file := range files {
+		if content := file.content.Load(); content != nil && file.path == "" {
+			snapshot.indexBytes += content.size()
+		}
+	}
+	return snapshot
+}
+
+// Statistics of a cache handler.
+type CacheStats struct {
+	// Number of files and directories.
+	Files int
+	// Size in memory of the contents, identity and compressed.
+	Bytes int64
+	// Number of requests served from memory.
+	Hits int64
+	// Number of requests that read an evicted file.
+	Misses int64
+	// Duration of the last update.
+	LastUpdate time.Duration
+	// The error of the last update, nil if success.
+	LastError error
+}
+
+// Get statistics of the cache.
+func (hand *cacheHandler) Stats() (stats CacheStats) {
+	if snapshot := hand.snapshot.Load(); snapshot != nil {
+		stats.Files = len(snapshot.files)
+		stats.Bytes = snapshot.indexBytes + hand.memory.Load()
+	}
+	stats.Hits = hand.hits.Load()
+	stats.Misses = hand.misses.Load()
+	hand.statsMutex.Lock()
+	defer hand.statsMutex.Unlock()
+	stats.LastUpdate = hand.lastUpdate
+	stats.LastError = hand.lastError
+	return
 }
 
 type cacheFile struct {
@@ -38,155 +122,598 @@ type cacheFile struct {
 	// Etag (sha256sum)
 	etag string
 
+	// Path in the filesystem, "" for a generated index.
+	path string
+	// The data of a generated index, for the other formats than HTML.
+	index *template.IndexData
+	// The file is bigger than the max size, it's served from the filesystem.
+	disk bool
+	// Key of the saved compressed versions, "" if no persistence.
+	persistKey string
+
+	// The content, nil if evicted or on disk.
+	content atomic.Pointer[cacheContent]
+	// The file is in the published snapshot, so its content is counted in
+	// the handler memory. Guarded by the handler memoryMutex.
+	live bool
+	// The element in the handler LRU list, nil if not loaded or not live.
+	lru *list.Element
+	// Only one read of an evicted file at the same time.
+	loadMutex sync.Mutex
+}
+
+type cacheContent struct {
 	// Identity content without compression
-	identityBytes []byte
-	identityLen   string
-	// Compressed version with "deflate"
-	// May be nil if it bigger than no compression.
-	deflateBytes []byte
-	deflateLen   string
+	identity []byte
+	// Compressed versions, ordered by server preference.
+	// Only the encodings smaller than identity are kept.
+	encoded []cacheEncoded
+}
+
+type cacheEncoded struct {
+	// The Content-Encoding name
+	encoding string
+	bytes    []byte
 }
 
 func (hand *cacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if hand.Serve(w, r) {
+	snapshot := hand.snapshot.Load()
+	if snapshot == nil && hand.fallback != nil {
+		hand.fallback.ServeHTTP(w, r)
+		return
+	} else if hand.Serve(w, r) {
 		return
 	}
 
-	file := hand.files[strings.TrimPrefix(path.Clean(r.URL.Path), "/")]
+	var file *cacheFile
+	if snapshot != nil {
+		file = snapshot.files[strings.TrimPrefix(path.Clean(r.URL.Path), "/")]
+	}
+	fallback := false
+	if file == nil && snapshot != nil && hand.spa.match(r.URL.Path) {
+		// A generated index is not a fallback.
+		if file = snapshot.files[hand.spa.cacheKey()]; file != nil && file.path != "" {
+			fallback = true
+			hand.spa.setCacheControl(w)
+		} else {
+			file = nil
+		}
+	}
 	if file == nil {
 		LogRequest(hand.Logger, http.StatusNotFound, r)
-		servHTML(w, http.StatusNotFound, template.Error404(r.URL.Path))
+		hand.servError(w, r, http.StatusNotFound)
 		return
-	} else if hand.endSlash(w, r, file.isDir) {
+	} else if !fallback && hand.endSlash(w, r, file.isDir) {
+		return
+	}
+
+	if !fallback && file.isDir && hand.archive.format(r) != "" {
+		hand.serveArchive(w, r, http.FS(snapshot.fsys), r.URL.Path)
+		return
+	}
+
+	if file.index != nil {
+		// Only the first page of the default sort in HTML is precomputed.
+		w.Header().Add(headerVary, headerAccept)
+		query := indexQuery(r, hand.pageSize)
+		if indexFormat(r) != IndexFormatHTML || query != (template.IndexQuery{Page: 1, PageSize: hand.pageSize}) {
+			hand.serveIndex(w, r, file.index.Query(query))
+			return
+		}
+	}
+
+	if file.disk {
+		hand.serveDisk(w, r, snapshot.fsys, file)
+		return
+	}
+	content, err := hand.load(snapshot.fsys, file)
+	if err != nil {
+		LogRequest(hand.Logger.With("err", err.Error()), http.StatusInternalServerError, r)
+		hand.servError(w, r, http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Add(headerContentType, file.contentType)
 	w.Header().Add(headerLastModified, file.modString)
 	w.Header().Add(headerETag, file.etag)
-	if file.etag == r.Header.Get(headerIfNoneMatch) {
-		LogRequest(hand.Logger, http.StatusNotModified, r)
-		w.WriteHeader(http.StatusNotModified)
-	} else if len(file.deflateBytes) > 0 && acceptDeflate(r.Header.Get(headerAcceptEncoding)) {
-		LogRequest(hand.Logger, http.StatusOK, r)
-		w.Header().Add(headerContentEncoding, deflateEncoding)
-		w.Header().Add(headerContentLength, file.deflateLen)
-		w.Write(file.deflateBytes)
-	} else {
-		LogRequest(hand.Logger, http.StatusOK, r)
-		w.Header().Add(headerContentLength, file.identityLen)
-		w.Write(file.identityBytes)
+	w.Header().Add(headerVary, headerAcceptEncoding)
+
+	// Range are always on identity content.
+	body := content.identity
+	if r.Header.Get(headerRange) == "" {
+		if encoded := content.negotiate(r.Header.Get(headerAcceptEncoding)); encoded != nil {
+			// http.ServeContent does not set the length of encoded content.
+			w.Header().Add(headerContentEncoding, encoded.encoding)
+			w.Header().Add(headerContentLength, strconv.Itoa(len(encoded.bytes)))
+			w.Header().Set(headerETag, encodingETag(file.etag, encoded.encoding))
+			body = encoded.bytes
+		}
 	}
+
+	sw := &statusWriter{ResponseWriter: w}
+	http.ServeContent(sw, r, "", file.modTime, bytes.NewReader(body))
+	LogRequest(hand.Logger, sw.status, r)
 }
 
-func acceptDeflate(header string) bool {
-	values := strings.FieldsFunc(header, func(r rune) bool { return !unicode.IsLetter(r) })
-	for _, v := range values {
-		if v == deflateEncoding {
-			return true
+// Get the best encoded version for the Accept-Encoding header,
+// or nil for identity.
+func (content *cacheContent) negotiate(acceptEncoding string) *cacheEncoded {
+	available := make([]string, len(content.encoded))
+	for i, encoded := range content.encoded {
+		available[i] = encoded.encoding
+	}
+	encoding := negotiateEncoding(acceptEncoding, available)
+	for i := range content.encoded {
+		if content.encoded[i].encoding == encoding {
+			return &content.encoded[i]
 		}
 	}
-	return false
+	return nil
+}
+
+// Size in memory of all the versions.
+func (content *cacheContent) size() (size int64) {
+	size = int64(len(content.identity))
+	for _, encoded := range content.encoded {
+		size += int64(len(encoded.bytes))
+	}
+	return
 }
 
-// Create a file handler without memory copy of file.
-// All 20 seconds, update the index.
+const (
+	cacheDefaultInterval = 20 * time.Second
+	// Wait time after a filesystem event before update the cache.
+	cacheDebounce = 50 * time.Millisecond
+	// Maximal wait time after the first pending event, for the directories
+	// with continuous writes.
+	cacheDebounceMax = 500 * time.Millisecond
+)
+
+// Create a cache handler of root with the default options, see CacheOptions.
 func Cache(logger *slog.Logger, root, cacheControl string) http.Handler {
+	return CacheOptions(logger, root, cacheControl, Options{})
+}
+
+// Create a file handler with a memory copy of all files.
+// On Linux, the cache is updated on filesystem events,
+// else all interval (default 20 seconds) the cache is updated.
+// Before the first update, the files are served from the filesystem.
+//
+// The returned handler has a method Stats() CacheStats.
+func CacheOptions(logger *slog.Logger, root, cacheControl string, opt Options) http.Handler {
+	fsys := unionRootFS(root, opt)
+	roots := append([]string{root}, opt.Under...)
+	hand := newCacheHandler(logger, fsys, cacheControl, opt, newCachePersist(logger, opt.CacheDir, roots))
+	cacheRegister(hand, fsys, roots)
+	go hand.run(fsys, roots, cacheInterval(opt))
+	return hand
+}
+
+// Create a cache handler of fsys, for example an embed.FS.
+// The cache is updated all interval (default 20 seconds),
+// the unmodified files are not read again. An embed.FS is immutable,
+// so it is scanned only once, like a fs.FS with the option Static.
+// The options Symlinks and Under are ignored. Each handler has its entries
+// in CacheDir, see the option CacheName.
+//
+// The returned handler has a method Stats() CacheStats.
+func CacheFS(logger *slog.Logger, fsys fs.FS, cacheControl string, opt Options) http.Handler {
+	hand := newCacheHandler(logger, fsys, cacheControl, opt, newCachePersistFS(logger, opt.CacheDir, opt.CacheName))
+	interval := cacheInterval(opt)
+	if _, static := fsys.(embed.FS); static || opt.Static {
+		interval = -1
+	}
+	go hand.run(fsys, nil, interval)
+	return hand
+}
+
+// Get the update interval, negative for no update.
+func cacheInterval(opt Options) time.Duration {
+	if opt.Interval == 0 {
+		return cacheDefaultInterval
+	}
+	return opt.Interval
+}
+
+// Create a cache handler with its persistence.
+func newCacheHandler(logger *slog.Logger, fsys fs.FS, cacheControl string, opt Options, persist *cachePersist) *cacheHandler {
 	hand := new(cacheHandler)
 	hand.Logger = logger
 	hand.CacheControl = cacheControl
-	hand.files = make(map[string]*cacheFile)
+	hand.deny = newDenyPolicy(opt)
+	hand.spa = newSPAFallback(opt)
+	hand.theme = loadTheme(logger, opt.Theme)
+	hand.pageSize = opt.PageSize
+	hand.archive = newArchivePolicy(opt)
+	hand.pages = newErrorPages(logger, fsys, hand.theme, opt)
+	hand.maxMemory = opt.MaxMemory
+	hand.maxFileSize = opt.MaxFileSize
+	hand.persist = persist
+
+	hand.fallback = &fileHandler{common: hand.common, fsys: http.FS(fsys)}
+	return hand
+}
 
-	go func() {
-		fsys := os.DirFS(root)
-		hand.Update(fsys, time.Now())
-		for now := range time.Tick(time.Second * 20) {
-			hand.Update(fsys, now)
+// Update the cache with filesystem events of the roots directories.
+// If the watch fail or without roots, update all interval, or only once
+// with a negative interval.
+func (hand *cacheHandler) run(fsys fs.FS, roots []string, interval time.Duration) {
+	if len(roots) > 0 {
+		if err := hand.watch(fsys, roots); !errors.Is(err, errors.ErrUnsupported) {
+			hand.Logger.Warn("cache-watch-fail", "root", roots[0], "err", err.Error())
 		}
-	}()
+	}
 
-	return hand
+	hand.Update(fsys, time.Now())
+	if interval < 0 {
+		return
+	}
+	for now := range time.Tick(interval) {
+		hand.Update(fsys, now)
+	}
 }
 
+// Scan all the filesystem and update the cache.
 func (hand *cacheHandler) Update(fsys fs.FS, now time.Time) {
-	now = now.UTC()
-	newFiles := make(map[string]*cacheFile, len(hand.files))
-	needIndex := make(map[string]struct{})
-	dirs := make(map[string][]fs.FileInfo)
+	hand.updateMutex.Lock()
+	defer hand.updateMutex.Unlock()
+	begin := time.Now()
 
-	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+	old := hand.snapshot.Load()
+	if old == nil {
+		old = &cacheSnapshot{}
+	}
+	scan := cacheScan{
+		fsys:        fsys,
+		now:         now.UTC(),
+		maxMemory:   hand.maxMemory,
+		maxFileSize: hand.maxFileSize,
+		deny:        &hand.deny,
+		theme:       hand.theme,
+		pageSize:    hand.pageSize,
+		archive:     hand.archive.enabled,
+		persist:     hand.persist,
+		persistKeys: make(map[string]bool),
+		old:         old.files,
+		files:       make(map[string]*cacheFile, len(old.files)),
+		dirs:        make(map[string][]fs.FileInfo),
+	}
+	err := scan.dir("", true)
+	hand.publish(&scan, begin, err)
+	if err == nil {
+		hand.persist.clean(scan.persistKeys)
+	}
+}
+
+// Update only the directories (not recursively), and the new sub directories.
+// The keys are the directory path without leading slash, "" for the root.
+func (hand *cacheHandler) updateDirs(fsys fs.FS, keys []string, now time.Time) {
+	old := hand.snapshot.Load()
+	if old == nil || old.dirs == nil {
+		hand.Update(fsys, now)
+		return
+	}
+
+	hand.updateMutex.Lock()
+	defer hand.updateMutex.Unlock()
+	begin := time.Now()
+
+	old = hand.snapshot.Load()
+	scan := cacheScan{
+		fsys:        fsys,
+		now:         now.UTC(),
+		maxMemory:   hand.maxMemory,
+		maxFileSize: hand.maxFileSize,
+		memory:      hand.memory.Load(),
+		deny:        &hand.deny,
+		theme:       hand.theme,
+		pageSize:    hand.pageSize,
+		archive:     hand.archive.enabled,
+		persist:     hand.persist,
+		persistKeys: make(map[string]bool),
+		old:         old.files,
+		files:       maps.Clone(old.files),
+		dirs:        maps.Clone(old.dirs),
+	}
+	var err error
+	for _, key := range keys {
+		if err = scan.dir(key, false); errors.Is(err, fs.ErrNotExist) {
+			scan.remove(key)
+			err = nil
+		} else if err != nil {
+			break
+		}
+	}
+	hand.publish(&scan, begin, err)
+}
+
+// Save the stats and publish the scan result if no error.
+func (hand *cacheHandler) publish(scan *cacheScan, begin time.Time, err error) {
+	if err == nil {
+		snapshot := newCacheSnapshot(scan.files, scan.dirs)
+		snapshot.fsys = scan.fsys
+		hand.memoryMutex.Lock()
+		if old := hand.snapshot.Load(); old != nil {
+			for key, file := range old.files {
+				if scan.files[key] != file {
+					hand.detach(file)
+				}
+			}
+		}
+		for _, file := range scan.files {
+			hand.attach(file)
+		}
+		hand.snapshot.Store(snapshot)
+		hand.evict()
+		hand.memoryMutex.Unlock()
+	} else {
+		hand.Logger.Warn("cache-update-fail", "err", err.Error())
+	}
+
+	hand.statsMutex.Lock()
+	defer hand.statsMutex.Unlock()
+	hand.lastUpdate = time.Since(begin)
+	hand.lastError = err
+}
+
+// A scan of the filesystem, the keys are the path without leading slash.
+type cacheScan struct {
+	fsys fs.FS
+	now  time.Time
+	deny *denyPolicy
+	// The theme, the page size and the archive links of the generated indexes.
+	theme    template.Theme
+	pageSize int
+	archive  bool
+
+	maxMemory   int64
+	maxFileSize int64
+	// Estimated size of the loaded file contents, to not load the files over
+	// the memory budget.
+	memory int64
+
+	persist *cachePersist
+	// The used persist keys.
+	persistKeys map[string]bool
+
+	// Files before the scan, to reuse unmodified files.
+	old map[string]*cacheFile
+	// Result of the scan
+	files map[string]*cacheFile
+	// The entries of each directory, used to generate the indexes.
+	dirs map[string][]fs.FileInfo
+}
+
+func cacheKeyJoin(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+func cacheKeyPath(key string) string {
+	if key == "" {
+		return "."
+	}
+	return key
+}
+
+// Scan a directory. Scan the sub directories if recursive or if there are new.
+func (scan *cacheScan) dir(key string, recursive bool) error {
+	entries, err := fs.ReadDir(scan.fsys, cacheKeyPath(key))
+	if err != nil {
+		return err
+	}
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if scan.deny.denied(cacheKeyJoin(key, entry.Name())) {
+			continue
 		}
-		info, err := d.Info()
+		info, err := entry.Info()
 		if err != nil {
 			return err
+		} else if info.Mode()&fs.ModeSymlink != 0 {
+			// Skip the broken links, the links out of the policy and the
+			// links to a parent directory, that make a loop.
+			info, err = fs.Stat(scan.fsys, cacheKeyJoin(key, entry.Name()))
+			if err != nil || info.IsDir() && scan.loop(key, info) {
+				continue
+			}
 		}
+		infos = append(infos, info)
+	}
 
-		dir := path.Dir(p)
-		dirs[dir] = append(dirs[dir], info)
-		exactPath := p
-		isIndex := false
+	// Remove deleted entries
+	oldDirs := make(map[string]bool)
+	for _, old := range scan.dirs[key] {
+		if old.IsDir() {
+			oldDirs[old.Name()] = true
+		}
+		if !slices.ContainsFunc(infos, func(info fs.FileInfo) bool {
+			return info.Name() == old.Name() && info.IsDir() == old.IsDir()
+		}) {
+			scan.remove(cacheKeyJoin(key, old.Name()))
+		}
+	}
+	scan.dirs[key] = infos
+
+	hasIndex := false
+	for _, info := range infos {
+		p := cacheKeyJoin(key, info.Name())
 		if info.IsDir() {
-			needIndex[p] = struct{}{}
-			return nil
-		} else if info.Name() == "index.html" {
-			p = dir
-			delete(needIndex, p)
-			isIndex = true
+			if recursive || !oldDirs[info.Name()] {
+				if err := scan.dir(p, true); err != nil {
+					return err
+				}
+			}
+			continue
 		}
 
-		oldFile := hand.files[p]
-		if oldFile != nil && oldFile.modTime.Equal(info.ModTime()) {
-			newFiles[p] = oldFile
-		} else {
-			content, err := fs.ReadFile(fsys, exactPath)
-			if err != nil {
+		isIndex := info.Name() == "index.html"
+		if isIndex {
+			hasIndex = true
+			if err := scan.file(key, p, info, true); err != nil {
 				return err
 			}
-			newFiles[p] = newCacheFile(content, info.Name(), isIndex, info.ModTime())
+		} else if err := scan.file(p, p, info, false); err != nil {
+			return err
+		}
+	}
+
+	if !hasIndex {
+		data := template.NewIndexData("/"+cacheKeyJoin(key, ""), infos)
+		if data.Readme != "" {
+			if readme, err := scan.fsys.Open(cacheKeyPath(cacheKeyJoin(key, data.Readme))); err == nil {
+				data.ReadmeHTML = readReadme(readme, data.Readme)
+				readme.Close()
+			}
+		}
+		data.Archive = scan.archive
+		page := data
+		if scan.pageSize > 0 {
+			page = data.Query(template.IndexQuery{PageSize: scan.pageSize})
+		}
+		index, err := renderIndex(scan.theme, page)
+		if err != nil {
+			return err
+		}
+		file := newCacheFile(index, "index.html", true, scan.now)
+		file.index = data
+		scan.files[key] = file
+	}
+
+	return nil
+}
+
+// Test if the directory info is the directory key or one of its parents.
+func (scan *cacheScan) loop(key string, info fs.FileInfo) bool {
+	for {
+		parent, err := fs.Stat(scan.fsys, cacheKeyPath(key))
+		if err != nil || os.SameFile(parent, info) {
+			return true
+		} else if key == "" {
+			return false
 		}
+		if i := strings.LastIndexByte(key, '/'); i >= 0 {
+			key = key[:i]
+		} else {
+			key = ""
+		}
+	}
+}
 
+// Read the file if it's new or modified.
+func (scan *cacheScan) file(key, p string, info fs.FileInfo, isIndex bool) error {
+	old := scan.old[key]
+	if old != nil && old.modTime.Equal(info.ModTime()) && old.isDir == isIndex && old.path == p {
+		scan.persistKeys[old.persistKey] = true
+		if scan.files[key] != old {
+			scan.files[key] = old
+			scan.memory += old.memory()
+		}
 		return nil
-	})
-	if err != nil {
-		hand.Logger.Warn("cache-update-fail", "err", err.Error())
-		return
+	} else if old != nil && scan.files[key] == old {
+		scan.memory -= old.memory()
 	}
 
-	for p := range needIndex {
-		newFiles[p] = newCacheFile(template.Index(p+"/", dirs[p]), htmlMIME, true, now)
+	persistKey := scan.persist.key(scan.fsys, p, info)
+	scan.persistKeys[persistKey] = true
+	entry := scan.persist.load(persistKey)
+
+	// The files bigger than the memory budget are served from the filesystem,
+	// the files over the remaining budget are loaded on use.
+	disk := scan.maxFileSize > 0 && info.Size() > scan.maxFileSize ||
+		scan.maxMemory > 0 && info.Size() > scan.maxMemory
+	if disk || scan.maxMemory > 0 && scan.memory+info.Size() > scan.maxMemory {
+		file, err := newCacheDiskFile(scan.fsys, p, info, isIndex, entry)
+		if err != nil {
+			return err
+		}
+		if entry == nil && disk {
+			scan.persist.save(persistKey, file.etag, nil)
+		}
+		file.disk = disk
+		file.persistKey = persistKey
+		scan.files[key] = file
+		return nil
 	}
 
-	newFiles[""] = newFiles["."]
-	delete(newFiles, ".")
+	data, err := fs.ReadFile(scan.fsys, p)
+	if err != nil {
+		return err
+	}
+	var file *cacheFile
+	if entry != nil {
+		file = newCacheFileMeta(info.Name(), isIndex, info.ModTime())
+		file.etag = entry.ETag
+		file.content.Store(entry.content(data))
+	} else {
+		file = newCacheFile(data, info.Name(), isIndex, info.ModTime())
+		scan.persist.save(persistKey, file.etag, file.content.Load())
+	}
+	file.path = p
+	file.persistKey = persistKey
+	scan.memory += file.memory()
+	scan.files[key] = file
+	return nil
+}
 
-	hand.files = newFiles
+// Remove the file or directory and all its sub files.
+func (scan *cacheScan) remove(key string) {
+	prefix := key + "/"
+	maps.DeleteFunc(scan.files, func(k string, file *cacheFile) bool {
+		if k == key || strings.HasPrefix(k, prefix) {
+			scan.memory -= file.memory()
+			return true
+		}
+		return false
+	})
+	delete(scan.dirs, key)
+	maps.DeleteFunc(scan.dirs, func(k string, _ []fs.FileInfo) bool { return strings.HasPrefix(k, prefix) })
 }
 
 func newCacheFile(content []byte, name string, isDir bool, lastModified time.Time) (file *cacheFile) {
+	file = newCacheFileMeta(name, isDir, lastModified)
+
+	hash := sha256.Sum256(content)
+	file.etag = cacheETag(hash[:])
+
+	file.content.Store(newCacheContent(content))
+
+	return
+}
+
+// Create a file without content and ETag.
+func newCacheFileMeta(name string, isDir bool, lastModified time.Time) (file *cacheFile) {
 	file = new(cacheFile)
 	file.isDir = isDir
 	file.contentType = mime.TypeByExtension(path.Ext(name))
 
 	lastModified = lastModified.UTC()
 	file.modTime = lastModified
-	file.modString = lastModified.Format(time.RFC1123)
+	file.modString = lastModified.Format(http.TimeFormat)
 
-	hash := sha256.Sum256(content)
-	file.etag = "\"" + base64.RawURLEncoding.EncodeToString(hash[:]) + "\""
+	return
+}
 
-	file.identityBytes = content
-	file.identityLen = strconv.Itoa(len(content))
+func cacheETag(hash []byte) string {
+	return "\"" + base64.RawURLEncoding.EncodeToString(hash) + "\""
+}
 
-	buff := bytes.Buffer{}
-	enc, _ := flate.NewWriter(&buff, flate.BestCompression)
-	enc.Write(content)
-	enc.Close()
-	if buff.Len() < len(content) {
-		file.deflateBytes = buff.Bytes()
-		file.deflateLen = strconv.Itoa(buff.Len())
+// Compress the content with all the Encoders.
+func newCacheContent(data []byte) *cacheContent {
+	content := &cacheContent{identity: data}
+	for _, encoder := range Encoders {
+		buff := bytes.Buffer{}
+		enc := encoder.New(&buff)
+		enc.Write(data)
+		enc.Close()
+		if buff.Len() < len(data) {
+			content.encoded = append(content.encoded, cacheEncoded{
+				encoding: encoder.Name,
+				bytes:    buff.Bytes(),
+			})
+		}
 	}
-
-	return
+	return content
 }
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+)
+
+// Size of the content in memory, zero for indexes, on disk or evicted files.
+func (file *cacheFile) memory() int64 {
+	if file.path == "" {
+		return 0
+	} else if content := file.content.Load(); content != nil {
+		return content.size()
+	}
+	return 0
+}
+
+// Get the content of the file, read it from the filesystem if evicted.
+func (hand *cacheHandler) load(fsys fs.FS, file *cacheFile) (*cacheContent, error) {
+	if content := file.content.Load(); content != nil {
+		hand.hits.Add(1)
+		hand.touch(file)
+		return content, nil
+	}
+
+	file.loadMutex.Lock()
+	defer file.loadMutex.Unlock()
+	if content := file.content.Load(); content != nil {
+		hand.hits.Add(1)
+		hand.touch(file)
+		return content, nil
+	}
+
+	hand.misses.Add(1)
+	data, err := fs.ReadFile(fsys, file.path)
+	if err != nil {
+		return nil, err
+	}
+	var content *cacheContent
+	if entry := hand.persist.load(file.persistKey); entry != nil {
+		content = entry.content(data)
+	} else {
+		content = newCacheContent(data)
+		hand.persist.save(file.persistKey, file.etag, content)
+	}
+
+	hand.memoryMutex.Lock()
+	defer hand.memoryMutex.Unlock()
+	file.content.Store(content)
+	if file.live {
+		hand.memory.Add(content.size())
+		if hand.maxMemory > 0 {
+			file.lru = hand.lru.PushFront(file)
+		}
+	}
+	hand.evict()
+
+	return content, nil
+}
+
+// Move the file at the front of the LRU list.
+func (hand *cacheHandler) touch(file *cacheFile) {
+	if hand.maxMemory <= 0 {
+		return
+	}
+	hand.memoryMutex.Lock()
+	defer hand.memoryMutex.Unlock()
+	if file.lru != nil {
+		hand.lru.MoveToFront(file.lru)
+	}
+}
+
+// Count the file content in the memory, when it enters in the published
+// snapshot. The memoryMutex must be held.
+func (hand *cacheHandler) attach(file *cacheFile) {
+	if file.live {
+		return
+	}
+	file.live = true
+	if size := file.memory(); size > 0 {
+		hand.memory.Add(size)
+		if hand.maxMemory > 0 {
+			file.lru = hand.lru.PushFront(file)
+		}
+	}
+}
+
+// Remove the file content from the memory, when it leaves the published
+// snapshot. The memoryMutex must be held.
+func (hand *cacheHandler) detach(file *cacheFile) {
+	if !file.live {
+		return
+	}
+	file.live = false
+	hand.memory.Add(-file.memory())
+	if file.lru != nil {
+		hand.lru.Remove(file.lru)
+		file.lru = nil
+	}
+}
+
+// Evict the least recently used files until the memory is under the budget.
+// The memoryMutex must be held.
+func (hand *cacheHandler) evict() {
+	for hand.maxMemory > 0 && hand.memory.Load() > hand.maxMemory && hand.lru.Len() > 0 {
+		file := hand.lru.Remove(hand.lru.Back()).(*cacheFile)
+		file.lru = nil
+		hand.memory.Add(-file.memory())
+		file.content.Store(nil)
+	}
+}
+
+// Create a file served from the filesystem.
+// The file is read to compute the ETag if the persisted entry is nil.
+func newCacheDiskFile(fsys fs.FS, p string, info fs.FileInfo, isDir bool, entry *cachePersistEntry) (*cacheFile, error) {
+	file := newCacheFileMeta(info.Name(), isDir, info.ModTime())
+	file.path = p
+	file.disk = true
+
+	if entry != nil {
+		file.etag = entry.ETag
+		return file, nil
+	}
+
+	f, err := fsys.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, err
+	}
+	file.etag = cacheETag(hash.Sum(nil))
+
+	return file, nil
+}
+
+// Serve a big file from the filesystem.
+func (hand *cacheHandler) serveDisk(w http.ResponseWriter, r *http.Request, fsys fs.FS, file *cacheFile) {
+	f, err := fsys.Open(file.path)
+	if err == nil {
+		defer f.Close()
+		if _, ok := f.(io.ReadSeeker); !ok {
+			err = errors.New("file is not seekable")
+		}
+	}
+	if err != nil {
+		LogRequest(hand.Logger.With("err", err.Error()), http.StatusInternalServerError, r)
+		hand.servError(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	if file.contentType != "" {
+		w.Header().Add(headerContentType, file.contentType)
+	}
+	w.Header().Add(headerLastModified, file.modString)
+	w.Header().Add(headerETag, file.etag)
+
+	sw := &statusWriter{ResponseWriter: w}
+	http.ServeContent(sw, r, path.Base(file.path), file.modTime, f.(io.ReadSeeker))
+	LogRequest(hand.Logger, sw.status, r)
+}
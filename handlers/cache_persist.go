@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// A directory to save the ETags and the compressed versions of the files,
+// to reuse them after a restart.
+// All methods accept a nil receiver, that do nothing.
+type cachePersist struct {
+	dir    string
+	logger *slog.Logger
+}
+
+// Create the persistence in a sub directory of dir specific to the roots,
+// so several handlers can share dir. Return nil if dir is empty.
+func newCachePersist(logger *slog.Logger, dir string, roots []string) *cachePersist {
+	if dir == "" {
+		return nil
+	}
+	hash := sha256.New()
+	for _, root := range roots {
+		if abs, err := filepath.Abs(root); err == nil {
+			root = abs
+		}
+		hash.Write([]byte(root + "\x00"))
+	}
+	return &cachePersist{
+		dir:    filepath.Join(dir, "root-"+hex.EncodeToString(hash.Sum(nil))[:16]),
+		logger: logger,
+	}
+}
+
+// The number of CacheFS handlers by directory, to name their persistence.
+var cachePersistFS = struct {
+	sync.Mutex
+	dirs map[string]int
+}{dirs: make(map[string]int)}
+
+// Create the persistence of a CacheFS handler in a sub directory of dir
+// specific to name. Without name, the handlers are named by their order of
+// creation. Return nil if dir is empty.
+func newCachePersistFS(logger *slog.Logger, dir, name string) *cachePersist {
+	if dir == "" {
+		return nil
+	}
+	if name == "" {
+		cachePersistFS.Lock()
+		name = strconv.Itoa(cachePersistFS.dirs[dir])
+		cachePersistFS.dirs[dir]++
+		cachePersistFS.Unlock()
+	}
+	hash := sha256.Sum256([]byte(name))
+	return &cachePersist{
+		dir:    filepath.Join(dir, "fs-"+hex.EncodeToString(hash[:])[:16]),
+		logger: logger,
+	}
+}
+
+// A saved file.
+type cachePersistEntry struct {
+	ETag    string
+	Encoded []cachePersistEncoded
+}
+
+type cachePersistEncoded struct {
+	Encoding string
+	Bytes    []byte
+}
+
+// Get the content with the saved compressed versions.
+func (entry *cachePersistEntry) content(identity []byte) *cacheContent {
+	content := &cacheContent{identity: identity}
+	for _, encoded := range entry.Encoded {
+		content.encoded = append(content.encoded, cacheEncoded{encoded.Encoding, encoded.Bytes})
+	}
+	return content
+}
+
+// The key of a file version, from the path, the modification time, the size
+// and the encoders. Without modification time (like in an embed.FS), the
+// content is also hashed.
+func (persist *cachePersist) key(fsys fs.FS, p string, info fs.FileInfo) string {
+	if persist == nil {
+		return ""
+	}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%d\x00%d", p, info.ModTime().UnixNano(), info.Size())
+	for _, encoder := range Encoders {
+		hash.Write([]byte("\x00" + encoder.Name))
+	}
+	if info.ModTime().IsZero() {
+		f, err := fsys.Open(p)
+		if err != nil {
+			return ""
+		}
+		defer f.Close()
+		hash.Write([]byte{0})
+		if _, err := io.Copy(hash, f); err != nil {
+			return ""
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Load an entry, return nil if it does not exist or is invalid.
+func (persist *cachePersist) load(key string) *cachePersistEntry {
+	if persist == nil || key == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(persist.dir, key))
+	if err != nil {
+		return nil
+	}
+	entry := new(cachePersistEntry)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(entry); err != nil {
+		persist.logger.Warn("cache-persist-load", "key", key, "err", err.Error())
+		return nil
+	}
+	return entry
+}
+
+// Save an entry. The content can be nil for file served from the filesystem.
+func (persist *cachePersist) save(key, etag string, content *cacheContent) {
+	if persist == nil || key == "" {
+		return
+	}
+
+	entry := cachePersistEntry{ETag: etag}
+	if content != nil {
+		for _, encoded := range content.encoded {
+			entry.Encoded = append(entry.Encoded, cachePersistEncoded{encoded.encoding, encoded.bytes})
+		}
+	}
+	buff := bytes.Buffer{}
+	gob.NewEncoder(&buff).Encode(&entry)
+
+	if err := persist.write(key, buff.Bytes()); err != nil {
+		persist.logger.Warn("cache-persist-save", "key", key, "err", err.Error())
+	}
+}
+
+// Write atomically the file.
+func (persist *cachePersist) write(key string, data []byte) error {
+	if err := os.MkdirAll(persist.dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(persist.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	} else if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(persist.dir, key))
+}
+
+// Remove the unused entries, the other files are kept.
+func (persist *cachePersist) clean(used map[string]bool) {
+	if persist == nil {
+		return
+	}
+	entries, err := os.ReadDir(persist.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if name := entry.Name(); !used[name] && entry.Type().IsRegular() && cachePersistKey(name) {
+			os.Remove(filepath.Join(persist.dir, name))
+		}
+	}
+}
+
+// Test if the name has the format of a key.
+func cachePersistKey(name string) bool {
+	_, err := hex.DecodeString(name)
+	return err == nil && len(name) == sha256.Size*2
+}
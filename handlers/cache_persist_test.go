@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachePersist(t *testing.T) {
+	defer func(encoders []Encoder) { Encoders = encoders }(Encoders)
+	compressCount := 0
+	Encoders = []Encoder{{"test", func(w io.Writer) io.WriteCloser {
+		compressCount++
+		return &testHalfEncoder{w: w}
+	}}}
+
+	dir := t.TempDir()
+	fsys := fstest.MapFS{
+		"a.txt":   &fstest.MapFile{Data: []byte("aaaa"), ModTime: time.Unix(1, 0)},
+		"big.txt": &fstest.MapFile{Data: []byte("big file"), ModTime: time.Unix(1, 0)},
+	}
+	newHandler := func() *cacheHandler {
+		logger, _ := testLoggerOne()
+		return &cacheHandler{
+			common:      common{Logger: logger},
+			maxFileSize: 4,
+			persist:     newCachePersist(logger, dir, []string{"/www"}),
+		}
+	}
+
+	hand := newHandler()
+	hand.Update(fsys, time.Now())
+	entries, err := os.ReadDir(hand.persist.dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, 2, compressCount) // a.txt and the index
+
+	compressCount = 0
+	hand = newHandler()
+	hand.Update(fsys, time.Now())
+	assert.Equal(t, 1, compressCount) // only the index
+	file := hand.snapshot.Load().files["a.txt"]
+	assert.Equal(t, `"Yb5VqOL2tOFyM4vd8YTW2-4pyYhT4KBIXs7n8nua8LQ"`, file.etag)
+	assert.Equal(t, []cacheEncoded{{"test", []byte("aa")}}, file.content.Load().encoded)
+	assert.Equal(t, `"h1T16jz8ms6NPjFPxQHTEiWlmDQQ5yf0LKtrGTF9mR0"`, hand.snapshot.Load().files["big.txt"].etag)
+
+	// Remove old entries
+	fsys["a.txt"] = &fstest.MapFile{Data: []byte("AAAA"), ModTime: time.Unix(2, 0)}
+	delete(fsys, "big.txt")
+	hand.Update(fsys, time.Now())
+	entries, err = os.ReadDir(hand.persist.dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestCachePersistShared(t *testing.T) {
+	dir := t.TempDir()
+	logger, _ := testLoggerOne()
+	newHandler := func(root string) *cacheHandler {
+		return &cacheHandler{
+			common:  common{Logger: logger},
+			persist: newCachePersist(logger, dir, []string{root}),
+		}
+	}
+	// Same path, modification time and size.
+	fsysA := fstest.MapFS{"a.txt": &fstest.MapFile{Data: []byte(strings.Repeat("a", 100)), ModTime: time.Unix(1, 0)}}
+	fsysB := fstest.MapFS{"a.txt": &fstest.MapFile{Data: []byte(strings.Repeat("b", 100)), ModTime: time.Unix(1, 0)}}
+	foreign := filepath.Join(dir, "keep.txt")
+	assert.NoError(t, os.WriteFile(foreign, []byte("not a cache entry"), 0o644))
+
+	newHandler("/www/a").Update(fsysA, time.Now())
+	newHandler("/www/b").Update(fsysB, time.Now())
+	handA, handB := newHandler("/www/a"), newHandler("/www/b")
+	handA.Update(fsysA, time.Now())
+	handB.Update(fsysB, time.Now())
+	assert.NotEqual(t, handA.persist.dir, handB.persist.dir)
+	assert.NotEqual(t, handA.snapshot.Load().files["a.txt"].etag, handB.snapshot.Load().files["a.txt"].etag)
+
+	get := func(hand *cacheHandler) string {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://host/a.txt", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		hand.ServeHTTP(w, r)
+		return gunzip(t, w.Body.Bytes())
+	}
+	assert.Equal(t, strings.Repeat("a", 100), get(handA))
+	assert.Equal(t, strings.Repeat("b", 100), get(handB))
+
+	entriesB, _ := os.ReadDir(handB.persist.dir)
+	assert.NotEmpty(t, entriesB)
+	assert.NoError(t, os.WriteFile(filepath.Join(handA.persist.dir, "keep.txt"), nil, 0o644))
+	delete(fsysA, "a.txt")
+	handA.Update(fsysA, time.Now())
+	entriesBAfter, _ := os.ReadDir(handB.persist.dir)
+	assert.Equal(t, entriesB, entriesBAfter)
+	assert.FileExists(t, foreign)
+	assert.FileExists(t, filepath.Join(handA.persist.dir, "keep.txt"))
+}
+
+func TestCachePersistFS(t *testing.T) {
+	dir := t.TempDir()
+	logger, _ := testLoggerOne()
+	newHandler := func(fsys fs.FS, name string) *cacheHandler {
+		hand := CacheFS(logger, fsys, "", Options{CacheDir: dir, CacheName: name}).(*cacheHandler)
+		for end := time.Now().Add(2 * time.Second); hand.Stats().Files == 0 && time.Now().Before(end); {
+			time.Sleep(time.Millisecond)
+		}
+		return hand
+	}
+	fsysA := fstest.MapFS{"a.txt": &fstest.MapFile{Data: []byte(strings.Repeat("a", 100))}}
+	fsysB := fstest.MapFS{"b.txt": &fstest.MapFile{Data: []byte(strings.Repeat("b", 100))}}
+
+	handA, handB := newHandler(fsysA, ""), newHandler(fsysB, "")
+	assert.NotEqual(t, handA.persist.dir, handB.persist.dir)
+	assert.Equal(t, handA.persist.dir, newHandler(fsysA, "0").persist.dir)
+	entriesA, _ := os.ReadDir(handA.persist.dir)
+	entriesB, _ := os.ReadDir(handB.persist.dir)
+	assert.Len(t, entriesA, 1)
+	assert.Len(t, entriesB, 1)
+
+	named := newHandler(fsysB, "named")
+	assert.NotEqual(t, handB.persist.dir, named.persist.dir)
+	assert.Equal(t, named.persist.dir, newHandler(fsysB, "named").persist.dir)
+}
+
+// Write only the half of the data.
+type testHalfEncoder struct {
+	w    io.Writer
+	buff bytes.Buffer
+}
+
+func (enc *testHalfEncoder) Write(data []byte) (int, error) { return enc.buff.Write(data) }
+func (enc *testHalfEncoder) Close() error {
+	_, err := enc.w.Write(enc.buff.Bytes()[:enc.buff.Len()/2])
+	return err
+}
+
+func TestCacheFallback(t *testing.T) {
+	logger, logBuffer := testLoggerOne()
+	hand := &cacheHandler{common: common{Logger: logger}}
+	hand.fallback = &fileHandler{common: hand.common, fsys: http.FS(testFS)}
+
+	w := httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "http://host/hello.txt", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "Hello World", w.Body.String())
+	assert.Equal(t, "level=INFO msg=http s=200 ip=192.0.2.1:1234 h=host m=GET u=/hello.txt\n", logBuffer.String())
+}
+
+func TestCachePersistKeyContent(t *testing.T) {
+	logger, _ := testLoggerOne()
+	persist := newCachePersist(logger, t.TempDir(), nil)
+	fsys := fstest.MapFS{"a.txt": &fstest.MapFile{Data: []byte("aaaa")}}
+	key := func(fsys fstest.MapFS) string {
+		info, _ := fsys.Stat("a.txt")
+		return persist.key(fsys, "a.txt", info)
+	}
+	rebuild := fstest.MapFS{"a.txt": &fstest.MapFile{Data: []byte("AAAA")}}
+	assert.True(t, cachePersistKey(key(fsys)))
+	assert.NotEqual(t, key(fsys), key(rebuild), "same size without modification time")
+
+	fsys["a.txt"].ModTime = time.Unix(1, 0)
+	rebuild["a.txt"].ModTime = time.Unix(1, 0)
+	assert.Equal(t, key(fsys), key(rebuild), "the content is not read")
+}
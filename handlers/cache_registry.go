@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The cache handlers by root directories, to update them at once after a
+// modification done by this server, for example with the WebDAV handler.
+var cacheRegistry struct {
+	sync.Mutex
+	entries []cacheRegistryEntry
+}
+
+type cacheRegistryEntry struct {
+	hand *cacheHandler
+	fsys fs.FS
+	// The absolute root directories.
+	roots []string
+}
+
+// Register the cache handler of the roots directories.
+func cacheRegister(hand *cacheHandler, fsys fs.FS, roots []string) {
+	entry := cacheRegistryEntry{hand: hand, fsys: fsys}
+	for _, root := range roots {
+		if abs, err := filepath.Abs(root); err == nil {
+			entry.roots = append(entry.roots, abs)
+		}
+	}
+
+	cacheRegistry.Lock()
+	defer cacheRegistry.Unlock()
+	cacheRegistry.entries = append(cacheRegistry.entries, entry)
+}
+
+// Update the cache handlers serving the directory dir, after a modification
+// of its entries. With recursive, the sub directories can also be modified,
+// so all the cache is updated.
+func cacheInvalidate(dir string, recursive bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+
+	cacheRegistry.Lock()
+	entries := cacheRegistry.entries
+	cacheRegistry.Unlock()
+
+	now := time.Now()
+	for _, entry := range entries {
+		for _, root := range entry.roots {
+			if rel, err := filepath.Rel(root, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				key := filepath.ToSlash(rel)
+				if key == "." {
+					key = ""
+				}
+				if recursive {
+					entry.hand.Update(entry.fsys, now)
+				} else {
+					entry.hand.updateDirs(entry.fsys, []string{key}, now)
+				}
+				break
+			} else if recursive && strings.HasPrefix(root, dir+string(filepath.Separator)) {
+				// The root is in the modified directory.
+				entry.hand.Update(entry.fsys, now)
+				break
+			}
+		}
+	}
+}
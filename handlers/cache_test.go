@@ -3,10 +3,17 @@ package handlers
 import (
 	"bytes"
 	"errors"
+	"io"
 	"io/fs"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/HuguesGuilleus/servHTTP/handlers/template"
@@ -23,32 +30,59 @@ func TestCacheImplementCommon(t *testing.T) {
 func newTestCache(r *http.Request) (*httptest.ResponseRecorder, *bytes.Buffer) {
 	logger, logBuffer := testLoggerOne()
 
+	hand := &cacheHandler{common: common{Logger: logger}}
+	hand.snapshot.Store(newCacheSnapshot(map[string]*cacheFile{
+		"file": testCacheFile(&cacheFile{
+			contentType: "text/plain; charset=utf-8",
+			etag:        `"etagT"`,
+			modTime:     time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC),
+			modString:   "Wed, 21 Oct 2015 07:28:00 GMT",
+		}, []byte("text"), cacheEncoded{deflateEncoding, []byte("co")}),
+		"compress": testCacheFile(&cacheFile{
+			contentType: "text/plain; charset=utf-8",
+			etag:        `"etagC"`,
+			modString:   "Wed, 22 Oct 2015 07:28:00 GMT",
+		}, nil, cacheEncoded{"gzip", []byte("gz")}, cacheEncoded{deflateEncoding, []byte("co")}),
+	}, nil))
+
 	w := httptest.NewRecorder()
-	(&cacheHandler{
-		common: common{Logger: logger},
-		files: map[string]*cacheFile{
-			"file": {
-				contentType:   "text/plain; charset=utf-8",
-				etag:          `"etagT"`,
-				modString:     "Wed, 21 Oct 2015 07:28:00 GMT",
-				identityBytes: []byte("text"),
-				identityLen:   "4",
-				deflateBytes:  []byte("co"),
-				deflateLen:    "2",
-			},
-			"compress": {
-				contentType:  "text/plain; charset=utf-8",
-				etag:         `"etagC"`,
-				modString:    "Wed, 22 Oct 2015 07:28:00 GMT",
-				deflateBytes: []byte("co"),
-				deflateLen:   "2",
-			},
-		},
-	}).ServeHTTP(w, r)
+	hand.ServeHTTP(w, r)
 
 	return w, logBuffer
 }
 
+// Set the content of the file.
+func testCacheFile(file *cacheFile, identity []byte, encoded ...cacheEncoded) *cacheFile {
+	file.content.Store(&cacheContent{identity: identity, encoded: encoded})
+	return file
+}
+
+// A comparable view of a cacheFile.
+type testCacheFileView struct {
+	isDir       bool
+	contentType string
+	modString   string
+	etag        string
+	path        string
+	disk        bool
+	identity    []byte
+}
+
+func viewCacheFile(file *cacheFile) (view testCacheFileView) {
+	view = testCacheFileView{
+		isDir:       file.isDir,
+		contentType: file.contentType,
+		modString:   file.modString,
+		etag:        file.etag,
+		path:        file.path,
+		disk:        file.disk,
+	}
+	if content := file.content.Load(); content != nil {
+		view.identity = content.identity
+	}
+	return
+}
+
 func TestCacheNotFound(t *testing.T) {
 	r := httptest.NewRequest("GET", "http://host/x", nil)
 	w, logBuffer := newTestCache(r)
@@ -76,9 +110,9 @@ func TestCacheWithEtag(t *testing.T) {
 	w, logBuffer := newTestCache(r)
 
 	assert.Equal(t, 304, w.Code)
-	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get(headerContentType))
+	assert.Equal(t, "", w.Header().Get(headerContentType))
 	assert.Equal(t, `"etagT"`, w.Header().Get(headerETag))
-	assert.Equal(t, "Wed, 21 Oct 2015 07:28:00 GMT", w.Header().Get(headerLastModified))
+	assert.Equal(t, "", w.Header().Get(headerLastModified))
 	assert.Equal(t, "", w.Header().Get(headerContentLength))
 	assert.Equal(t, "", w.Body.String())
 
@@ -92,14 +126,44 @@ func TestCacheCompress(t *testing.T) {
 
 	assert.Equal(t, 200, w.Code)
 	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get(headerContentType))
-	assert.Equal(t, `"etagC"`, w.Header().Get(headerETag))
+	assert.Equal(t, `"etagC-gzip"`, w.Header().Get(headerETag))
 	assert.Equal(t, "Wed, 22 Oct 2015 07:28:00 GMT", w.Header().Get(headerLastModified))
+	assert.Equal(t, "gzip", w.Header().Get(headerContentEncoding))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get(headerVary))
 	assert.Equal(t, "2", w.Header().Get(headerContentLength))
-	assert.Equal(t, "co", w.Body.String())
+	assert.Equal(t, "gz", w.Body.String())
 
 	assert.Equal(t, "level=INFO msg=http s=200 ip=192.0.2.1:1234 h=host m=GET u=/compress\n", logBuffer.String())
 }
 
+func TestCacheCompressDeflate(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://host/compress", nil)
+	r.Header.Add("accept-encoding", "deflate, gzip;q=0.8, *;q=0.5")
+	w, _ := newTestCache(r)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "deflate", w.Header().Get(headerContentEncoding))
+	assert.Equal(t, "co", w.Body.String())
+}
+
+func TestCacheCompressNegotiate(t *testing.T) {
+	tf := func(acceptEncoding, contentEncoding, body string) {
+		r := httptest.NewRequest("GET", "http://host/file", nil)
+		r.Header.Add("accept-encoding", acceptEncoding)
+		w, _ := newTestCache(r)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, contentEncoding, w.Header().Get(headerContentEncoding))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get(headerVary))
+		assert.Equal(t, body, w.Body.String())
+	}
+	tf("", "", "text")
+	tf("gzip", "", "text")
+	tf("*", "deflate", "co")
+	tf("deflate;q=0.5", "", "text")
+	tf("deflate;q=0.5, identity;q=0", "deflate", "co")
+	tf("*;q=0", "", "text")
+}
+
 func TestCacheNormal(t *testing.T) {
 	r := httptest.NewRequest("GET", "http://host/file", nil)
 	w, logBuffer := newTestCache(r)
@@ -108,67 +172,332 @@ func TestCacheNormal(t *testing.T) {
 	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get(headerContentType))
 	assert.Equal(t, `"etagT"`, w.Header().Get(headerETag))
 	assert.Equal(t, "Wed, 21 Oct 2015 07:28:00 GMT", w.Header().Get(headerLastModified))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get(headerVary))
 	assert.Equal(t, "4", w.Header().Get(headerContentLength))
 	assert.Equal(t, "text", w.Body.String())
 
 	assert.Equal(t, "level=INFO msg=http s=200 ip=192.0.2.1:1234 h=host m=GET u=/file\n", logBuffer.String())
 }
 
-func TestCacheUpdateOK(t *testing.T) {
-	autoindex_file := &cacheFile{
-		isDir:         false,
-		contentType:   "text/plain; charset=utf-8",
-		modString:     "Mon, 01 Jan 0001 00:00:00 UTC",
-		etag:          `"O5w1jzbwoxtq0-FPMJx88ZiskkboMW-c5UPVsZrAK4A"`,
-		identityBytes: []byte("file"),
-		identityLen:   "4",
+func TestCacheConditional(t *testing.T) {
+	tf := func(method string, headers map[string]string, code int, contentType, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "http://host/file", nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w, logBuffer := newTestCache(r)
+		assert.Equal(t, code, w.Code)
+		assert.Equal(t, contentType, w.Header().Get(headerContentType))
+		assert.Equal(t, body, w.Body.String())
+		assert.Equal(t, "level=INFO msg=http s="+strconv.Itoa(code)+" ip=192.0.2.1:1234 h=host m="+method+" u=/file\n", logBuffer.String())
+		return w
 	}
 
+	// Conditional
+	tf("GET", map[string]string{"If-None-Match": `"etagX", W/"etagT"`}, 304, "", "")
+	tf("GET", map[string]string{"If-None-Match": `*`}, 304, "", "")
+	tf("GET", map[string]string{"If-None-Match": `"etagX"`}, 200, "text/plain; charset=utf-8", "text")
+	tf("GET", map[string]string{"If-Modified-Since": "Wed, 21 Oct 2015 07:28:00 GMT"}, 304, "", "")
+	tf("GET", map[string]string{"If-Modified-Since": "Wed, 21 Oct 2015 07:27:00 GMT"}, 200, "text/plain; charset=utf-8", "text")
+	tf("GET", map[string]string{"If-Match": `"etagX"`}, 412, "text/plain; charset=utf-8", "")
+	tf("GET", map[string]string{"If-Match": `"etagT"`}, 200, "text/plain; charset=utf-8", "text")
+	tf("GET", map[string]string{"If-Unmodified-Since": "Wed, 21 Oct 2015 07:27:00 GMT"}, 412, "text/plain; charset=utf-8", "")
+
+	// Range
+	w := tf("GET", map[string]string{"Range": "bytes=1-2", "Accept-Encoding": "deflate"}, 206, "text/plain; charset=utf-8", "ex")
+	assert.Equal(t, "bytes 1-2/4", w.Header().Get("Content-Range"))
+	assert.Equal(t, "", w.Header().Get(headerContentEncoding))
+	assert.Equal(t, "2", w.Header().Get(headerContentLength))
+	tf("GET", map[string]string{"Range": "bytes=1-2", "If-Range": `"etagT"`}, 206, "text/plain; charset=utf-8", "ex")
+	tf("GET", map[string]string{"Range": "bytes=1-2", "If-Range": `"etagX"`}, 200, "text/plain; charset=utf-8", "text")
+	tf("GET", map[string]string{"Range": "bytes=1-2", "If-Range": "Wed, 21 Oct 2015 07:28:00 GMT"}, 206, "text/plain; charset=utf-8", "ex")
+	w = tf("GET", map[string]string{"Range": "bytes=10-20"}, 416, "text/plain; charset=utf-8", "invalid range: failed to overlap\n")
+	assert.Equal(t, "bytes */4", w.Header().Get("Content-Range"))
+
+	r := httptest.NewRequest("GET", "http://host/file", nil)
+	r.Header.Set("Range", "bytes=0-0,2-3")
+	w, _ = newTestCache(r)
+	assert.Equal(t, 206, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get(headerContentType), "multipart/byteranges; boundary="))
+	_, params, err := mime.ParseMediaType(w.Header().Get(headerContentType))
+	assert.NoError(t, err)
+	reader := multipart.NewReader(w.Body, params["boundary"])
+	for _, expected := range []struct{ contentRange, body string }{
+		{"bytes 0-0/4", "t"},
+		{"bytes 2-3/4", "xt"},
+	} {
+		part, err := reader.NextPart()
+		assert.NoError(t, err)
+		assert.Equal(t, expected.contentRange, part.Header.Get("Content-Range"))
+		body, _ := io.ReadAll(part)
+		assert.Equal(t, expected.body, string(body))
+	}
+
+	// HEAD
+	w = tf("HEAD", nil, 200, "text/plain; charset=utf-8", "")
+	assert.Equal(t, "4", w.Header().Get(headerContentLength))
+}
+
+func TestCacheUpdateOK(t *testing.T) {
+	autoindex_file := testCacheFile(&cacheFile{
+		isDir:       false,
+		contentType: "text/plain; charset=utf-8",
+		modString:   "Mon, 01 Jan 0001 00:00:00 GMT",
+		etag:        `"O5w1jzbwoxtq0-FPMJx88ZiskkboMW-c5UPVsZrAK4A"`,
+		path:        "autoindex/file.txt",
+	}, []byte("file"))
+
 	now := time.Now()
 	logger, logBuffer := testLoggerOne()
-	hand := cacheHandler{common: common{logger, ""}, files: map[string]*cacheFile{
+	hand := cacheHandler{common: common{Logger: logger}}
+	hand.snapshot.Store(newCacheSnapshot(map[string]*cacheFile{
 		"autoindex/file.txt": autoindex_file,
-	}}
+	}, nil))
 
 	hand.Update(testFS, now)
 
 	// files
-	assert.Equal(t, &cacheFile{
-		isDir:         false,
-		contentType:   "text/plain; charset=utf-8",
-		modString:     "Mon, 01 Jan 0001 00:00:00 UTC",
-		etag:          `"pZGm1Av0IEBKARczz7exkNYsZb8LzaMrV7J32a2fFG4"`,
-		identityBytes: []byte("Hello World"),
-		identityLen:   "11",
-	}, hand.files["hello.txt"])
-	assert.Equal(t, &cacheFile{
-		isDir:         true,
-		contentType:   "text/html; charset=utf-8",
-		modString:     "Mon, 01 Jan 0001 00:00:00 UTC",
-		etag:          `"KBS6GuR-Ifb0nlVoOVLTLuy6QjM_EsZDSUqhq8wXOgo"`,
-		identityBytes: []byte("the index"),
-		identityLen:   "9",
-	}, hand.files["index"])
-	assert.Same(t, autoindex_file, hand.files["autoindex/file.txt"])
+	assert.Equal(t, testCacheFileView{
+		isDir:       false,
+		contentType: "text/plain; charset=utf-8",
+		modString:   "Mon, 01 Jan 0001 00:00:00 GMT",
+		etag:        `"pZGm1Av0IEBKARczz7exkNYsZb8LzaMrV7J32a2fFG4"`,
+		path:        "hello.txt",
+		identity:    []byte("Hello World"),
+	}, viewCacheFile(hand.snapshot.Load().files["hello.txt"]))
+	assert.Equal(t, testCacheFileView{
+		isDir:       true,
+		contentType: "text/html; charset=utf-8",
+		modString:   "Mon, 01 Jan 0001 00:00:00 GMT",
+		etag:        `"KBS6GuR-Ifb0nlVoOVLTLuy6QjM_EsZDSUqhq8wXOgo"`,
+		path:        "index/index.html",
+		identity:    []byte("the index"),
+	}, viewCacheFile(hand.snapshot.Load().files["index"]))
+	assert.Same(t, autoindex_file, hand.snapshot.Load().files["autoindex/file.txt"])
 
 	// auto index
-	assert.NotNil(t, hand.files["autoindex"])
-	assert.NotNil(t, hand.files["dir"])
-	assert.NotNil(t, hand.files[""])
+	assert.NotNil(t, hand.snapshot.Load().files["autoindex"])
+	assert.NotNil(t, hand.snapshot.Load().files["dir"])
+	assert.NotNil(t, hand.snapshot.Load().files[""])
 
-	assert.Len(t, hand.files, 6)
+	assert.Len(t, hand.snapshot.Load().files, 6)
+
+	assert.Equal(t, "", logBuffer.String())
+}
+
+func TestCacheUpdateDirs(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt":     &fstest.MapFile{Data: []byte("a")},
+		"sub/b.txt": &fstest.MapFile{Data: []byte("b")},
+		"sub/c.txt": &fstest.MapFile{Data: []byte("c")},
+		"old/d.txt": &fstest.MapFile{Data: []byte("d")},
+	}
+	logger, logBuffer := testLoggerOne()
+	hand := cacheHandler{common: common{Logger: logger}}
+	hand.Update(fsys, time.Now())
+	assert.Len(t, hand.snapshot.Load().files, 7)
+	subIndex := hand.snapshot.Load().files["sub"]
+	bFile := hand.snapshot.Load().files["sub/b.txt"]
+
+	fsys["a.txt"] = &fstest.MapFile{Data: []byte("A"), ModTime: time.Now()}
+	fsys["new/deep/e.txt"] = &fstest.MapFile{Data: []byte("e")}
+	delete(fsys, "old/d.txt")
+	hand.updateDirs(fsys, []string{"", "old"}, time.Now())
+
+	assert.Equal(t, []byte("A"), hand.snapshot.Load().files["a.txt"].content.Load().identity)
+	assert.Equal(t, []byte("e"), hand.snapshot.Load().files["new/deep/e.txt"].content.Load().identity)
+	assert.NotNil(t, hand.snapshot.Load().files["new"])
+	assert.NotNil(t, hand.snapshot.Load().files["new/deep"])
+	assert.Nil(t, hand.snapshot.Load().files["old"])
+	assert.Nil(t, hand.snapshot.Load().files["old/d.txt"])
+	assert.Nil(t, hand.snapshot.Load().dirs["old"])
+	assert.Same(t, subIndex, hand.snapshot.Load().files["sub"])
+	assert.Same(t, bFile, hand.snapshot.Load().files["sub/b.txt"])
+	assert.Len(t, hand.snapshot.Load().files, 8)
+
+	// The not updated directory keep it old index.
+	delete(fsys, "sub/c.txt")
+	hand.updateDirs(fsys, []string{""}, time.Now())
+	assert.NotNil(t, hand.snapshot.Load().files["sub/c.txt"])
+	hand.updateDirs(fsys, []string{"sub"}, time.Now())
+	assert.Nil(t, hand.snapshot.Load().files["sub/c.txt"])
+	assert.NotSame(t, subIndex, hand.snapshot.Load().files["sub"])
 
 	assert.Equal(t, "", logBuffer.String())
 }
 
 func TestCacheUpdateFail(t *testing.T) {
 	logger, logBuffer := testLoggerOne()
-	hand := cacheHandler{
-		common: common{Logger: logger},
-		files:  make(map[string]*cacheFile),
-	}
+	hand := cacheHandler{common: common{Logger: logger}}
 	hand.Update(failWhenOpenFS{}, time.Now())
 	assert.Equal(t, "level=WARN msg=cache-update-fail err=\"open fail\"\n", logBuffer.String())
+	assert.Nil(t, hand.snapshot.Load())
+	assert.EqualError(t, hand.Stats().LastError, "open fail")
+}
+
+func TestCacheStats(t *testing.T) {
+	logger, _ := testLoggerOne()
+	hand := cacheHandler{common: common{Logger: logger}}
+	assert.Equal(t, CacheStats{}, hand.Stats())
+
+	hand.Update(fstest.MapFS{
+		"a.txt": &fstest.MapFile{Data: []byte("aaaa")},
+		"b.txt": &fstest.MapFile{Data: []byte("bb")},
+	}, time.Now())
+
+	stats := hand.Stats()
+	assert.Equal(t, 3, stats.Files)
+	assert.Equal(t, 6+hand.snapshot.Load().files[""].content.Load().size(), stats.Bytes)
+	assert.NotZero(t, stats.LastUpdate)
+	assert.NoError(t, stats.LastError)
+}
+
+func TestCacheMemory(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt":   &fstest.MapFile{Data: []byte("aaaa")},
+		"b.txt":   &fstest.MapFile{Data: []byte("bbbb")},
+		"c.txt":   &fstest.MapFile{Data: []byte("cccc")},
+		"big.txt": &fstest.MapFile{Data: []byte("big file")},
+	}
+	logger, logBuffer := testLoggerOne()
+	hand := &cacheHandler{common: common{Logger: logger}, maxMemory: 8, maxFileSize: 4}
+	hand.Update(fsys, time.Now())
+	files := hand.snapshot.Load().files
+	assert.Equal(t, testCacheFileView{
+		contentType: "text/plain; charset=utf-8",
+		modString:   "Mon, 01 Jan 0001 00:00:00 GMT",
+		etag:        `"h1T16jz8ms6NPjFPxQHTEiWlmDQQ5yf0LKtrGTF9mR0"`,
+		path:        "big.txt",
+		disk:        true,
+	}, viewCacheFile(files["big.txt"]))
+	assert.NotNil(t, files["a.txt"].content.Load())
+	assert.NotNil(t, files["b.txt"].content.Load())
+	assert.Nil(t, files["c.txt"].content.Load())
+	assert.Equal(t, int64(8), hand.memory.Load())
+
+	get := func(url, expected string) {
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, expected, w.Body.String())
+	}
+
+	// b.txt is the least recently used.
+	get("http://host/a.txt", "aaaa")
+	get("http://host/c.txt", "cccc")
+	assert.NotNil(t, files["a.txt"].content.Load())
+	assert.Nil(t, files["b.txt"].content.Load())
+	assert.NotNil(t, files["c.txt"].content.Load())
+	assert.Equal(t, int64(8), hand.memory.Load())
+
+	get("http://host/b.txt", "bbbb")
+	assert.Nil(t, files["a.txt"].content.Load())
+	get("http://host/big.txt", "big file")
+
+	stats := hand.Stats()
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(2), stats.Misses)
+	assert.NotContains(t, logBuffer.String(), "WARN")
+
+	// A reused file keep its memory.
+	hand.Update(fsys, time.Now())
+	assert.Equal(t, int64(8), hand.memory.Load())
+}
+
+func TestCacheMemoryScan(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt":   &fstest.MapFile{Data: []byte("aaaaaa")},
+		"b.txt":   &fstest.MapFile{Data: []byte("bbbbbb")},
+		"big.txt": &fstest.MapFile{Data: []byte("big file!")},
+	}
+	logger, _ := testLoggerOne()
+	hand := &cacheHandler{common: common{Logger: logger}, maxMemory: 8}
+	hand.Update(fsys, time.Now())
+	files := hand.snapshot.Load().files
+	assert.False(t, files["a.txt"].disk)
+	assert.NotNil(t, files["a.txt"].content.Load())
+	assert.False(t, files["b.txt"].disk, "loaded on use")
+	assert.Nil(t, files["b.txt"].content.Load())
+	assert.True(t, files["big.txt"].disk, "bigger than the budget")
+	assert.NotEmpty(t, files["big.txt"].etag)
+	assert.Equal(t, int64(6), hand.memory.Load())
+}
+
+// The memory is the size of the live contents after concurrent loads and
+// updates, to be run with the race detector.
+func TestCacheMemoryConcurrent(t *testing.T) {
+	fsysA := fstest.MapFS{
+		"a.txt": &fstest.MapFile{Data: []byte("aa"), ModTime: time.Unix(1, 0)},
+		"b.txt": &fstest.MapFile{Data: []byte("bb"), ModTime: time.Unix(1, 0)},
+		"c.txt": &fstest.MapFile{Data: []byte("cc"), ModTime: time.Unix(1, 0)},
+	}
+	fsysB := fstest.MapFS{
+		"a.txt": &fstest.MapFile{Data: []byte("AAA"), ModTime: time.Unix(2, 0)},
+		"b.txt": &fstest.MapFile{Data: []byte("bb"), ModTime: time.Unix(1, 0)},
+		"c.txt": &fstest.MapFile{Data: []byte("CCC"), ModTime: time.Unix(2, 0)},
+	}
+	logger, _ := testLoggerOne()
+	hand := &cacheHandler{common: common{Logger: logger}, maxMemory: 5}
+	hand.Update(fsysA, time.Now())
+
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 50; j++ {
+			hand.Update(fsysB, time.Now())
+			hand.Update(fsysA, time.Now())
+		}
+	}()
+	for _, name := range []string{"a.txt", "c.txt"} {
+		go func(name string) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				hand.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://host/"+name, nil))
+				hand.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://host/b.txt", nil))
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	live := int64(0)
+	for _, file := range hand.snapshot.Load().files {
+		live += file.memory()
+	}
+	assert.Equal(t, live, hand.memory.Load())
+	assert.LessOrEqual(t, live, int64(5))
+}
+
+// Update and serve concurrently, to be run with the race detector.
+func TestCacheConcurrent(t *testing.T) {
+	fsysA := fstest.MapFS{"file.txt": &fstest.MapFile{Data: []byte("A"), ModTime: time.Unix(1, 0)}}
+	fsysB := fstest.MapFS{"file.txt": &fstest.MapFile{Data: []byte("B"), ModTime: time.Unix(2, 0)}}
+	logger, _ := testLoggerOne()
+	hand := &cacheHandler{common: common{Logger: logger}}
+	hand.Update(fsysA, time.Now())
+
+	wg := sync.WaitGroup{}
+	wg.Add(4)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				hand.Update(fsysB, time.Now())
+				hand.updateDirs(fsysA, []string{""}, time.Now())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				w := httptest.NewRecorder()
+				hand.ServeHTTP(w, httptest.NewRequest("GET", "http://host/file.txt", nil))
+				assert.Equal(t, 200, w.Code)
+				assert.Contains(t, []string{"A", "B"}, w.Body.String())
+				hand.Stats()
+			}
+		}()
+	}
+	wg.Wait()
 }
 
 // A http.FileSystem that always fail with .Open()
@@ -177,3 +506,54 @@ type failWhenOpenFS struct{}
 func (failWhenOpenFS) Open(string) (fs.File, error) {
 	return nil, errors.New("open fail")
 }
+
+func TestCacheRunOnce(t *testing.T) {
+	logger, _ := testLoggerOne()
+	hand := &cacheHandler{common: common{Logger: logger}}
+	done := make(chan struct{})
+	go func() {
+		hand.run(fstest.MapFS{"a.txt": &fstest.MapFile{}}, nil, -1)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("run does not return with a negative interval")
+	}
+	assert.Equal(t, 2, hand.Stats().Files)
+}
+
+func TestCacheFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"dir/file.txt": &fstest.MapFile{Data: []byte("file")},
+	}
+	logger, _ := testLoggerOne()
+	hand := CacheFS(logger, fsys, "max-age=60", Options{})
+
+	for end := time.Now().Add(2 * time.Second); hand.(*cacheHandler).Stats().Files == 0 && time.Now().Before(end); {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 3, hand.(*cacheHandler).Stats().Files)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://example.com/dir/file.txt", nil)
+	hand.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "file", w.Body.String())
+	assert.Equal(t, "max-age=60", w.Header().Get("Cache-Control"))
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+}
+
+func TestCacheFSStatic(t *testing.T) {
+	fsys := fstest.MapFS{"a.txt": &fstest.MapFile{Data: []byte("a")}}
+	logger, _ := testLoggerOne()
+	hand := CacheFS(logger, fsys, "", Options{Interval: time.Millisecond, Static: true}).(*cacheHandler)
+	for end := time.Now().Add(2 * time.Second); hand.Stats().Files == 0 && time.Now().Before(end); {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 2, hand.Stats().Files)
+
+	fsys["b.txt"] = &fstest.MapFile{Data: []byte("b")}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, hand.Stats().Files, "a static fs.FS is scanned only once")
+}
@@ -0,0 +1,173 @@
+//go:build linux
+
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_ONLYDIR |
+	syscall.IN_ATTRIB |
+	syscall.IN_CLOSE_WRITE |
+	syscall.IN_CREATE |
+	syscall.IN_DELETE |
+	syscall.IN_DELETE_SELF |
+	syscall.IN_MODIFY |
+	syscall.IN_MOVE_SELF |
+	syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO
+
+// Watch the roots with inotify, and update only the modified directories.
+// Block until a fail, for example when the watches are exhausted.
+func (hand *cacheHandler) watch(fsys fs.FS, roots []string) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("inotify init: %w", err)
+	}
+	defer syscall.Close(fd)
+
+	watcher := inotifyWatcher{
+		fd:    fd,
+		roots: roots,
+		fsys:  fsys,
+		keys:  make(map[int32]string),
+	}
+	if err := watcher.add(""); err != nil {
+		return err
+	}
+	hand.Update(fsys, time.Now())
+
+	events := make(chan inotifyChange, 64)
+	errs := make(chan error, 1)
+	go func() { errs <- watcher.read(events) }()
+
+	changed := make(map[string]bool)
+	full := false
+	var debounce <-chan time.Time
+	var deadline time.Time
+	for {
+		select {
+		case event := <-events:
+			if event.full {
+				full = true
+			} else {
+				changed[event.key] = true
+			}
+			if deadline.IsZero() {
+				deadline = time.Now().Add(cacheDebounceMax)
+			}
+			debounce = time.After(min(cacheDebounce, time.Until(deadline)))
+		case now := <-debounce:
+			debounce = nil
+			deadline = time.Time{}
+			if full {
+				hand.Update(fsys, now)
+			} else {
+				keys := make([]string, 0, len(changed))
+				for key := range changed {
+					keys = append(keys, key)
+				}
+				slices.Sort(keys)
+				hand.updateDirs(fsys, keys, now)
+			}
+			clear(changed)
+			full = false
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// A change in a directory.
+type inotifyChange struct {
+	// The directory key.
+	key string
+	// Some events are lost, need a full scan.
+	full bool
+}
+
+type inotifyWatcher struct {
+	fd int
+	// The root and the union roots, all are watched.
+	roots []string
+	fsys  fs.FS
+	// The directory key of each watch descriptor.
+	keys map[int32]string
+}
+
+// Add a watch on the directory in each root and all its sub directories.
+// The directory can be missing in some roots.
+func (watcher *inotifyWatcher) add(key string) error {
+	for _, root := range watcher.roots {
+		wd, err := syscall.InotifyAddWatch(watcher.fd, filepath.Join(root, filepath.FromSlash(key)), inotifyMask)
+		if len(watcher.roots) > 1 && (errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ENOTDIR)) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("inotify add watch %q: %w", key, err)
+		}
+		watcher.keys[int32(wd)] = key
+	}
+
+	entries, err := fs.ReadDir(watcher.fsys, cacheKeyPath(key))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if err := watcher.add(cacheKeyJoin(key, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Read the inotify events and send the modified directories.
+func (watcher *inotifyWatcher) read(events chan<- inotifyChange) error {
+	buff := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := syscall.Read(watcher.fd, buff)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("inotify read: %w", err)
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buff[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			offset = nameStart + int(event.Len)
+			name := strings.TrimRight(string(buff[nameStart:offset]), "\x00")
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				events <- inotifyChange{full: true}
+				continue
+			} else if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(watcher.keys, event.Wd)
+				continue
+			}
+
+			key, ok := watcher.keys[event.Wd]
+			if !ok {
+				continue
+			}
+			if event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				if err := watcher.add(cacheKeyJoin(key, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+				// The directory can exist in another root, scan the files
+				// created before the watch.
+				events <- inotifyChange{key: cacheKeyJoin(key, name)}
+			}
+			events <- inotifyChange{key: key}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheWatch(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0o644))
+
+	logger, logBuffer := testLoggerOne()
+	hand := &cacheHandler{common: common{Logger: logger}}
+	go hand.watch(os.DirFS(root), []string{root})
+
+	waitBody := func(url, expected string) {
+		t.Helper()
+		body := ""
+		for end := time.Now().Add(2 * time.Second); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+			w := httptest.NewRecorder()
+			hand.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+			if body = w.Body.String(); body == expected {
+				return
+			}
+		}
+		t.Errorf("%s: expected %q, got %q", url, expected, body)
+	}
+	waitStatus := func(url string, expected int) {
+		t.Helper()
+		for end := time.Now().Add(2 * time.Second); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+			w := httptest.NewRecorder()
+			hand.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+			if w.Code == expected {
+				return
+			}
+		}
+		t.Errorf("%s: expected status %d", url, expected)
+	}
+
+	waitBody("http://host/a.txt", "a")
+
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("modified"), 0o644))
+	waitBody("http://host/a.txt", "modified")
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "sub", "deep"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "sub", "deep", "b.txt"), []byte("b"), 0o644))
+	waitBody("http://host/sub/deep/b.txt", "b")
+
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "sub", "deep", "c.txt"), []byte("c"), 0o644))
+	waitBody("http://host/sub/deep/c.txt", "c")
+
+	assert.NoError(t, os.Rename(filepath.Join(root, "sub"), filepath.Join(root, "moved")))
+	waitBody("http://host/moved/deep/b.txt", "b")
+	waitStatus("http://host/sub/deep/b.txt", 404)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "moved", "deep", "d.txt"), []byte("d"), 0o644))
+	waitBody("http://host/moved/deep/d.txt", "d")
+
+	assert.NoError(t, os.Remove(filepath.Join(root, "a.txt")))
+	waitStatus("http://host/a.txt", 404)
+
+	assert.NotContains(t, logBuffer.String(), "cache-update-fail")
+}
+
+func TestCacheWatchUnion(t *testing.T) {
+	site, theme := t.TempDir(), t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(theme, "css"), 0o755))
+
+	logger, _ := testLoggerOne()
+	hand := &cacheHandler{common: common{Logger: logger}}
+	go hand.watch(unionRootFS(site, Options{Under: []string{theme}}), []string{site, theme})
+
+	waitBody := func(url, expected string) {
+		t.Helper()
+		body := ""
+		for end := time.Now().Add(2 * time.Second); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+			w := httptest.NewRecorder()
+			hand.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+			if body = w.Body.String(); body == expected {
+				return
+			}
+		}
+		t.Errorf("%s: expected %q, got %q", url, expected, body)
+	}
+
+	assert.NoError(t, os.WriteFile(filepath.Join(theme, "css", "a.css"), []byte("theme"), 0o644))
+	waitBody("http://host/css/a.css", "theme")
+
+	assert.NoError(t, os.Mkdir(filepath.Join(site, "css"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(site, "css", "a.css"), []byte("site"), 0o644))
+	waitBody("http://host/css/a.css", "site")
+}
+
+func TestCacheWatchContinuous(t *testing.T) {
+	root := t.TempDir()
+	logger, _ := testLoggerOne()
+	hand := &cacheHandler{common: common{Logger: logger}}
+	go hand.watch(os.DirFS(root), []string{root})
+	time.Sleep(50 * time.Millisecond)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		log, _ := os.Create(filepath.Join(root, "app.log"))
+		defer log.Close()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(cacheDebounce / 5):
+				log.WriteString("line\n")
+			}
+		}
+	}()
+
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0o644))
+	for end := time.Now().Add(2 * time.Second); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, httptest.NewRequest("GET", "http://host/a.txt", nil))
+		if w.Body.String() == "a" {
+			return
+		}
+	}
+	t.Error("never updated during continuous writes")
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package handlers
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// Filesystem events are only supported on Linux.
+func (hand *cacheHandler) watch(fs.FS, []string) error {
+	return errors.ErrUnsupported
+}
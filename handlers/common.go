@@ -1,25 +1,32 @@
 package handlers
 
 import (
+	"errors"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/HuguesGuilleus/servHTTP/handlers/template"
 )
 
 var (
+	headerAccept          = http.CanonicalHeaderKey("Accept")
 	headerAcceptEncoding  = http.CanonicalHeaderKey("Accept-Encoding")
 	headerCacheControl    = http.CanonicalHeaderKey("cache-control")
 	headerContentEncoding = http.CanonicalHeaderKey("Content-Encoding")
 	headerContentLength   = http.CanonicalHeaderKey("Content-Length")
 	headerContentType     = http.CanonicalHeaderKey("Content-Type")
 	headerETag            = http.CanonicalHeaderKey("ETag")
-	headerIfNoneMatch     = http.CanonicalHeaderKey("If-None-Match")
 	headerLastModified    = http.CanonicalHeaderKey("Last-Modified")
+	headerRange           = http.CanonicalHeaderKey("Range")
+	headerVary            = http.CanonicalHeaderKey("Vary")
 
 	htmlMIME        = "text/html"
+	problemJSONMIME = "application/problem+json"
+	textMIME        = "text/plain; charset=utf-8"
 	deflateEncoding = "deflate"
 )
 
@@ -29,11 +36,26 @@ type common struct {
 	Logger *slog.Logger
 	// CacheControl control header.
 	CacheControl string
+	// Deny access to dotfiles and some names.
+	deny denyPolicy
+	// The fallback for the single page applications.
+	spa spaFallback
+	// The custom error pages.
+	pages errorPages
+	// The theme of the index pages, nil for the default theme.
+	theme template.Theme
+	// The number of entries by index page, 0 for no pagination.
+	pageSize int
+	// The directory download as an archive.
+	archive archivePolicy
+	// Show the upload form in the index pages.
+	uploadForm bool
 }
 
 // Add Cache control if any.
 // Then Manage request for a static file system:
 // - Reject method is different of HEAD and GET
+// - Reject denied path (dotfiles...)
 // - Redirect request with url "/index.html" end
 // else return false
 func (hand *common) Serve(w http.ResponseWriter, r *http.Request) bool {
@@ -45,7 +67,14 @@ func (hand *common) Serve(w http.ResponseWriter, r *http.Request) bool {
 	case "GET", "HEAD":
 	default:
 		LogRequest(hand.Logger, http.StatusMethodNotAllowed, r)
-		servHTML(w, http.StatusMethodNotAllowed, template.Error405(r.URL.Path))
+		hand.servError(w, r, http.StatusMethodNotAllowed)
+		return true
+	}
+
+	if hand.deny.denied(r.URL.Path) {
+		status := hand.deny.statusCode()
+		LogRequest(hand.Logger, status, r)
+		hand.servError(w, r, status)
 		return true
 	}
 
@@ -62,6 +91,35 @@ func (hand *common) Serve(w http.ResponseWriter, r *http.Request) bool {
 	return false
 }
 
+// Serve the error page of the status code.
+func (hand *common) servError(w http.ResponseWriter, r *http.Request, status int) {
+	hand.pages.serve(w, r, status)
+}
+
+// Log the request and send the status, with an error page for the errors.
+func (hand *common) serveStatus(w http.ResponseWriter, r *http.Request, status int) {
+	LogRequest(hand.Logger, status, r)
+	if status >= http.StatusBadRequest {
+		hand.servError(w, r, status)
+	} else {
+		w.WriteHeader(status)
+	}
+}
+
+// Send the status of a filesystem error: 404 for a missing file, 409 for
+// a file used as a directory, else 500.
+func (hand *common) serveFSError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		hand.serveStatus(w, r, http.StatusNotFound)
+	case errors.Is(err, syscall.ENOTDIR):
+		hand.serveStatus(w, r, http.StatusConflict)
+	default:
+		LogRequest(hand.Logger.With("err", err.Error()), http.StatusInternalServerError, r)
+		hand.servError(w, r, http.StatusInternalServerError)
+	}
+}
+
 func (hand *common) endSlash(w http.ResponseWriter, r *http.Request, isDir bool) bool {
 	endSlash := strings.HasSuffix(r.URL.Path, "/")
 	if isDir != endSlash {
@@ -82,6 +140,28 @@ func (hand *common) endSlash(w http.ResponseWriter, r *http.Request, isDir bool)
 	return false
 }
 
+// A http.ResponseWriter that save the status code, used to log the request.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
 func LogRequest(logger *slog.Logger, status int, r *http.Request) {
 	l := logger.With("s", status, "ip", r.RemoteAddr, "h", r.Host, "m", r.Method, "u", r.URL.Path)
 
@@ -93,7 +173,11 @@ func LogRequest(logger *slog.Logger, status int, r *http.Request) {
 }
 
 func servHTML(w http.ResponseWriter, status int, body []byte) {
-	w.Header().Set(headerContentType, htmlMIME)
+	servBody(w, status, htmlMIME, body)
+}
+
+func servBody(w http.ResponseWriter, status int, contentType string, body []byte) {
+	w.Header().Set(headerContentType, contentType)
 	w.Header().Set(headerContentLength, strconv.Itoa(len(body)))
 	w.WriteHeader(status)
 	w.Write(body)
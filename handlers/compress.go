@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// Minimum size of a response body to be compressed on the fly.
+	CompressMinSize = 1024
+	// MIME types compressed on the fly.
+	// A type ending with "/" is a prefix for all sub types.
+	CompressTypes = []string{
+		"text/",
+		"application/javascript",
+		"application/json",
+		"application/problem+json",
+		"application/wasm",
+		"application/xml",
+		"image/svg+xml",
+	}
+)
+
+const gzipEncoding = "gzip"
+
+var gzipPool = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// Compress on the fly with gzip the responses of next.
+func Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := newCompressWriter(w, r)
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// Test if the MIME type is in CompressTypes.
+func compressibleType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, t := range CompressTypes {
+		if strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) || t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// A http.ResponseWriter that compress the body if it's possible.
+// The body is buffered until CompressMinSize bytes,
+// then the compression start.
+// Call Close at the end to flush the buffer or the compressor.
+type compressWriter struct {
+	w http.ResponseWriter
+	// The client accept gzip and it's not a range request.
+	accept bool
+	head   bool
+
+	status int
+	// The decision state: compressPending, compressOn or compressOff
+	state  int
+	buffer []byte
+	gz     *gzip.Writer
+}
+
+const (
+	compressPending = iota
+	compressOn
+	compressOff
+)
+
+func newCompressWriter(w http.ResponseWriter, r *http.Request) *compressWriter {
+	return &compressWriter{
+		w:      w,
+		accept: r.Header.Get(headerRange) == "" && negotiateEncoding(r.Header.Get(headerAcceptEncoding), []string{gzipEncoding}) == gzipEncoding,
+		head:   r.Method == http.MethodHead,
+	}
+}
+
+func (cw *compressWriter) Header() http.Header { return cw.w.Header() }
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter { return cw.w }
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 || cw.state != compressPending {
+		return
+	} else if status < 200 {
+		cw.w.WriteHeader(status)
+		return
+	}
+	cw.status = status
+
+	h := cw.w.Header()
+	switch {
+	case cw.head,
+		status == http.StatusNoContent,
+		status == http.StatusNotModified,
+		status == http.StatusPartialContent,
+		h.Get(headerContentEncoding) != "",
+		h.Get("Content-Range") != "",
+		h.Get(headerContentType) != "" && !compressibleType(h.Get(headerContentType)):
+		cw.passThrough()
+		return
+	}
+
+	if h.Get(headerContentType) != "" {
+		h.Add(headerVary, headerAcceptEncoding)
+		if !cw.accept {
+			cw.passThrough()
+			return
+		}
+	}
+
+	if l, err := strconv.Atoi(h.Get(headerContentLength)); err == nil && l < CompressMinSize {
+		cw.passThrough()
+	}
+}
+
+func (cw *compressWriter) Write(data []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	switch cw.state {
+	case compressOn:
+		return cw.gz.Write(data)
+	case compressOff:
+		return cw.w.Write(data)
+	}
+
+	h := cw.w.Header()
+	if h.Get(headerContentType) == "" {
+		buff := append(cw.buffer, data[:min(len(data), 512-len(cw.buffer))]...)
+		contentType := http.DetectContentType(buff)
+		h.Set(headerContentType, contentType)
+		if !compressibleType(contentType) {
+			cw.passThrough()
+			return cw.w.Write(data)
+		}
+		h.Add(headerVary, headerAcceptEncoding)
+		if !cw.accept {
+			cw.passThrough()
+			return cw.w.Write(data)
+		}
+	}
+
+	cw.buffer = append(cw.buffer, data...)
+	if len(cw.buffer) >= CompressMinSize {
+		if err := cw.startCompress(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// Send the headers and the buffer without compression.
+func (cw *compressWriter) passThrough() {
+	cw.state = compressOff
+	cw.w.WriteHeader(cw.status)
+	if len(cw.buffer) > 0 {
+		cw.w.Write(cw.buffer)
+		cw.buffer = nil
+	}
+}
+
+// Send the headers and start the compression of the buffer.
+func (cw *compressWriter) startCompress() error {
+	cw.state = compressOn
+
+	h := cw.w.Header()
+	h.Del(headerContentLength)
+	h.Set(headerContentEncoding, gzipEncoding)
+	if etag := h.Get(headerETag); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set(headerETag, "W/"+etag)
+	}
+	cw.w.WriteHeader(cw.status)
+
+	cw.gz = gzipPool.Get().(*gzip.Writer)
+	cw.gz.Reset(cw.w)
+	_, err := cw.gz.Write(cw.buffer)
+	cw.buffer = nil
+	return err
+}
+
+// Flush the compressor and the underlying writer.
+// On the first flush, the compression start if the response is compressible.
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.state == compressPending {
+		if cw.w.Header().Get(headerContentType) == "" {
+			cw.passThrough()
+		} else {
+			cw.startCompress()
+		}
+	}
+	if cw.state == compressOn {
+		cw.gz.Flush()
+	}
+	http.NewResponseController(cw.w).Flush()
+}
+
+// Terminate the response: write the buffer or close the compressor.
+func (cw *compressWriter) Close() error {
+	switch cw.state {
+	case compressPending:
+		if cw.status == 0 {
+			return nil
+		}
+		cw.passThrough()
+	case compressOn:
+		err := cw.gz.Close()
+		cw.gz.Reset(io.Discard)
+		gzipPool.Put(cw.gz)
+		cw.gz = nil
+		cw.state = compressOff
+		return err
+	}
+	return nil
+}
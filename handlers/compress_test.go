@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCompress(t *testing.T, r *http.Request, hand http.HandlerFunc) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	Compress(hand).ServeHTTP(w, r)
+	return w
+}
+
+func gunzip(t *testing.T, data []byte) string {
+	gz, err := gzip.NewReader(strings.NewReader(string(data)))
+	assert.NoError(t, err)
+	content, err := io.ReadAll(gz)
+	assert.NoError(t, err)
+	return string(content)
+}
+
+func TestCompressible(t *testing.T) {
+	assert.True(t, compressibleType("text/html; charset=utf-8"))
+	assert.True(t, compressibleType("Application/JSON"))
+	assert.True(t, compressibleType("image/svg+xml"))
+	assert.False(t, compressibleType("image/png"))
+	assert.False(t, compressibleType("application/json-seq"))
+	assert.False(t, compressibleType(""))
+}
+
+func TestCompress(t *testing.T) {
+	big := strings.Repeat("Hello World! ", 200)
+	newRequest := func(acceptEncoding string) *http.Request {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		return r
+	}
+	serveBig := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(201)
+		io.WriteString(w, big[:100])
+		io.WriteString(w, big[100:])
+	}
+
+	t.Run("gzip", func(t *testing.T) {
+		w := testCompress(t, newRequest("gzip"), serveBig)
+		assert.Equal(t, 201, w.Code)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		assert.Equal(t, `W/"etag"`, w.Header().Get("ETag"))
+		assert.Equal(t, "", w.Header().Get("Content-Length"))
+		assert.Equal(t, big, gunzip(t, w.Body.Bytes()))
+	})
+	t.Run("noAccept", func(t *testing.T) {
+		w := testCompress(t, newRequest("br, gzip;q=0"), serveBig)
+		assert.Equal(t, 201, w.Code)
+		assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		assert.Equal(t, `"etag"`, w.Header().Get("ETag"))
+		assert.Equal(t, big, w.Body.String())
+	})
+	t.Run("range", func(t *testing.T) {
+		r := newRequest("gzip")
+		r.Header.Set("Range", "bytes=0-10")
+		w := testCompress(t, r, serveBig)
+		assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, big, w.Body.String())
+	})
+	t.Run("small", func(t *testing.T) {
+		w := testCompress(t, newRequest("gzip"), func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "small")
+		})
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		assert.Equal(t, "small", w.Body.String())
+	})
+	t.Run("smallContentLength", func(t *testing.T) {
+		w := testCompress(t, newRequest("gzip"), func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Length", "5")
+			io.WriteString(w, "small")
+		})
+		assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "5", w.Header().Get("Content-Length"))
+		assert.Equal(t, "small", w.Body.String())
+	})
+	t.Run("noBody", func(t *testing.T) {
+		w := testCompress(t, newRequest("gzip"), func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotModified)
+		})
+		assert.Equal(t, 304, w.Code)
+		assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	})
+	t.Run("type", func(t *testing.T) {
+		w := testCompress(t, newRequest("gzip"), func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, big)
+		})
+		assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "", w.Header().Get("Vary"))
+		assert.Equal(t, big, w.Body.String())
+	})
+	t.Run("sniff", func(t *testing.T) {
+		w := testCompress(t, newRequest("gzip"), func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "<!DOCTYPE html>"+big)
+		})
+		assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "<!DOCTYPE html>"+big, gunzip(t, w.Body.Bytes()))
+	})
+	t.Run("encoded", func(t *testing.T) {
+		w := testCompress(t, newRequest("gzip"), func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, big)
+		})
+		assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, big, w.Body.String())
+	})
+	t.Run("head", func(t *testing.T) {
+		r := newRequest("gzip")
+		r.Method = "HEAD"
+		w := testCompress(t, r, serveBig)
+		assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	})
+	t.Run("flush", func(t *testing.T) {
+		w := testCompress(t, newRequest("gzip"), func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "event")
+			w.(http.Flusher).Flush()
+			assert.True(t, w.(*compressWriter).w.(*httptest.ResponseRecorder).Flushed)
+			io.WriteString(w, "event")
+		})
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "eventevent", gunzip(t, w.Body.Bytes()))
+	})
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Policy to deny the access to dotfiles and some file names.
+type denyPolicy struct {
+	// Allow the names beginning with a dot.
+	allowDotfiles bool
+	// Glob patterns (path.Match) of the denied names.
+	patterns []string
+	// Status code of a denied request, 404 or 403.
+	status int
+}
+
+func newDenyPolicy(opt Options) denyPolicy {
+	return denyPolicy{
+		allowDotfiles: opt.AllowDotfiles,
+		patterns:      opt.Deny,
+		status:        opt.DenyStatus,
+	}
+}
+
+// Test if a path (with slash separator) is denied.
+// "/.well-known/" is always allowed.
+func (policy *denyPolicy) denied(p string) bool {
+	for i, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" || i == 0 && name == ".well-known" {
+			continue
+		} else if !policy.allowDotfiles && strings.HasPrefix(name, ".") {
+			return true
+		}
+		for _, pattern := range policy.patterns {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Get the status code for a denied request.
+func (policy *denyPolicy) statusCode() int {
+	if policy.status == http.StatusForbidden {
+		return http.StatusForbidden
+	}
+	return http.StatusNotFound
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDenyPolicy(t *testing.T) {
+	policy := newDenyPolicy(Options{Deny: []string{"*.bak", "*~"}})
+	assert.False(t, policy.denied("/"))
+	assert.False(t, policy.denied("/dir/file.txt"))
+	assert.False(t, policy.denied("/.well-known/acme-challenge/token"))
+	assert.True(t, policy.denied("/.git/config"))
+	assert.True(t, policy.denied("/dir/.env"))
+	assert.True(t, policy.denied("/dir/.well-known/"))
+	assert.True(t, policy.denied("/.well-known/.secret"))
+	assert.True(t, policy.denied("/file.bak"))
+	assert.True(t, policy.denied("/dir.bak/file.txt"))
+	assert.True(t, policy.denied("/file.txt~"))
+	assert.Equal(t, http.StatusNotFound, policy.statusCode())
+
+	policy = newDenyPolicy(Options{AllowDotfiles: true, DenyStatus: http.StatusForbidden})
+	assert.False(t, policy.denied("/.git/config"))
+	assert.Equal(t, http.StatusForbidden, policy.statusCode())
+}
+
+var testDenyFS = fstest.MapFS{
+	".git/config":            &fstest.MapFile{Data: []byte("secret")},
+	".well-known/security":   &fstest.MapFile{Data: []byte("contact")},
+	"file.txt":               &fstest.MapFile{Data: []byte("file")},
+	"file.txt.bak":           &fstest.MapFile{Data: []byte("old")},
+	"public/.htaccess":       &fstest.MapFile{Data: []byte("secret")},
+	"public/index.html.bak~": &fstest.MapFile{Data: []byte("old")},
+}
+
+func TestDenyHandlers(t *testing.T) {
+	opt := Options{Deny: []string{"*.bak", "*~"}}
+	logger, _ := testLoggerOne()
+	file := &fileHandler{
+		common: common{Logger: logger, deny: newDenyPolicy(opt)},
+		fsys:   http.FS(testDenyFS),
+	}
+	cache := &cacheHandler{common: common{Logger: logger, deny: newDenyPolicy(opt)}}
+	cache.Update(testDenyFS, time.Now())
+
+	for name, hand := range map[string]http.Handler{"file": file, "cache": cache} {
+		t.Run(name, func(t *testing.T) {
+			tf := func(url string, code int) string {
+				w := httptest.NewRecorder()
+				hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+url, nil))
+				assert.Equal(t, code, w.Code, url)
+				return w.Body.String()
+			}
+			tf("/file.txt", 200)
+			tf("/.well-known/security", 200)
+			tf("/.git/config", 404)
+			tf("/.git/", 404)
+			tf("/file.txt.bak", 404)
+			tf("/public/.htaccess", 404)
+			tf("/public/index.html.bak~", 404)
+
+			index := tf("/", 200)
+			assert.Contains(t, index, "file.txt")
+			assert.NotContains(t, index, "file.txt.bak")
+			assert.NotContains(t, tf("/public/", 200), "index.html.bak~")
+		})
+	}
+
+	file.deny.status = http.StatusForbidden
+	w := httptest.NewRecorder()
+	file.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/.git/config", nil))
+	assert.Equal(t, 403, w.Code)
+	assert.Contains(t, w.Body.String(), "403 Forbidden")
+}
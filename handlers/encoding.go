@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const identityEncoding = "identity"
+
+// A compressor for a Content-Encoding.
+type Encoder struct {
+	// Name of the encoding, used in the Content-Encoding header.
+	Name string
+	// Create a compressor writer.
+	New func(w io.Writer) io.WriteCloser
+}
+
+// Encoders used to precompress the content in the cache handler,
+// ordered by server preference.
+//
+// You can add your custom encoders (like br or zstd) to this slice at init.
+var Encoders = []Encoder{
+	{"gzip", func(w io.Writer) io.WriteCloser {
+		enc, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+		return enc
+	}},
+	// The HTTP deflate encoding is the zlib format (RFC 9110), not raw deflate.
+	{deflateEncoding, func(w io.Writer) io.WriteCloser {
+		enc, _ := zlib.NewWriterLevel(w, flate.BestCompression)
+		return enc
+	}},
+}
+
+// The ETag of an encoded representation, distinct from the identity ETag
+// because the ranges and the If-Range apply on the encoded bytes.
+func encodingETag(etag, encoding string) string {
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// Parse a header with quality values (like Accept-Encoding).
+// The keys are lower case, the values are the quality between 0 and 1.
+func parseQuality(header string) map[string]float64 {
+	values := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(key), "q") {
+				f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil || f < 0 {
+					f = 0
+				} else if f > 1 {
+					f = 1
+				}
+				q = f
+			}
+		}
+		values[name] = q
+	}
+	return values
+}
+
+// Get the quality of the encoding from a parsed Accept-Encoding header.
+func encodingQuality(accept map[string]float64, encoding string) float64 {
+	if q, ok := accept[encoding]; ok {
+		return q
+	} else if encoding == "gzip" {
+		if q, ok := accept["x-gzip"]; ok {
+			return q
+		}
+	}
+	if q, ok := accept["*"]; ok {
+		return q
+	} else if encoding == identityEncoding {
+		return 1
+	}
+	return 0
+}
+
+// Select the best encoding from available (ordered by server preference)
+// according to the Accept-Encoding header.
+// Return "" for identity.
+func negotiateEncoding(header string, available []string) string {
+	if header == "" || len(available) == 0 {
+		return ""
+	}
+	accept := parseQuality(header)
+
+	best, bestQ := "", 0.0
+	for _, encoding := range available {
+		if q := encodingQuality(accept, encoding); q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	if best == "" || bestQ < encodingQuality(accept, identityEncoding) {
+		return ""
+	}
+	return best
+}
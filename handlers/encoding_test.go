@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuality(t *testing.T) {
+	assert.Equal(t, map[string]float64{
+		"gzip":     1,
+		"br":       0.5,
+		"identity": 0,
+		"*":        0.1,
+	}, parseQuality("gzip, BR;q=0.5 ,, identity;q=0, *;q=0.1"))
+	assert.Equal(t, map[string]float64{"gzip": 0}, parseQuality("gzip;q=yolo"))
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	available := []string{"br", "gzip", "deflate"}
+	assert.Equal(t, "", negotiateEncoding("", available))
+	assert.Equal(t, "", negotiateEncoding("gzip", nil))
+	assert.Equal(t, "", negotiateEncoding("compress", available))
+	assert.Equal(t, "br", negotiateEncoding("gzip, br", available))
+	assert.Equal(t, "gzip", negotiateEncoding("gzip, br;q=0.5", available))
+	assert.Equal(t, "gzip", negotiateEncoding("x-gzip", available))
+	assert.Equal(t, "br", negotiateEncoding("*", available))
+	assert.Equal(t, "deflate", negotiateEncoding("deflate, *;q=0", available))
+	assert.Equal(t, "", negotiateEncoding("gzip;q=0.5, identity", available))
+	assert.Equal(t, "gzip", negotiateEncoding("gzip;q=0.1, identity;q=0", available))
+	assert.Equal(t, "", negotiateEncoding("br;q=0, gzip;q=0, deflate;q=0", available))
+}
+
+func TestEncodersDeflate(t *testing.T) {
+	for _, encoder := range Encoders {
+		if encoder.Name != deflateEncoding {
+			continue
+		}
+		buff := bytes.Buffer{}
+		w := encoder.New(&buff)
+		io.WriteString(w, "Hello World")
+		w.Close()
+		r, err := zlib.NewReader(&buff)
+		assert.NoError(t, err)
+		data, _ := io.ReadAll(r)
+		assert.Equal(t, "Hello World", string(data))
+	}
+}
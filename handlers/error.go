@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	htmltemplate "html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/HuguesGuilleus/servHTTP/handlers/template"
+)
+
+const headerRequestID = "X-Request-Id"
+
+// Formats of the error responses.
+const (
+	// HTML page, the built-in or a custom page.
+	ErrorFormatHTML = "html"
+	// Problem details JSON (RFC 9457), "application/problem+json".
+	ErrorFormatJSON = "json"
+	// Plain text.
+	ErrorFormatText = "text"
+)
+
+// A format of a response, selected by its media type.
+type formatMediaType struct{ mediaType, format string }
+
+// The error formats by media type, ordered by server preference.
+var errorMediaTypes = []formatMediaType{
+	{htmlMIME, ErrorFormatHTML},
+	{problemJSONMIME, ErrorFormatJSON},
+	{"application/json", ErrorFormatJSON},
+	{"text/plain", ErrorFormatText},
+}
+
+// The data of a custom error page template.
+type ErrorData = template.ErrorData
+
+// Custom error pages, html/template files read on each error.
+// The search order for the status 404:
+// 1. "404.html" in root
+// 2. the file files["404"]
+// 3. the theme error page.
+type errorPages struct {
+	logger *slog.Logger
+	// The theme, nil for the default theme.
+	theme template.Theme
+	// The served root, nil to ignore.
+	root fs.FS
+	// The template path by status code.
+	files map[string]string
+	// The error format, "" to negotiate it with the Accept header.
+	format string
+}
+
+func newErrorPages(logger *slog.Logger, root fs.FS, theme template.Theme, opt Options) errorPages {
+	return errorPages{
+		logger: logger,
+		theme:  theme,
+		root:   root,
+		files:  opt.Errors,
+		format: opt.ErrorFormat,
+	}
+}
+
+// Serve the error page of the status code.
+func (pages *errorPages) serve(w http.ResponseWriter, r *http.Request, status int) {
+	id := r.Header.Get(headerRequestID)
+	if id == "" {
+		id = newRequestID()
+	}
+	w.Header().Set(headerRequestID, id)
+
+	format := pages.format
+	if format == "" {
+		w.Header().Add(headerVary, headerAccept)
+		format = negotiateFormat(r.Header.Get(headerAccept), errorMediaTypes)
+	}
+	switch format {
+	case ErrorFormatJSON:
+		servBody(w, status, problemJSONMIME, template.ErrorJSON(status, r.URL.Path))
+	case ErrorFormatText:
+		servBody(w, status, textMIME, template.ErrorText(status, r.URL.Path))
+	default:
+		data := &ErrorData{
+			Status:     status,
+			StatusText: http.StatusText(status),
+			Path:       r.URL.Path,
+			RequestID:  id,
+		}
+		if body := pages.render(data); body != nil {
+			servHTML(w, status, body)
+			return
+		}
+		buff := bytes.Buffer{}
+		if err := themeOrDefault(pages.theme).Error(&buff, data); err != nil {
+			pages.logger.Warn("error-page", "status", status, "err", err.Error())
+			servHTML(w, status, template.Error(status, r.URL.Path))
+			return
+		}
+		servHTML(w, status, buff.Bytes())
+	}
+}
+
+// Select the format from the Accept header. The media types are ordered
+// by server preference, the first one is the default.
+func negotiateFormat(header string, mediaTypes []formatMediaType) string {
+	if header == "" {
+		return mediaTypes[0].format
+	}
+	accept := parseQuality(header)
+
+	best, bestQ := mediaTypes[0].format, 0.0
+	for _, m := range mediaTypes {
+		if q := mediaTypeQuality(accept, m.mediaType); q > bestQ {
+			best, bestQ = m.format, q
+		}
+	}
+	return best
+}
+
+// Get the quality of the media type from a parsed Accept header,
+// with the wildcards "type/*" and "*/*".
+func mediaTypeQuality(accept map[string]float64, mediaType string) float64 {
+	if q, ok := accept[mediaType]; ok {
+		return q
+	}
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	if q, ok := accept[mainType+"/*"]; ok {
+		return q
+	} else if q, ok := accept["*/*"]; ok {
+		return q
+	}
+	return 0
+}
+
+// Render the custom error page, or return nil if there is none.
+func (pages *errorPages) render(data *ErrorData) []byte {
+	status := data.Status
+	name := strconv.Itoa(status)
+	var page []byte
+	if pages.root != nil {
+		page, _ = fs.ReadFile(pages.root, name+".html")
+	}
+	if page == nil && pages.files[name] != "" {
+		var err error
+		page, err = os.ReadFile(pages.files[name])
+		if err != nil {
+			pages.logger.Warn("error-page", "status", status, "err", err.Error())
+			return nil
+		}
+	}
+	if page == nil {
+		return nil
+	}
+
+	tmpl, err := htmltemplate.New(name).Parse(string(page))
+	if err != nil {
+		pages.logger.Warn("error-page", "status", status, "err", err.Error())
+		return nil
+	}
+	buff := bytes.Buffer{}
+	if err := tmpl.Execute(&buff, data); err != nil {
+		pages.logger.Warn("error-page", "status", status, "err", err.Error())
+		return nil
+	}
+	return buff.Bytes()
+}
+
+// Create a random request ID.
+func newRequestID() string {
+	id := [8]byte{}
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/HuguesGuilleus/servHTTP/handlers/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorPages(t *testing.T) {
+	dir := t.TempDir()
+	configured := filepath.Join(dir, "error.html")
+	assert.NoError(t, os.WriteFile(configured, []byte(`<p>{{.Status}} {{.StatusText}}: {{.Path}}</p>`), 0o644))
+	invalid := filepath.Join(dir, "invalid.html")
+	assert.NoError(t, os.WriteFile(invalid, []byte(`{{.Status`), 0o644))
+
+	logger, logBuffer := testLoggerOne()
+	pages := errorPages{
+		logger: logger,
+		root: fstest.MapFS{
+			"404.html": &fstest.MapFile{Data: []byte(`<h1>{{.Status}}</h1><p>{{.Path}} [{{.RequestID}}]</p>`)},
+		},
+		files: map[string]string{
+			"404": configured,
+			"405": configured,
+			"500": invalid,
+			"502": filepath.Join(dir, "missing.html"),
+		},
+	}
+
+	tf := func(url, requestID string, status int) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "http://example.com"+url, nil)
+		if requestID != "" {
+			r.Header.Set("X-Request-Id", requestID)
+		}
+		w := httptest.NewRecorder()
+		pages.serve(w, r, status)
+		assert.Equal(t, status, w.Code)
+		assert.Equal(t, "text/html", w.Header().Get("Content-Type"))
+		return w
+	}
+
+	t.Run("root", func(t *testing.T) {
+		w := tf("/<script>", "abc", 404)
+		assert.Equal(t, "<h1>404</h1><p>/&lt;script&gt; [abc]</p>", w.Body.String())
+		assert.Equal(t, "abc", w.Header().Get("X-Request-Id"))
+	})
+	t.Run("generatedID", func(t *testing.T) {
+		w := tf("/", "", 404)
+		id := w.Header().Get("X-Request-Id")
+		assert.Len(t, id, 16)
+		assert.Equal(t, "<h1>404</h1><p>/ ["+id+"]</p>", w.Body.String())
+	})
+	t.Run("configured", func(t *testing.T) {
+		assert.Equal(t, "<p>405 Method Not Allowed: /f</p>", tf("/f", "", 405).Body.String())
+	})
+	t.Run("builtin", func(t *testing.T) {
+		assert.Equal(t, string(template.Error403("/f")), tf("/f", "", 403).Body.String())
+		assert.Empty(t, logBuffer.String())
+	})
+	t.Run("invalid", func(t *testing.T) {
+		assert.Equal(t, string(template.Error500("/f")), tf("/f", "", 500).Body.String())
+		assert.Contains(t, logBuffer.String(), "level=WARN msg=error-page status=500")
+		logBuffer.Reset()
+		assert.Equal(t, string(template.Error502("/f")), tf("/f", "", 502).Body.String())
+		assert.Contains(t, logBuffer.String(), "level=WARN msg=error-page status=502")
+	})
+}
+
+func TestErrorPagesHandlers(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "404.html"), []byte(`custom {{.Status}} {{.Path}}`), 0o644))
+	logger, _ := testLoggerOne()
+
+	for name, hand := range map[string]http.Handler{
+		"file":  FileOptions(logger, root, "", Options{}),
+		"cache": CacheOptions(logger, root, "", Options{}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/missing", nil))
+			assert.Equal(t, 404, w.Code)
+			assert.Equal(t, "custom 404 /missing", w.Body.String())
+		})
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	assert.Equal(t, ErrorFormatHTML, negotiateFormat("", errorMediaTypes))
+	assert.Equal(t, ErrorFormatHTML, negotiateFormat("*/*", errorMediaTypes))
+	assert.Equal(t, ErrorFormatHTML, negotiateFormat("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", errorMediaTypes))
+	assert.Equal(t, ErrorFormatJSON, negotiateFormat("application/json", errorMediaTypes))
+	assert.Equal(t, ErrorFormatJSON, negotiateFormat("application/problem+json, text/html;q=0.5", errorMediaTypes))
+	assert.Equal(t, ErrorFormatJSON, negotiateFormat("application/*", errorMediaTypes))
+	assert.Equal(t, ErrorFormatText, negotiateFormat("text/plain", errorMediaTypes))
+	assert.Equal(t, ErrorFormatText, negotiateFormat("text/*;q=0.5, text/html;q=0", errorMediaTypes))
+	assert.Equal(t, ErrorFormatHTML, negotiateFormat("image/png", errorMediaTypes))
+}
+
+func TestErrorFormat(t *testing.T) {
+	tf := func(format, accept string, contentType, vary, body string) {
+		logger, _ := testLoggerOne()
+		pages := newErrorPages(logger, nil, nil, Options{ErrorFormat: format})
+		r := httptest.NewRequest("GET", "http://example.com/api/", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		pages.serve(w, r, 502)
+		assert.Equal(t, 502, w.Code)
+		assert.Equal(t, contentType, w.Header().Get("Content-Type"))
+		assert.Equal(t, vary, w.Header().Get("Vary"))
+		assert.Equal(t, body, w.Body.String())
+	}
+
+	tf("", "application/json", "application/problem+json", "Accept", string(template.ErrorJSON(502, "/api/")))
+	tf("", "text/plain", "text/plain; charset=utf-8", "Accept", "502 Bad Gateway\n/api/\n")
+	tf("", "", "text/html", "Accept", string(template.Error502("/api/")))
+	tf(ErrorFormatJSON, "text/html", "application/problem+json", "", string(template.ErrorJSON(502, "/api/")))
+	tf(ErrorFormatText, "", "text/plain; charset=utf-8", "", "502 Bad Gateway\n/api/\n")
+	tf(ErrorFormatHTML, "application/json", "text/html", "", string(template.Error502("/api/")))
+}
+
+func TestReverseProxyError(t *testing.T) {
+	backend := httptest.NewServer(http.NotFoundHandler())
+	backend.Close()
+
+	logger, logBuffer := testLoggerOne()
+	hand := ReverseProxyOptions(logger, backend.URL, "", Options{})
+	r := httptest.NewRequest("GET", "http://example.com/api/", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	hand.ServeHTTP(w, r)
+
+	assert.Equal(t, 502, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	assert.Equal(t, string(template.ErrorJSON(502, "/api/")), w.Body.String())
+	assert.Contains(t, logBuffer.String(), "s=502")
+}
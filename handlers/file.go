@@ -2,26 +2,71 @@ package handlers
 
 import (
 	"errors"
+	htmltemplate "html/template"
+	"io"
 	"io/fs"
 	"log/slog"
+	"mime"
 	"net/http"
 	"path"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/HuguesGuilleus/servHTTP/handlers/template"
 )
 
+// Precompressed sibling files, by server preference.
+var precompressed = []struct{ encoding, ext string }{
+	{"br", ".br"},
+	{"zstd", ".zst"},
+	{"gzip", ".gz"},
+}
+
 type fileHandler struct {
 	common
 	fsys http.FileSystem
+
+	// ETagMeta, ETagHash or "" to disable ETag.
+	etagMode string
+	// Hash ETags by path, to compute them only on file modification.
+	etags sync.Map
+	// Rendered readmes by path, to render them only on file modification.
+	readmes sync.Map
+
+	// Serve the zip files as directories, see serveMount.
+	mountZip bool
+	mounts   archiveMounts
 }
 
+// Create a file handler serving root with the default options.
 func File(logger *slog.Logger, root, cacheControl string) http.Handler {
+	return FileOptions(logger, root, cacheControl, Options{})
+}
+
+// Create a file handler serving root.
+func FileOptions(logger *slog.Logger, root, cacheControl string, opt Options) http.Handler {
+	return FileFS(logger, unionRootFS(root, opt), cacheControl, opt)
+}
+
+// Create a file handler serving fsys, for example an embed.FS.
+// The options Symlinks and Under are ignored.
+func FileFS(logger *slog.Logger, fsys fs.FS, cacheControl string, opt Options) http.Handler {
+	theme := loadTheme(logger, opt.Theme)
 	return &fileHandler{
 		common: common{
 			Logger:       logger,
 			CacheControl: cacheControl,
+			deny:         newDenyPolicy(opt),
+			spa:          newSPAFallback(opt),
+			pages:        newErrorPages(logger, fsys, theme, opt),
+			theme:        theme,
+			pageSize:     opt.PageSize,
+			archive:      newArchivePolicy(opt),
 		},
-		fsys: http.Dir(root),
+		fsys:     http.FS(fsys),
+		etagMode: opt.ETag,
+		mountZip: opt.MountZip,
 	}
 }
 
@@ -30,14 +75,27 @@ func (hand *fileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if hand.mountZip {
+		if i := strings.Index(strings.ToLower(r.URL.Path), ".zip/"); i >= 0 && hand.serveMount(w, r, r.URL.Path[:i+4]) {
+			return
+		}
+	}
+
+	hand.serve(w, r)
+}
+
+// Serve the request, after the common checks.
+func (hand *fileHandler) serve(w http.ResponseWriter, r *http.Request) {
 	file, stat, err := open(hand.fsys, path.Clean(r.URL.Path))
 	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
+		if errors.Is(err, fs.ErrNotExist) && hand.spa.match(r.URL.Path) {
+			hand.serveFallback(w, r)
+		} else if errors.Is(err, fs.ErrNotExist) {
 			LogRequest(hand.Logger, http.StatusNotFound, r)
-			servHTML(w, http.StatusNotFound, template.Error404(r.URL.Path))
+			hand.servError(w, r, http.StatusNotFound)
 		} else {
 			LogRequest(hand.Logger.With("err", err.Error()), http.StatusInternalServerError, r)
-			servHTML(w, http.StatusInternalServerError, template.Error500(r.URL.Path))
+			hand.servError(w, r, http.StatusInternalServerError)
 		}
 		return
 	}
@@ -47,29 +105,157 @@ func (hand *fileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if stat.IsDir() && hand.archive.format(r) != "" {
+		hand.serveArchive(w, r, hand.fsys, r.URL.Path)
+		return
+	}
+
 	if stat.IsDir() {
 		index, info, err := open(hand.fsys, r.URL.Path+"index.html")
 		if err == nil {
 			defer index.Close()
 			if !info.IsDir() {
 				LogRequest(hand.Logger, http.StatusOK, r)
-				http.ServeContent(w, r, "index.html", info.ModTime(), index)
+				hand.serveFile(w, r, r.URL.Path+"index.html", index, info)
 				return
 			}
 		}
 
-		entries, err := file.Readdir(0)
-		if err != nil {
-			LogRequest(hand.Logger.With("err", err.Error()), http.StatusInternalServerError, r)
-			servHTML(w, http.StatusInternalServerError, template.Error500(r.URL.Path))
-			return
+		// Read the directory by chunks, the builder keep only the page entries.
+		builder := template.NewIndexBuilder(r.URL.Path, indexQuery(r, hand.pageSize))
+		for {
+			entries, err := file.Readdir(indexReadSize)
+			for _, entry := range entries {
+				if !hand.deny.denied(r.URL.Path + entry.Name()) {
+					builder.Add(entry)
+				}
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				LogRequest(hand.Logger.With("err", err.Error()), http.StatusInternalServerError, r)
+				hand.servError(w, r, http.StatusInternalServerError)
+				return
+			}
 		}
-		LogRequest(hand.Logger, http.StatusOK, r)
-		servHTML(w, http.StatusOK, template.Index(r.URL.Path, entries))
+		data := builder.Data()
+		if data.Readme != "" {
+			data.ReadmeHTML = hand.readme(r.URL.Path + data.Readme)
+		}
+		w.Header().Add(headerVary, headerAccept)
+		hand.serveIndex(w, r, data)
 	} else {
 		LogRequest(hand.Logger, http.StatusOK, r)
-		http.ServeContent(w, r, stat.Name(), stat.ModTime(), file)
+		hand.serveFile(w, r, path.Clean(r.URL.Path), file, stat)
+	}
+}
+
+// A rendered readme, valid while the file is not modified.
+type fileReadme struct {
+	modTime time.Time
+	size    int64
+	html    htmltemplate.HTML
+}
+
+// Render the readme file name, "" if it can not be read.
+func (hand *fileHandler) readme(name string) htmltemplate.HTML {
+	file, stat, err := open(hand.fsys, name)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	if v, ok := hand.readmes.Load(name); ok {
+		if cached := v.(fileReadme); cached.modTime.Equal(stat.ModTime()) && cached.size == stat.Size() {
+			return cached.html
+		}
 	}
+
+	html := readReadme(file, path.Base(name))
+	hand.readmes.Store(name, fileReadme{stat.ModTime(), stat.Size(), html})
+	return html
+}
+
+// Serve the single page application fallback, or a 404 error.
+func (hand *fileHandler) serveFallback(w http.ResponseWriter, r *http.Request) {
+	file, stat, err := open(hand.fsys, hand.spa.file)
+	if err != nil || stat.IsDir() {
+		if err == nil {
+			file.Close()
+		}
+		LogRequest(hand.Logger, http.StatusNotFound, r)
+		hand.servError(w, r, http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	hand.spa.setCacheControl(w)
+	LogRequest(hand.Logger, http.StatusOK, r)
+	hand.serveFile(w, r, hand.spa.file, file, stat)
+}
+
+// Serve a regular file, or a precompressed sibling (name.br, name.gz...)
+// if the client accept it. The sibling is ignored if older than the file.
+func (hand *fileHandler) serveFile(w http.ResponseWriter, r *http.Request, name string, file http.File, stat fs.FileInfo) {
+	w.Header().Add(headerVary, headerAcceptEncoding)
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		buff := [512]byte{}
+		n, _ := io.ReadFull(file, buff[:])
+		contentType = http.DetectContentType(buff[:n])
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			LogRequest(hand.Logger.With("err", err.Error()), http.StatusInternalServerError, r)
+			hand.servError(w, r, http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set(headerContentType, contentType)
+
+	if etag, err := hand.etag(name, file, stat); err != nil {
+		LogRequest(hand.Logger.With("err", err.Error()), http.StatusInternalServerError, r)
+		hand.servError(w, r, http.StatusInternalServerError)
+		return
+	} else if etag != "" {
+		w.Header().Set(headerETag, etag)
+	}
+
+	if f, ok := file.(*archiveFile); ok && r.Header.Get(headerRange) == "" {
+		if raw, crc, ok := f.rawDeflate(); ok && hand.serveDeflate(w, r, stat, raw, crc) {
+			return
+		}
+	}
+
+	if accept := r.Header.Get(headerAcceptEncoding); accept != "" {
+		siblings := make(map[string]http.File, len(precompressed))
+		available := make([]string, 0, len(precompressed))
+		acceptQuality := parseQuality(accept)
+		for _, p := range precompressed {
+			if encodingQuality(acceptQuality, p.encoding) == 0 {
+				continue
+			}
+			sibling, info, err := open(hand.fsys, name+p.ext)
+			if err != nil {
+				continue
+			} else if info.IsDir() || info.ModTime().Before(stat.ModTime()) {
+				sibling.Close()
+				continue
+			}
+			defer sibling.Close()
+			siblings[p.encoding] = sibling
+			available = append(available, p.encoding)
+		}
+
+		if encoding := negotiateEncoding(accept, available); encoding != "" {
+			w.Header().Set(headerContentEncoding, encoding)
+			if etag := w.Header().Get(headerETag); etag != "" {
+				w.Header().Set(headerETag, encodingETag(etag, encoding))
+			}
+			http.ServeContent(w, r, stat.Name(), stat.ModTime(), siblings[encoding])
+			return
+		}
+	}
+
+	http.ServeContent(w, r, stat.Name(), stat.ModTime(), file)
 }
 
 func open(fsys http.FileSystem, name string) (file http.File, info fs.FileInfo, err error) {
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// ETag from the inode, the size and the modification time.
+	ETagMeta = "meta"
+	// ETag from the sha256 of the content, like the cache handler.
+	ETagHash = "hash"
+)
+
+// A computed hash ETag, valid while the file is not modified.
+type fileETag struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+// Get the ETag of the file, "" if disabled.
+// A file info with a method ETag() in Sys(), like the git blobs, gives
+// its ETag.
+func (hand *fileHandler) etag(name string, file http.File, stat fs.FileInfo) (string, error) {
+	if sys, ok := stat.Sys().(interface{ ETag() string }); ok {
+		return sys.ETag(), nil
+	}
+
+	switch hand.etagMode {
+	case ETagMeta:
+		etag := `"`
+		if inode := fileInode(stat); inode != 0 {
+			etag += strconv.FormatUint(inode, 16) + "-"
+		}
+		etag += strconv.FormatInt(stat.Size(), 16) + "-" + strconv.FormatInt(stat.ModTime().UnixNano(), 16) + `"`
+		return etag, nil
+
+	case ETagHash:
+		if v, ok := hand.etags.Load(name); ok {
+			if cached := v.(fileETag); cached.modTime.Equal(stat.ModTime()) && cached.size == stat.Size() {
+				return cached.etag, nil
+			}
+		}
+
+		hash := sha256.New()
+		if _, err := io.Copy(hash, file); err != nil {
+			return "", err
+		} else if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		etag := cacheETag(hash.Sum(nil))
+		hand.etags.Store(name, fileETag{stat.ModTime(), stat.Size(), etag})
+		return etag, nil
+	}
+
+	return "", nil
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package handlers
+
+import (
+	"io/fs"
+)
+
+// Get the inode number, or zero if unknown.
+func fileInode(fs.FileInfo) uint64 {
+	return 0
+}
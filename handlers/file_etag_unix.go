@@ -0,0 +1,16 @@
+//go:build unix
+
+package handlers
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// Get the inode number, or zero if unknown.
+func fileInode(info fs.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"io/fs"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"testing/fstest"
 	"time"
@@ -24,9 +27,15 @@ var testFS = fstest.MapFS{
 func TestFile(t *testing.T) {
 	logger, _ := testLoggerOne()
 	assert.Equal(t, &fileHandler{
-		common: common{logger, "cache"},
-		fsys:   http.Dir("fs"),
-	}, File(logger, "fs", "cache"))
+		common: common{
+			Logger:       logger,
+			CacheControl: "cache",
+			pages:        errorPages{logger: logger, theme: template.DefaultTheme, root: os.DirFS("fs")},
+			theme:        template.DefaultTheme,
+			archive:      archivePolicy{max: archiveDefaultMax},
+		},
+		fsys: http.FS(os.DirFS("fs")),
+	}, FileOptions(logger, "fs", "cache", Options{}))
 }
 
 func testFileHandler(r *http.Request) (*httptest.ResponseRecorder, *bytes.Buffer) {
@@ -174,3 +183,180 @@ func (failWhenReaddirInfo) Mode() fs.FileMode  { return fs.ModeDir }  // file mo
 func (failWhenReaddirInfo) ModTime() time.Time { return time.Time{} } // modification time
 func (failWhenReaddirInfo) IsDir() bool        { return true }        // abbreviation for Mode().IsDir()
 func (failWhenReaddirInfo) Sys() any           { return nil }         // underlying data source (can return nil)
+
+func TestFilePrecompressed(t *testing.T) {
+	old := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	now := old.Add(time.Hour)
+	fsys := fstest.MapFS{
+		"style.css":    &fstest.MapFile{Data: []byte("body{}"), ModTime: now},
+		"style.css.br": &fstest.MapFile{Data: []byte("br"), ModTime: now},
+		"style.css.gz": &fstest.MapFile{Data: []byte("gzip"), ModTime: now},
+		"data":         &fstest.MapFile{Data: []byte("<!DOCTYPE html>"), ModTime: now},
+		"data.gz":      &fstest.MapFile{Data: []byte("gzip"), ModTime: now},
+		"old.txt":      &fstest.MapFile{Data: []byte("new content"), ModTime: now},
+		"old.txt.gz":   &fstest.MapFile{Data: []byte("old gzip"), ModTime: old},
+	}
+	tf := func(t *testing.T, url, acceptEncoding, rangeHeader string, code int, contentType, contentEncoding, body string) {
+		logger, _ := testLoggerOne()
+		hand := fileHandler{common: common{Logger: logger}, fsys: http.FS(fsys)}
+		r := httptest.NewRequest("GET", url, nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		if rangeHeader != "" {
+			r.Header.Set("Range", rangeHeader)
+		}
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, r)
+
+		assert.Equal(t, code, w.Code)
+		assert.Equal(t, contentType, w.Header().Get("Content-Type"))
+		assert.Equal(t, contentEncoding, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		assert.Equal(t, body, w.Body.String())
+	}
+
+	t.Run("br", func(t *testing.T) {
+		tf(t, "http://example.com/style.css", "gzip, br", "", 200, "text/css; charset=utf-8", "br", "br")
+	})
+	t.Run("gzip", func(t *testing.T) {
+		tf(t, "http://example.com/style.css", "gzip, br;q=0.5", "", 200, "text/css; charset=utf-8", "gzip", "gzip")
+	})
+	t.Run("identity", func(t *testing.T) {
+		tf(t, "http://example.com/style.css", "", "", 200, "text/css; charset=utf-8", "", "body{}")
+		tf(t, "http://example.com/style.css", "zstd", "", 200, "text/css; charset=utf-8", "", "body{}")
+	})
+	t.Run("range", func(t *testing.T) {
+		tf(t, "http://example.com/style.css", "gzip", "bytes=1-2", 206, "text/css; charset=utf-8", "gzip", "zi")
+	})
+	t.Run("sniff", func(t *testing.T) {
+		tf(t, "http://example.com/data", "gzip", "", 200, "text/html; charset=utf-8", "gzip", "gzip")
+	})
+	t.Run("stale", func(t *testing.T) {
+		tf(t, "http://example.com/old.txt", "gzip", "", 200, "text/plain; charset=utf-8", "", "new content")
+	})
+}
+
+func TestFileAutoIndexCompress(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/autoindex/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w, _ := testFileHandler(r)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, []string{"Accept", "Accept-Encoding"}, w.Header().Values("Vary"))
+
+	info, err := (&testFS).Stat("autoindex/file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, string(template.Index("/autoindex/", []fs.FileInfo{info})), gunzip(t, w.Body.Bytes()))
+}
+
+// The file and cache handlers give the same ETags to the same bytes.
+func TestFileCacheETag(t *testing.T) {
+	content := bytes.Repeat([]byte("Hello World "), 100)
+	gz := bytes.Buffer{}
+	zw := gzip.NewWriter(&gz)
+	zw.Write(content)
+	zw.Close()
+	fsys := fstest.MapFS{
+		"hello.txt":    &fstest.MapFile{Data: content},
+		"hello.txt.gz": &fstest.MapFile{Data: gz.Bytes()},
+	}
+	logger, _ := testLoggerOne()
+	file := FileFS(logger, fsys, "", Options{ETag: ETagHash})
+	cache := &cacheHandler{common: common{Logger: logger}}
+	cache.Update(fsys, time.Now())
+
+	for _, encoding := range []string{"", "gzip"} {
+		etags := make([]string, 0, 2)
+		for _, hand := range []http.Handler{file, cache} {
+			r := httptest.NewRequest("GET", "http://example.com/hello.txt", nil)
+			r.Header.Set("Accept-Encoding", encoding)
+			w := httptest.NewRecorder()
+			hand.ServeHTTP(w, r)
+			assert.Equal(t, encoding, w.Header().Get("Content-Encoding"))
+			etags = append(etags, w.Header().Get("ETag"))
+		}
+		assert.NotEmpty(t, etags[0], encoding)
+		assert.Equal(t, etags[0], etags[1], encoding)
+	}
+}
+
+func TestFileETag(t *testing.T) {
+	modTime := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"hello.txt":    &fstest.MapFile{Data: []byte("Hello World"), ModTime: modTime},
+		"hello.txt.gz": &fstest.MapFile{Data: []byte("gzip"), ModTime: modTime},
+	}
+	tf := func(t *testing.T, mode string, headers map[string]string, code int, etag, body string) {
+		logger, _ := testLoggerOne()
+		hand := &fileHandler{common: common{Logger: logger}, fsys: http.FS(fsys), etagMode: mode}
+		for i := 0; i < 2; i++ {
+			r := httptest.NewRequest("GET", "http://example.com/hello.txt", nil)
+			for k, v := range headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			hand.ServeHTTP(w, r)
+			assert.Equal(t, code, w.Code)
+			assert.Equal(t, etag, w.Header().Get("ETag"))
+			assert.Equal(t, body, w.Body.String())
+		}
+	}
+
+	const hashETag = `"pZGm1Av0IEBKARczz7exkNYsZb8LzaMrV7J32a2fFG4"`
+	const metaETag = `"b-140f22fe10510000"`
+	t.Run("none", func(t *testing.T) {
+		tf(t, "", nil, 200, "", "Hello World")
+	})
+	t.Run("meta", func(t *testing.T) {
+		tf(t, ETagMeta, nil, 200, metaETag, "Hello World")
+		tf(t, ETagMeta, map[string]string{"If-None-Match": metaETag}, 304, metaETag, "")
+	})
+	t.Run("hash", func(t *testing.T) {
+		tf(t, ETagHash, nil, 200, hashETag, "Hello World")
+		tf(t, ETagHash, map[string]string{"If-None-Match": hashETag}, 304, hashETag, "")
+		gzipETag := `"pZGm1Av0IEBKARczz7exkNYsZb8LzaMrV7J32a2fFG4-gzip"`
+		tf(t, ETagHash, map[string]string{"Accept-Encoding": "gzip"}, 200, gzipETag, "gzip")
+		tf(t, ETagHash, map[string]string{"Accept-Encoding": "gzip", "If-None-Match": gzipETag}, 304, gzipETag, "")
+		tf(t, ETagHash, map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-1", "If-Range": hashETag}, 200, gzipETag, "gzip")
+		tf(t, ETagHash, map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-1", "If-Range": gzipETag}, 206, gzipETag, "gz")
+		tf(t, ETagHash, map[string]string{"Range": "bytes=0-4", "If-Range": hashETag}, 206, hashETag, "Hello")
+		tf(t, ETagHash, map[string]string{"Range": "bytes=0-4", "If-Range": `"old"`}, 200, hashETag, "Hello World")
+	})
+	t.Run("sameAsCache", func(t *testing.T) {
+		logger, _ := testLoggerOne()
+		hand := cacheHandler{common: common{Logger: logger}}
+		hand.Update(fsys, time.Now())
+		assert.Equal(t, hashETag, hand.snapshot.Load().files["hello.txt"].etag)
+	})
+	t.Run("inode", func(t *testing.T) {
+		root := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(root, "file"), []byte("content"), 0o644))
+		logger, _ := testLoggerOne()
+		w := httptest.NewRecorder()
+		FileOptions(logger, root, "", Options{ETag: ETagMeta}).ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/file", nil))
+		assert.Regexp(t, `^"[0-9a-f]+-7-[0-9a-f]+"$`, w.Header().Get("ETag"))
+	})
+}
+
+func TestFileFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"dir/file.txt": &fstest.MapFile{Data: []byte("file")},
+		"404.html":     &fstest.MapFile{Data: []byte("missing {{.Path}}")},
+	}
+	logger, _ := testLoggerOne()
+	hand := FileFS(logger, fsys, "max-age=60", Options{})
+
+	w := httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/dir/file.txt", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "file", w.Body.String())
+	assert.Equal(t, "max-age=60", w.Header().Get("Cache-Control"))
+
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/dir/", nil))
+	assert.Contains(t, w.Body.String(), `<a href="file.txt">file.txt</a>`)
+
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/yolo", nil))
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, "missing /yolo", w.Body.String())
+}
@@ -0,0 +1,420 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Serve the files of a ref of the git repository u (bare or not) with the
+// git command. The ref (option Ref, default HEAD) is checked all interval
+// (default 20s), and the new commit is served at once when it moves.
+// The ETags are the blob hashes. The symbolic links and the submodules are
+// not served. The blobs are read by one "git cat-file --batch" process and
+// the last read are kept in memory, see GitBlobCacheSize. The large blobs
+// are streamed by their own process, see GitBlobStreamSize.
+//
+// With the option Preview, the other branches and tags are served under this
+// path prefix, for example "/_preview/feature/" for the branch "feature", or
+// "/_preview/fix/x/" for the branch "fix/x". They are listed with the ref
+// check.
+func Git(logger *slog.Logger, u, cacheControl string, opt Options) http.Handler {
+	theme := loadTheme(logger, opt.Theme)
+	hand := &gitHandler{
+		repo:     u,
+		ref:      opt.Ref,
+		batch:    gitBatch{repo: u},
+		blobs:    newGitBlobCache(GitBlobCacheSize),
+		previews: make(map[string]*gitTree),
+	}
+	if hand.ref == "" {
+		hand.ref = "HEAD"
+	}
+	if opt.Preview != "" {
+		hand.preview = strings.TrimSuffix(path.Clean("/"+opt.Preview), "/") + "/"
+	}
+	hand.base = fileHandler{
+		common: common{
+			Logger:       logger,
+			CacheControl: cacheControl,
+			deny:         newDenyPolicy(opt),
+			spa:          newSPAFallback(opt),
+			pages:        newErrorPages(logger, gitCurrentFS{hand}, theme, opt),
+			theme:        theme,
+			pageSize:     opt.PageSize,
+			archive:      newArchivePolicy(opt),
+		},
+		etagMode: opt.ETag,
+	}
+
+	if err := hand.update(); err != nil {
+		logger.Warn("git-update-fail", "repo", u, "ref", hand.ref, "err", err.Error())
+	}
+	go hand.run(cacheInterval(opt))
+
+	return hand
+}
+
+type gitHandler struct {
+	repo string
+	ref  string
+	// The URL path prefix of the previews, ending with a slash, "" to disable.
+	preview string
+	// The options of the tree handlers, without filesystem.
+	base fileHandler
+
+	// The tree of ref, nil before the first success.
+	current atomic.Pointer[gitTree]
+	// Read the blobs.
+	batch gitBatch
+	blobs *gitBlobCache
+
+	// The commits of the preview refs, and their loaded trees, by ref.
+	previewsMutex sync.Mutex
+	refs          map[string]gitRef
+	previews      map[string]*gitTree
+}
+
+// A commit of a ref.
+type gitRef struct {
+	commit  string
+	modTime time.Time
+}
+
+func (hand *gitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tree := hand.current.Load()
+	if hand.preview != "" && strings.HasPrefix(r.URL.Path+"/", hand.preview) {
+		var err error
+		tree, err = hand.previewTree(strings.TrimPrefix(r.URL.Path, hand.preview))
+		if err != nil {
+			hand.base.Logger.Debug("git-preview", "err", err.Error())
+			if hand.base.Serve(w, r) {
+				return
+			}
+			LogRequest(hand.base.Logger, http.StatusNotFound, r)
+			hand.base.servError(w, r, http.StatusNotFound)
+			return
+		}
+	}
+
+	if tree == nil {
+		if hand.base.Serve(w, r) {
+			return
+		}
+		LogRequest(hand.base.Logger, http.StatusInternalServerError, r)
+		hand.base.servError(w, r, http.StatusInternalServerError)
+		return
+	}
+	tree.hand.ServeHTTP(w, r)
+}
+
+// Check the ref all interval.
+func (hand *gitHandler) run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := hand.update(); err != nil {
+			hand.base.Logger.Warn("git-update-fail", "repo", hand.repo, "ref", hand.ref, "err", err.Error())
+		}
+	}
+}
+
+// Load the tree of the ref if it moved, and list the preview refs.
+func (hand *gitHandler) update() error {
+	if hand.preview != "" {
+		if err := hand.updateRefs(); err != nil {
+			return err
+		}
+	}
+
+	commit, modTime, err := hand.resolve(hand.ref)
+	if err != nil {
+		return err
+	} else if current := hand.current.Load(); current != nil && current.commit == commit {
+		return nil
+	}
+
+	tree, err := hand.load(commit, modTime, "", hand.base.spa)
+	if err != nil {
+		return err
+	}
+	hand.current.Store(tree)
+	hand.base.Logger.Info("git-update", "repo", hand.repo, "ref", hand.ref, "commit", commit)
+	return nil
+}
+
+// List the branches and the tags for the previews, and forget the trees of
+// the removed refs.
+func (hand *gitHandler) updateRefs() error {
+	out, err := hand.git("for-each-ref", "--format=%(refname:short) %(objectname) %(*objectname) %(committerdate:unix) %(*committerdate:unix)", "refs/heads/", "refs/tags/")
+	if err != nil {
+		return err
+	}
+	refs := make(map[string]gitRef)
+	for _, line := range strings.Split(string(out), "\n") {
+		// "<ref> <object> <peeled object> <date> <peeled date>", the peeled
+		// fields are set only for the annotated tags.
+		fields := strings.Split(line, " ")
+		if len(fields) != 5 {
+			continue
+		} else if fields[2] != "" {
+			fields[1], fields[3] = fields[2], fields[4]
+		}
+		seconds, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			// Not a commit.
+			continue
+		}
+		refs[fields[0]] = gitRef{fields[1], time.Unix(seconds, 0).UTC()}
+	}
+
+	hand.previewsMutex.Lock()
+	defer hand.previewsMutex.Unlock()
+	hand.refs = refs
+	for ref, tree := range hand.previews {
+		if refs[ref].commit != tree.commit {
+			delete(hand.previews, ref)
+		}
+	}
+	return nil
+}
+
+// Get the tree of the longest preview ref at the beginning of the path p,
+// like "feature/x" for "feature/x/a.txt". The tree is loaded on the first
+// request.
+func (hand *gitHandler) previewTree(p string) (*gitTree, error) {
+	hand.previewsMutex.Lock()
+	defer hand.previewsMutex.Unlock()
+	name, ref, ok := "", gitRef{}, false
+	for i := len(p); i > 0 && !ok; i = strings.LastIndexByte(p[:i], '/') {
+		name = p[:i]
+		ref, ok = hand.refs[name]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown ref for %q", p)
+	} else if tree := hand.previews[name]; tree != nil {
+		return tree, nil
+	}
+	tree, err := hand.load(ref.commit, ref.modTime, hand.preview+name, spaFallback{})
+	if err != nil {
+		return nil, err
+	}
+	hand.previews[name] = tree
+	return tree, nil
+}
+
+// Run the git command in the repository.
+func (hand *gitHandler) git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", hand.repo}, args...)...)
+	stderr := bytes.Buffer{}
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// Get the commit of a ref and its time.
+func (hand *gitHandler) resolve(ref string) (commit string, modTime time.Time, err error) {
+	out, err := hand.git("log", "-1", "--format=%H %ct", ref, "--")
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	commit, unix, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+	seconds, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("git log: invalid output %q", out)
+	}
+	return commit, time.Unix(seconds, 0).UTC(), nil
+}
+
+// Load the tree of the commit, the files modification time is the commit
+// time. The URL paths are prefixed by prefix.
+func (hand *gitHandler) load(commit string, modTime time.Time, prefix string, spa spaFallback) (*gitTree, error) {
+	out, err := hand.git("ls-tree", "-r", "-t", "-l", "-z", "--full-tree", commit)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &gitTree{
+		handler: hand,
+		commit:  commit,
+		prefix:  prefix,
+		entries: map[string]*gitEntry{
+			"": {name: "/", dir: true, modTime: modTime},
+		},
+	}
+	for _, line := range bytes.Split(out, []byte{0}) {
+		// "<mode> <type> <object> <size>\t<path>"
+		meta, name, ok := strings.Cut(string(line), "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 4 {
+			continue
+		}
+		entry := &gitEntry{name: path.Base(name), hash: fields[2], modTime: modTime}
+		switch fields[1] {
+		case "tree":
+			entry.dir = true
+		case "blob":
+			if fields[0] == "120000" {
+				continue
+			}
+			entry.size, _ = strconv.ParseInt(fields[3], 10, 64)
+		default:
+			continue
+		}
+
+		parent := tree.entries[strings.TrimPrefix(path.Dir("/"+name), "/")]
+		if parent == nil {
+			continue
+		}
+		parent.children = append(parent.children, entry.name)
+		tree.entries[name] = entry
+	}
+	for _, entry := range tree.entries {
+		slices.Sort(entry.children)
+	}
+
+	common := hand.base.common
+	common.spa = spa
+	tree.hand = &fileHandler{common: common, fsys: tree, etagMode: hand.base.etagMode}
+	return tree, nil
+}
+
+// The files of a commit, a http.FileSystem.
+type gitTree struct {
+	handler *gitHandler
+	commit  string
+	// The URL path prefix, without ending slash.
+	prefix string
+	// The entries by path without leading slash, "" for the root.
+	entries map[string]*gitEntry
+	// The handler serving this tree.
+	hand *fileHandler
+}
+
+func (tree *gitTree) Open(name string) (http.File, error) {
+	p := path.Clean("/" + name)
+	if tree.prefix != "" {
+		if p != tree.prefix && !strings.HasPrefix(p, tree.prefix+"/") {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		p = "/" + strings.TrimPrefix(p, tree.prefix)
+	}
+	key := strings.Trim(p, "/")
+	entry := tree.entries[key]
+	if entry == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	file := &gitFile{tree: tree, key: key, entry: entry, ReadSeeker: bytes.NewReader(nil)}
+	if entry.size >= GitBlobStreamSize && !entry.dir {
+		file.ReadSeeker = &gitBlobReader{repo: tree.handler.repo, hash: entry.hash, size: entry.size}
+	} else if !entry.dir {
+		content, err := tree.handler.blob(entry.hash)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		file.ReadSeeker = bytes.NewReader(content)
+	}
+	return file, nil
+}
+
+// Get the content of a blob, from the cache or the batch process.
+func (hand *gitHandler) blob(hash string) ([]byte, error) {
+	if content, ok := hand.blobs.get(hash); ok {
+		return content, nil
+	}
+	content, err := hand.batch.blob(hash)
+	if err != nil {
+		return nil, err
+	}
+	hand.blobs.put(hash, content)
+	return content, nil
+}
+
+// An opened file or directory of a git tree.
+type gitFile struct {
+	io.ReadSeeker
+	tree  *gitTree
+	key   string
+	entry *gitEntry
+	// The position in the children for Readdir.
+	dirOffset int
+}
+
+func (f *gitFile) Stat() (fs.FileInfo, error) { return f.entry, nil }
+func (f *gitFile) Close() error {
+	if closer, ok := f.ReadSeeker.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (f *gitFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if !f.entry.dir {
+		return nil, errors.New("not a directory")
+	}
+	children := f.entry.children[f.dirOffset:]
+	if count > 0 {
+		if len(children) == 0 {
+			return nil, io.EOF
+		}
+		children = children[:min(count, len(children))]
+	}
+	f.dirOffset += len(children)
+
+	infos := make([]fs.FileInfo, len(children))
+	for i, name := range children {
+		infos[i] = f.tree.entries[strings.TrimPrefix(f.key+"/"+name, "/")]
+	}
+	return infos, nil
+}
+
+// A file or a directory of a git tree, it's also its fs.FileInfo.
+type gitEntry struct {
+	name    string
+	hash    string
+	size    int64
+	dir     bool
+	modTime time.Time
+	// The children names of a directory, sorted.
+	children []string
+}
+
+func (entry *gitEntry) Name() string       { return entry.name }
+func (entry *gitEntry) Size() int64        { return entry.size }
+func (entry *gitEntry) ModTime() time.Time { return entry.modTime }
+func (entry *gitEntry) IsDir() bool        { return entry.dir }
+func (entry *gitEntry) Sys() any           { return entry }
+func (entry *gitEntry) Mode() fs.FileMode {
+	if entry.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// The ETag of the file, from the blob hash.
+func (entry *gitEntry) ETag() string { return `"` + entry.hash + `"` }
+
+// The fs.FS of the current tree of a git handler, used for the error pages.
+type gitCurrentFS struct{ hand *gitHandler }
+
+func (fsys gitCurrentFS) Open(name string) (fs.File, error) {
+	tree := fsys.hand.current.Load()
+	if tree == nil || !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return tree.Open("/" + name)
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"bufio"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Maximum size in bytes of the blobs kept in memory by each git handler,
+// 0 to disable. A larger blob is never kept.
+var GitBlobCacheSize int64 = 32 * 1024 * 1024
+
+// Minimum size in bytes of the blobs streamed by their own git process,
+// without reading them in memory nor holding the batch process.
+var GitBlobStreamSize int64 = 1024 * 1024
+
+// A long running "git cat-file --batch" process, started on the first read
+// and restarted after an error.
+type gitBatch struct {
+	repo string
+
+	mutex  sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+// Read the content of a blob.
+func (batch *gitBatch) blob(hash string) ([]byte, error) {
+	batch.mutex.Lock()
+	defer batch.mutex.Unlock()
+
+	if batch.cmd == nil {
+		if err := batch.start(); err != nil {
+			return nil, err
+		}
+	}
+	content, err := batch.read(hash)
+	if err != nil && !errors.Is(err, errGitNotBlob) {
+		batch.stop()
+	}
+	return content, err
+}
+
+// The object is missing or is not a blob, the process can read other objects.
+var errGitNotBlob = errors.New("not a blob")
+
+// Send the request and read the response "<oid> <type> <size>\n<content>\n",
+// or "<object> missing\n".
+func (batch *gitBatch) read(hash string) ([]byte, error) {
+	if strings.ContainsAny(hash, " \n") {
+		return nil, fmt.Errorf("git cat-file: invalid hash %q", hash)
+	} else if _, err := io.WriteString(batch.stdin, hash+"\n"); err != nil {
+		return nil, fmt.Errorf("git cat-file: %w", err)
+	}
+
+	header, err := batch.stdout.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("git cat-file: %w", err)
+	}
+	fields := strings.Fields(header)
+	if len(fields) == 2 && fields[1] == "missing" {
+		return nil, fmt.Errorf("git cat-file: %s is missing: %w", hash, errGitNotBlob)
+	} else if len(fields) != 3 {
+		return nil, fmt.Errorf("git cat-file: invalid header %q", header)
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("git cat-file: invalid header %q", header)
+	}
+
+	content := make([]byte, size+1)
+	if _, err := io.ReadFull(batch.stdout, content); err != nil {
+		return nil, fmt.Errorf("git cat-file: %w", err)
+	} else if content[size] != '\n' {
+		return nil, errors.New("git cat-file: invalid content end")
+	} else if fields[1] != "blob" {
+		return nil, fmt.Errorf("git cat-file: %s is a %s: %w", hash, fields[1], errGitNotBlob)
+	}
+	return content[:size], nil
+}
+
+func (batch *gitBatch) start() error {
+	cmd := exec.Command("git", "-C", batch.repo, "cat-file", "--batch")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("git cat-file: %w", err)
+	}
+	batch.cmd = cmd
+	batch.stdin = stdin
+	batch.stdout = bufio.NewReader(stdout)
+	return nil
+}
+
+// Stop the process, the next read starts a new one.
+func (batch *gitBatch) stop() {
+	batch.stdin.Close()
+	batch.cmd.Process.Kill()
+	batch.cmd.Wait()
+	batch.cmd = nil
+	batch.stdin = nil
+	batch.stdout = nil
+}
+
+// The last read blobs by hash, in a limited size.
+type gitBlobCache struct {
+	max int64
+
+	mutex sync.Mutex
+	size  int64
+	lru   list.List
+	blobs map[string]*list.Element
+}
+
+type gitBlob struct {
+	hash    string
+	content []byte
+}
+
+func newGitBlobCache(max int64) *gitBlobCache {
+	return &gitBlobCache{max: max, blobs: make(map[string]*list.Element)}
+}
+
+// Get a blob content.
+func (cache *gitBlobCache) get(hash string) ([]byte, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element := cache.blobs[hash]
+	if element == nil {
+		return nil, false
+	}
+	cache.lru.MoveToFront(element)
+	return element.Value.(*gitBlob).content, true
+}
+
+// Add a blob, and remove the least recently used to stay under the size.
+func (cache *gitBlobCache) put(hash string, content []byte) {
+	if int64(len(content)) > cache.max {
+		return
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.blobs[hash] != nil {
+		return
+	}
+	cache.blobs[hash] = cache.lru.PushFront(&gitBlob{hash, content})
+	cache.size += int64(len(content))
+	for cache.size > cache.max {
+		blob := cache.lru.Remove(cache.lru.Back()).(*gitBlob)
+		delete(cache.blobs, blob.hash)
+		cache.size -= int64(len(blob.content))
+	}
+}
+
+// A large blob read by its own "git cat-file blob" process, started on the
+// first read and started again to read before its position.
+type gitBlobReader struct {
+	repo string
+	hash string
+	size int64
+	// The position of the next read.
+	offset int64
+
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	// The position in the process output.
+	position int64
+}
+
+func (r *gitBlobReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	} else if r.cmd != nil && r.position > r.offset {
+		r.Close()
+	}
+	if r.cmd == nil {
+		cmd := exec.Command("git", "-C", r.repo, "cat-file", "blob", r.hash)
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return 0, err
+		} else if err := cmd.Start(); err != nil {
+			return 0, fmt.Errorf("git cat-file: %w", err)
+		}
+		r.cmd, r.stdout, r.position = cmd, stdout, 0
+	}
+	if r.position < r.offset {
+		n, err := io.CopyN(io.Discard, r.stdout, r.offset-r.position)
+		r.position += n
+		if err != nil {
+			return 0, fmt.Errorf("git cat-file: %w", err)
+		}
+	}
+
+	n, err := r.stdout.Read(p[:min(int64(len(p)), r.size-r.offset)])
+	r.offset += int64(n)
+	r.position += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = fmt.Errorf("git cat-file: %w", io.ErrUnexpectedEOF)
+	}
+	return n, err
+}
+
+func (r *gitBlobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("git blob: negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Stop the process.
+func (r *gitBlobReader) Close() error {
+	if r.cmd != nil {
+		r.stdout.Close()
+		r.cmd.Process.Kill()
+		r.cmd.Wait()
+		r.cmd = nil
+		r.stdout = nil
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"io"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Create a bare repository with the branches main and feature,
+// return the repository and the work tree.
+func testGitRepo(t *testing.T) (repo, work string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	repo, work = filepath.Join(t.TempDir(), "repo.git"), t.TempDir()
+	testGit(t, "", "init", "--quiet", "--bare", "--initial-branch=main", repo)
+	testGit(t, "", "init", "--quiet", "--initial-branch=main", work)
+	testGit(t, work, "remote", "add", "origin", repo)
+
+	testGitCommit(t, work, map[string]string{
+		"a.txt":        "a v1",
+		"dir/b.txt":    "b",
+		".gitignore":   "*.o",
+		"404.html":     "missing {{.Path}}",
+		"index/x.html": "x",
+	})
+	assert.NoError(t, os.Symlink("/etc/passwd", filepath.Join(work, "link")))
+	testGit(t, work, "add", "link")
+	testGit(t, work, "commit", "--quiet", "-m", "link")
+	testGit(t, work, "push", "--quiet", "origin", "main")
+
+	testGit(t, work, "checkout", "--quiet", "-b", "feature")
+	testGitCommit(t, work, map[string]string{"a.txt": "a feature"})
+	testGit(t, work, "push", "--quiet", "origin", "feature")
+	testGit(t, work, "checkout", "--quiet", "main")
+	return
+}
+
+func testGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_GLOBAL=/dev/null",
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com", "GIT_AUTHOR_DATE=2024-01-02T03:04:05Z",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com", "GIT_COMMITTER_DATE=2024-01-02T03:04:05Z",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func testGitCommit(t *testing.T, work string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(work, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		assert.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	}
+	testGit(t, work, "add", "--all")
+	testGit(t, work, "commit", "--quiet", "-m", "commit")
+}
+
+func TestGit(t *testing.T) {
+	repo, work := testGitRepo(t)
+	logger, _ := testLoggerOne()
+	hand := Git(logger, repo, "", Options{Preview: "/_preview"}).(*gitHandler)
+	serve := func(url string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "http://example.com"+url, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("/a.txt")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "a v1", w.Body.String())
+	assert.Equal(t, `"`+testGit(t, repo, "rev-parse", "main:a.txt")+`"`, w.Header().Get("ETag"))
+	assert.Equal(t, "Tue, 02 Jan 2024 03:04:05 GMT", w.Header().Get("Last-Modified"))
+	assert.Equal(t, 304, serve("/a.txt", "If-None-Match", w.Header().Get("ETag")).Code)
+	assert.Equal(t, "v1", serve("/a.txt", "Range", "bytes=2-").Body.String())
+
+	assert.Equal(t, "b", serve("/dir/b.txt").Body.String())
+	assert.Contains(t, serve("/dir/").Body.String(), `<a href="b.txt">b.txt</a>`)
+	assert.Equal(t, 301, serve("/dir").Code)
+	assert.Equal(t, 404, serve("/.gitignore").Code)
+	assert.Equal(t, 404, serve("/link").Code)
+	w = serve("/missing")
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, "missing /missing", w.Body.String())
+
+	// The previews.
+	assert.Equal(t, "a feature", serve("/_preview/feature/a.txt").Body.String())
+	assert.Equal(t, "b", serve("/_preview/feature/dir/b.txt").Body.String())
+	assert.Equal(t, 301, serve("/_preview/feature").Code)
+	assert.Contains(t, serve("/_preview/feature/").Body.String(), `<a href="a.txt">a.txt</a>`)
+	assert.Equal(t, 404, serve("/_preview/unknown/a.txt").Code)
+	assert.Equal(t, 404, serve("/_preview/--help/a.txt").Code)
+	assert.Equal(t, 404, serve("/_preview/main~1/a.txt").Code)
+
+	// The ref moves.
+	testGitCommit(t, work, map[string]string{"a.txt": "a v2"})
+	testGit(t, work, "push", "--quiet", "origin", "main")
+	assert.Equal(t, "a v1", serve("/a.txt").Body.String())
+	assert.NoError(t, hand.update())
+	assert.Equal(t, "a v2", serve("/a.txt").Body.String())
+	assert.Equal(t, "a feature", serve("/_preview/feature/a.txt").Body.String())
+	assert.Equal(t, "a v2", serve("/_preview/main/a.txt").Body.String())
+
+	// The preview refs are listed with the update.
+	testGit(t, repo, "branch", "later", "main")
+	testGit(t, repo, "tag", "--annotate", "-m", "tag", "v1", "feature")
+	testGit(t, repo, "branch", "--delete", "--force", "feature")
+	assert.Equal(t, 404, serve("/_preview/later/a.txt").Code)
+	assert.Equal(t, "a feature", serve("/_preview/feature/a.txt").Body.String())
+	assert.NoError(t, hand.update())
+	assert.Equal(t, "a v2", serve("/_preview/later/a.txt").Body.String())
+	assert.Equal(t, "a feature", serve("/_preview/v1/a.txt").Body.String())
+	assert.Equal(t, 404, serve("/_preview/feature/a.txt").Code)
+
+	// The refs with a slash, the longest ref wins.
+	testGit(t, repo, "branch", "fix/x", "v1")
+	testGit(t, repo, "branch", "v1/y", "main")
+	assert.NoError(t, hand.update())
+	assert.Equal(t, "a feature", serve("/_preview/fix/x/a.txt").Body.String())
+	assert.Contains(t, serve("/_preview/fix/x/").Body.String(), `<a href="a.txt">a.txt</a>`)
+	assert.Equal(t, 301, serve("/_preview/fix/x").Code)
+	assert.Equal(t, 404, serve("/_preview/fix/a.txt").Code)
+	assert.Equal(t, "a v2", serve("/_preview/v1/y/a.txt").Body.String())
+	assert.Equal(t, "a feature", serve("/_preview/v1/a.txt").Body.String())
+}
+
+func TestGitBatch(t *testing.T) {
+	repo, _ := testGitRepo(t)
+	batch := gitBatch{repo: repo}
+	defer func() { batch.mutex.Lock(); batch.stop() }()
+
+	content, err := batch.blob(testGit(t, repo, "rev-parse", "main:a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a v1", string(content))
+	cmd := batch.cmd
+
+	_, err = batch.blob(strings.Repeat("0", 40))
+	assert.ErrorIs(t, err, errGitNotBlob)
+	_, err = batch.blob(testGit(t, repo, "rev-parse", "main:dir"))
+	assert.ErrorIs(t, err, errGitNotBlob)
+	assert.Same(t, cmd, batch.cmd)
+
+	// Restart after the process end.
+	cmd.Process.Kill()
+	cmd.Wait()
+	_, err = batch.blob(testGit(t, repo, "rev-parse", "main:dir/b.txt"))
+	assert.Error(t, err)
+	content, err = batch.blob(testGit(t, repo, "rev-parse", "main:dir/b.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "b", string(content))
+}
+
+func TestGitBlobStream(t *testing.T) {
+	defer func(size int64) { GitBlobStreamSize = size }(GitBlobStreamSize)
+	GitBlobStreamSize = 4
+
+	repo, _ := testGitRepo(t)
+	logger, _ := testLoggerOne()
+	hand := Git(logger, repo, "", Options{}).(*gitHandler)
+	serve := func(url string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "http://example.com"+url, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, "a v1", serve("/a.txt").Body.String())
+	assert.Equal(t, "v1", serve("/a.txt", "Range", "bytes=2-").Body.String())
+	assert.Equal(t, " ", serve("/a.txt", "Range", "bytes=1-1").Body.String())
+	assert.Nil(t, hand.batch.cmd, "the large blobs are not read by the batch process")
+	assert.Equal(t, "b", serve("/dir/b.txt").Body.String())
+	assert.NotNil(t, hand.batch.cmd)
+
+	r := &gitBlobReader{repo: repo, hash: testGit(t, repo, "rev-parse", "main:a.txt"), size: 4}
+	defer r.Close()
+	buff := make([]byte, 2)
+	n, err := r.Read(buff)
+	assert.NoError(t, err)
+	assert.Equal(t, "a ", string(buff[:n]))
+	r.Seek(1, io.SeekStart)
+	content, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, " v1", string(content))
+}
+
+func TestGitBlobCache(t *testing.T) {
+	cache := newGitBlobCache(10)
+	cache.put("a", []byte("aaaa"))
+	cache.put("b", []byte("bbbb"))
+	cache.put("big", []byte("0123456789+"))
+	_, ok := cache.get("a")
+	assert.True(t, ok)
+	cache.put("c", []byte("cccc"))
+
+	content, ok := cache.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "aaaa", string(content))
+	_, ok = cache.get("b")
+	assert.False(t, ok)
+	_, ok = cache.get("big")
+	assert.False(t, ok)
+	_, ok = cache.get("c")
+	assert.True(t, ok)
+	assert.Equal(t, int64(8), cache.size)
+}
+
+func TestGitRef(t *testing.T) {
+	repo, _ := testGitRepo(t)
+	logger, _ := testLoggerOne()
+	hand := Git(logger, repo, "", Options{Ref: "feature"})
+	w := httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/a.txt", nil))
+	assert.Equal(t, "a feature", w.Body.String())
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/_preview/main/a.txt", nil))
+	assert.Equal(t, 404, w.Code)
+
+	logger, log := testLoggerOne()
+	hand = Git(logger, filepath.Join(t.TempDir(), "missing"), "", Options{})
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/a.txt", nil))
+	assert.Equal(t, 500, w.Code)
+	assert.Contains(t, log.String(), "git-update-fail")
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	htmltemplate "html/template"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/HuguesGuilleus/servHTTP/handlers/template"
+)
+
+// Formats of the generated directory indexes.
+const (
+	IndexFormatHTML = "html"
+	IndexFormatJSON = "json"
+	IndexFormatText = "text"
+	IndexFormatXML  = "xml"
+)
+
+// The index formats by media type, ordered by server preference.
+var indexMediaTypes = []formatMediaType{
+	{htmlMIME, IndexFormatHTML},
+	{"application/json", IndexFormatJSON},
+	{"text/plain", IndexFormatText},
+	{"application/xml", IndexFormatXML},
+	{"text/xml", IndexFormatXML},
+}
+
+// Number of entries read at once in a directory.
+const indexReadSize = 1024
+
+// Maximum size of a readme rendered by the server,
+// a larger readme is loaded by the client.
+var ReadmeMaxSize int64 = 256 * 1024
+
+// Render the readme of an index from the file f.
+// Return "" if the file can not be read or is too large.
+func readReadme(f io.Reader, name string) htmltemplate.HTML {
+	content, err := io.ReadAll(io.LimitReader(f, ReadmeMaxSize+1))
+	if err != nil || int64(len(content)) > ReadmeMaxSize {
+		return ""
+	}
+	return template.ReadmeHTML(name, content)
+}
+
+// Get the sort and the page of the index from the query
+// "sort" (name, size or mtime), "order" (asc or desc) and "page".
+func indexQuery(r *http.Request, pageSize int) template.IndexQuery {
+	values := r.URL.Query()
+	query := template.IndexQuery{
+		Desc:     values.Get("order") == "desc",
+		Page:     1,
+		PageSize: pageSize,
+	}
+	switch sort := values.Get("sort"); sort {
+	case template.IndexSortSize, template.IndexSortTime:
+		query.Sort = sort
+	}
+	if page, err := strconv.Atoi(values.Get("page")); err == nil && page > 1 && pageSize > 0 {
+		// Keep Page*PageSize in an int.
+		query.Page = min(page, math.MaxInt/pageSize)
+	}
+	return query
+}
+
+// Get the index format from the query "format", else from the Accept header.
+// An unknown format is HTML.
+func indexFormat(r *http.Request) string {
+	switch format := r.URL.Query().Get("format"); format {
+	case IndexFormatHTML, IndexFormatJSON, IndexFormatText, IndexFormatXML:
+		return format
+	}
+	return negotiateFormat(r.Header.Get(headerAccept), indexMediaTypes)
+}
+
+// Serve the index in the format of the request.
+func (hand *common) serveIndex(w http.ResponseWriter, r *http.Request, data *template.IndexData) {
+	data.Archive = hand.archive.enabled
+	data.Upload = hand.uploadForm
+	if format := indexFormat(r); format != IndexFormatHTML {
+		hand.serveIndexFormat(w, r, format, data)
+		return
+	}
+
+	body, err := renderIndex(hand.theme, data)
+	if err != nil {
+		LogRequest(hand.Logger.With("err", err.Error()), http.StatusInternalServerError, r)
+		hand.servError(w, r, http.StatusInternalServerError)
+		return
+	}
+	LogRequest(hand.Logger, http.StatusOK, r)
+	cw := newCompressWriter(w, r)
+	defer cw.Close()
+	servHTML(cw, http.StatusOK, body)
+}
+
+// Serve the index in a machine readable format (not HTML).
+func (hand *common) serveIndexFormat(w http.ResponseWriter, r *http.Request, format string, data *template.IndexData) {
+	var body []byte
+	var contentType string
+	switch format {
+	case IndexFormatJSON:
+		body, contentType = template.IndexJSON(data), "application/json"
+	case IndexFormatXML:
+		body, contentType = template.IndexXML(data), "application/xml"
+	default:
+		body, contentType = template.IndexText(data), textMIME
+	}
+
+	LogRequest(hand.Logger, http.StatusOK, r)
+	cw := newCompressWriter(w, r)
+	defer cw.Close()
+	servBody(cw, http.StatusOK, contentType, body)
+}
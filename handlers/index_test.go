@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"fmt"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/HuguesGuilleus/servHTTP/handlers/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexFormat(t *testing.T) {
+	tf := func(url, accept, expected string) {
+		r := httptest.NewRequest("GET", "http://example.com"+url, nil)
+		r.Header.Set("Accept", accept)
+		assert.Equal(t, expected, indexFormat(r), url+" "+accept)
+	}
+	tf("/", "", IndexFormatHTML)
+	tf("/", "text/html,*/*;q=0.8", IndexFormatHTML)
+	tf("/", "application/json", IndexFormatJSON)
+	tf("/", "text/plain", IndexFormatText)
+	tf("/", "text/xml", IndexFormatXML)
+	tf("/?format=json", "text/html", IndexFormatJSON)
+	tf("/?format=xml", "", IndexFormatXML)
+	tf("/?format=text", "", IndexFormatText)
+	tf("/?format=html", "application/json", IndexFormatHTML)
+	tf("/?format=yaml", "application/json", IndexFormatJSON)
+}
+
+func TestIndexFormatHandlers(t *testing.T) {
+	logger, _ := testLoggerOne()
+	cache := &cacheHandler{common: common{Logger: logger, deny: newDenyPolicy(Options{Deny: []string{"*.bak"}})}}
+	cache.Update(testDenyFS, time.Now())
+	handlers := map[string]http.Handler{
+		"file": &fileHandler{
+			common: common{Logger: logger, deny: newDenyPolicy(Options{Deny: []string{"*.bak"}})},
+			fsys:   http.FS(testDenyFS),
+		},
+		"cache": cache,
+	}
+
+	info, err := testDenyFS.Stat("file.txt")
+	assert.NoError(t, err)
+	wellKnown, err := testDenyFS.Stat(".well-known")
+	assert.NoError(t, err)
+	publicDir, err := testDenyFS.Stat("public")
+	assert.NoError(t, err)
+	data := template.NewIndexData("/", []fs.FileInfo{wellKnown, info, publicDir})
+
+	for name, hand := range handlers {
+		t.Run(name, func(t *testing.T) {
+			tf := func(url, accept string, contentType string, body []byte) {
+				r := httptest.NewRequest("GET", "http://example.com"+url, nil)
+				r.Header.Set("Accept", accept)
+				w := httptest.NewRecorder()
+				hand.ServeHTTP(w, r)
+				assert.Equal(t, 200, w.Code)
+				assert.Contains(t, w.Header().Get("Content-Type"), contentType)
+				assert.Contains(t, w.Header().Values("Vary"), "Accept")
+				assert.Equal(t, string(body), w.Body.String())
+			}
+			tf("/", "application/json", "application/json", template.IndexJSON(data))
+			tf("/?format=json", "", "application/json", template.IndexJSON(data))
+			tf("/?format=xml", "", "application/xml", template.IndexXML(data))
+			tf("/", "text/plain", "text/plain; charset=utf-8", []byte("public/\nfile.txt\n"))
+			tf("/", "", "text/html", template.Index("/", []fs.FileInfo{wellKnown, info, publicDir}))
+		})
+	}
+}
+
+func TestIndexQuery(t *testing.T) {
+	tf := func(url string, pageSize int, expected template.IndexQuery) {
+		r := httptest.NewRequest("GET", "http://example.com"+url, nil)
+		assert.Equal(t, expected, indexQuery(r, pageSize), url)
+	}
+	tf("/", 0, template.IndexQuery{Page: 1})
+	tf("/?sort=size&order=desc", 0, template.IndexQuery{Sort: template.IndexSortSize, Desc: true, Page: 1})
+	tf("/?sort=mtime&order=asc", 10, template.IndexQuery{Sort: template.IndexSortTime, Page: 1, PageSize: 10})
+	tf("/?sort=name&page=3", 10, template.IndexQuery{Page: 3, PageSize: 10})
+	tf("/?sort=other&page=-1", 10, template.IndexQuery{Page: 1, PageSize: 10})
+	tf("/?page=3", 0, template.IndexQuery{Page: 1})
+	tf("/?page=9223372036854775807", 10, template.IndexQuery{Page: math.MaxInt / 10, PageSize: 10})
+}
+
+func TestIndexPagination(t *testing.T) {
+	modTime := time.Date(2023, 12, 2, 13, 23, 25, 0, time.UTC)
+	fsys := fstest.MapFS{}
+	for i := 0; i < 1000; i++ {
+		fsys[fmt.Sprintf("dir/%04d.log", i)] = &fstest.MapFile{Data: make([]byte, i%7), ModTime: modTime}
+	}
+
+	logger, _ := testLoggerOne()
+	c := common{Logger: logger, pageSize: 100}
+	cache := &cacheHandler{common: c}
+	cache.Update(fsys, time.Now())
+	handlers := map[string]http.Handler{
+		"file":  &fileHandler{common: c, fsys: http.FS(fsys)},
+		"cache": cache,
+	}
+
+	for name, hand := range handlers {
+		t.Run(name, func(t *testing.T) {
+			tf := func(url string) string {
+				w := httptest.NewRecorder()
+				hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+url, nil))
+				assert.Equal(t, 200, w.Code)
+				return w.Body.String()
+			}
+
+			body := tf("/dir/")
+			assert.Contains(t, body, `<a href="0099.log">`)
+			assert.NotContains(t, body, `<a href="0100.log">`)
+			assert.Contains(t, body, `<p id=g>1/10 <a href="?page=2">&gt;</a></p>`)
+
+			body = tf("/dir/?page=2&order=desc")
+			assert.Contains(t, body, `<a href="0899.log">`)
+			assert.Contains(t, body, `<a href="0800.log">`)
+			assert.NotContains(t, body, `<a href="0799.log">`)
+
+			body = tf("/dir/?page=9223372036854775807")
+			assert.NotContains(t, body, `.log">`)
+
+			text := tf("/dir/?format=text&sort=size&order=desc")
+			assert.Equal(t, 100, strings.Count(text, "\n"))
+			assert.True(t, strings.HasPrefix(text, "0993.log\n0986.log\n"), text[:20])
+		})
+	}
+}
+
+func TestIndexReadme(t *testing.T) {
+	defer func(size int64) { ReadmeMaxSize = size }(ReadmeMaxSize)
+	ReadmeMaxSize = 10
+
+	fsys := fstest.MapFS{
+		"doc/README.md": &fstest.MapFile{Data: []byte("# <Doc>")},
+		"txt/README":    &fstest.MapFile{Data: []byte("a < b")},
+		"big/README.md": &fstest.MapFile{Data: []byte("# Too large readme")},
+	}
+	logger, _ := testLoggerOne()
+	cache := &cacheHandler{common: common{Logger: logger}}
+	cache.Update(fsys, time.Now())
+	handlers := map[string]http.Handler{
+		"file":  &fileHandler{common: common{Logger: logger}, fsys: http.FS(fsys)},
+		"cache": cache,
+	}
+
+	for name, hand := range handlers {
+		t.Run(name, func(t *testing.T) {
+			tf := func(url, expected string) {
+				w := httptest.NewRecorder()
+				hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+url, nil))
+				assert.Equal(t, 200, w.Code)
+				assert.Contains(t, w.Body.String(), expected)
+			}
+			tf("/doc/", "<article id=m><h1>&lt;Doc&gt;</h1>\n</article>")
+			tf("/doc/?sort=size", "<article id=m><h1>&lt;Doc&gt;</h1>\n</article>")
+			tf("/txt/", "<article id=m><pre>a &lt; b</pre></article>")
+			tf("/big/", "<pre id=r>README.md</pre>")
+		})
+	}
+}
+
+func TestFileReadmeCache(t *testing.T) {
+	fsys := fstest.MapFS{"README.md": &fstest.MapFile{Data: []byte("# Old")}}
+	logger, _ := testLoggerOne()
+	hand := &fileHandler{common: common{Logger: logger}, fsys: http.FS(fsys)}
+	tf := func(expected string) {
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), expected)
+	}
+
+	tf("<h1>Old</h1>")
+	// Same size and modification time: the rendered readme is reused.
+	fsys["README.md"] = &fstest.MapFile{Data: []byte("# New")}
+	tf("<h1>Old</h1>")
+	fsys["README.md"] = &fstest.MapFile{Data: []byte("# New"), ModTime: time.Now()}
+	tf("<h1>New</h1>")
+}
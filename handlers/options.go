@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"time"
+)
+
+// Options of the handlers, decoded with the handler config.
+// The zero value keeps the default behavior.
+type Options struct {
+	// Interval between two scans of the cache root, or between two checks
+	// of the git handler ref.
+	// On Linux, the cache handler use inotify and scan only if it fail.
+	// A negative interval disables the updates after the first one.
+	// Default: 20s
+	Interval time.Duration `toml:"interval"`
+	// Memory budget in bytes of the cache handler for the file contents.
+	// The least recently used files are evicted and read again on request.
+	// Default: no limit
+	MaxMemory int64 `toml:"max_memory"`
+	// Bigger files in bytes are not kept in memory by the cache handler,
+	// there are served from the filesystem.
+	// Default: no limit
+	MaxFileSize int64 `toml:"max_file_size"`
+	// Directory to save the ETags and the compressed versions of the cache
+	// handler files, to reuse them after a restart.
+	// Default: no persistence
+	CacheDir string `toml:"cache_dir"`
+	// Name of the CacheFS handler entries in CacheDir, stable between the
+	// restarts. The Cache handler entries are identified by the roots.
+	// Default: the order of creation of the CacheFS handlers of CacheDir
+	CacheName string `toml:"-"`
+	// The fs.FS of CacheFS is immutable, like a fs.Sub of an embed.FS,
+	// so it is scanned only once. An embed.FS is always static.
+	// Default: false
+	Static bool `toml:"-"`
+	// ETag of the file handler: ETagMeta ("meta") from the inode, size and
+	// modification time; or ETagHash ("hash") from the content, identical
+	// to the cache handler ETags.
+	// Default: no ETag
+	ETag string `toml:"etag"`
+
+	// Serve the names beginning with a dot in the file and cache handlers.
+	// "/.well-known/" is always allowed.
+	// Default: false
+	AllowDotfiles bool `toml:"allow_dotfiles"`
+	// Glob patterns of the denied names, for example: "*.bak", "*~".
+	Deny []string `toml:"deny"`
+	// Status code of denied request: 403 or 404.
+	// Default: 404
+	DenyStatus int `toml:"deny_status"`
+	// Policy for the symbolic links in the file and cache handlers:
+	// SymlinkFollow ("follow"), SymlinkWithinRoot ("follow_within_root")
+	// or SymlinkDeny ("deny"). A link out of the policy is a missing file.
+	// Default: follow
+	Symlinks string `toml:"symlinks"`
+	// Directories merged under the root by the file and cache handlers,
+	// the root then the first directories win on conflicts. In a directory,
+	// a whiteout file ".wh.name" hides name in the lower directories, and
+	// a file ".wh..wh..opq" hides all the lower entries of its directory.
+	// Default: only the root
+	Under []string `toml:"under"`
+
+	// File served with the status 200 by the file and cache handlers for the
+	// missing paths without extension, for the single page applications.
+	// Example: "/index.html"
+	// Default: no fallback
+	Fallback string `toml:"fallback"`
+	// Fall back also for the missing paths with an extension.
+	// Default: false
+	FallbackAny bool `toml:"fallback_any"`
+	// Cache-Control of the fallback response, the hashed assets keep
+	// the Cache-Control of the handler.
+	// Default: the handler Cache-Control
+	FallbackCache string `toml:"fallback_c"`
+
+	// Paths of the custom error pages by status code (like "404"), they are
+	// html/template files executed with ErrorData. The file and cache
+	// handlers search first a file like "404.html" in the root.
+	// Default: the built-in pages
+	Errors map[string]string `toml:"errors"`
+	// Format of the error responses: ErrorFormatHTML ("html"),
+	// ErrorFormatJSON ("json") or ErrorFormatText ("text").
+	// Default: negotiated with the Accept header, HTML if no preference
+	ErrorFormat string `toml:"error_format"`
+	// Directory of a theme for the index and error pages, with the
+	// html/template files "index.html" and "error.html", see template.LoadTheme.
+	// Default: the built-in theme
+	Theme string `toml:"theme"`
+	// Number of entries by page of the generated directory indexes,
+	// the query "page" select the page.
+	// Default: no pagination
+	PageSize int `toml:"page_size"`
+	// Enable the directory download as an archive with the query
+	// "download=zip" or "download=tar.gz" in the file and cache handlers.
+	// Default: false
+	Archive bool `toml:"archive"`
+	// Maximum total size in bytes of the files in a directory archive,
+	// -1 for no limit. A larger directory is a 413 error.
+	// Default: 1 GiB
+	ArchiveMax int64 `toml:"archive_max"`
+	// Serve the zip files of the file handler as directories: "/docs.zip/"
+	// is the root of the zip file "/docs.zip".
+	// Default: false
+	MountZip bool `toml:"mount_zip"`
+
+	// The ref (branch, tag or commit) served by the git handler.
+	// Default: HEAD
+	Ref string `toml:"ref"`
+	// URL path prefix of the git handler to serve the other refs, for
+	// example "/_preview/" serves the branch "feature" at "/_preview/feature/".
+	// Default: no preview
+	Preview string `toml:"preview"`
+
+	// The users of the WebDAV and upload handlers, with the bcrypt hash of
+	// their password, for example from "htpasswd -nbB user password".
+	// Default: no user, the WebDAV handler rejects all the requests and
+	// the upload handler rejects all the uploads
+	Users map[string]string `toml:"users"`
+	// Accept the uploads without authentication in the upload handler.
+	// Default: false
+	Anonymous bool `toml:"anonymous"`
+	// Forbid the modifications of the WebDAV handler.
+	// Default: false
+	ReadOnly bool `toml:"read_only"`
+	// Maximal total size in bytes of the files of the WebDAV or the upload
+	// handler root.
+	// Default: 0 (no limit)
+	Quota int64 `toml:"quota"`
+
+	// Maximal size in bytes of an uploaded file, -1 for no limit.
+	// Default: 1 GiB
+	UploadMax int64 `toml:"upload_max"`
+	// Show an upload form in the index pages of the upload handler.
+	// Default: false
+	UploadForm bool `toml:"upload_form"`
+}
@@ -5,18 +5,24 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-
-	"github.com/HuguesGuilleus/servHTTP/handlers/template"
 )
 
-func ReverseProxy(logger *slog.Logger, rawURL, _ string) http.Handler {
+// Create a reverse proxy to rawURL with the default options.
+func ReverseProxy(logger *slog.Logger, rawURL, cacheControl string) http.Handler {
+	return ReverseProxyOptions(logger, rawURL, cacheControl, Options{})
+}
+
+// Create a reverse proxy to rawURL, the errors are served with the error
+// pages of the options.
+func ReverseProxyOptions(logger *slog.Logger, rawURL, _ string, opt Options) http.Handler {
 	targetURL, err := url.Parse(rawURL)
 	if err != nil {
 		logger.Error("reverseParseURL", "rawURL", rawURL, "err", err.Error())
 		return http.NotFoundHandler()
 	}
 
-	return &httputil.ReverseProxy{
+	pages := newErrorPages(logger, nil, loadTheme(logger, opt.Theme), opt)
+	return Compress(&httputil.ReverseProxy{
 		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
 		Rewrite: func(r *httputil.ProxyRequest) {
 			r.Out.Header.Del("X-Forwarded-For")
@@ -27,11 +33,11 @@ func ReverseProxy(logger *slog.Logger, rawURL, _ string) http.Handler {
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
 			const status = http.StatusBadGateway
 			LogRequest(logger.With("err", err.Error()), status, r)
-			servHTML(w, status, template.Error502(r.URL.Path))
+			pages.serve(w, r, status)
 		},
 		ModifyResponse: func(w *http.Response) error {
 			LogRequest(logger, w.StatusCode, w.Request)
 			return nil
 		},
-	}
+	})
 }
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// The fallback of a single page application, served for the missing paths.
+type spaFallback struct {
+	// Path of the served file, "" to disable the fallback.
+	file string
+	// Fall back also for the paths with an extension.
+	any bool
+	// Cache-Control of the fallback response, "" to keep the handler one.
+	cacheControl string
+}
+
+func newSPAFallback(opt Options) spaFallback {
+	file := opt.Fallback
+	if file != "" {
+		file = path.Clean("/" + file)
+	}
+	return spaFallback{
+		file:         file,
+		any:          opt.FallbackAny,
+		cacheControl: opt.FallbackCache,
+	}
+}
+
+// Test if a missing path falls back. The paths with an extension
+// are assets that are really missing, except with any.
+func (spa *spaFallback) match(p string) bool {
+	return spa.file != "" && (spa.any || path.Ext(p) == "")
+}
+
+// Set the Cache-Control of the fallback response.
+func (spa *spaFallback) setCacheControl(w http.ResponseWriter) {
+	if spa.cacheControl != "" {
+		w.Header().Set(headerCacheControl, spa.cacheControl)
+	}
+}
+
+// The key of the file in the cache handler,
+// where an "index.html" file has the key of its directory.
+func (spa *spaFallback) cacheKey() string {
+	key := strings.TrimPrefix(spa.file, "/")
+	if path.Base(key) == "index.html" {
+		key = strings.TrimSuffix(strings.TrimSuffix(key, "index.html"), "/")
+	}
+	return key
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSPAFallback(t *testing.T) {
+	spa := newSPAFallback(Options{Fallback: "app/index.html"})
+	assert.Equal(t, "/app/index.html", spa.file)
+	assert.Equal(t, "app", spa.cacheKey())
+	assert.True(t, spa.match("/settings"))
+	assert.True(t, spa.match("/user/v1.2/"))
+	assert.False(t, spa.match("/app.js"))
+
+	spa = newSPAFallback(Options{Fallback: "/index.html", FallbackAny: true})
+	assert.Equal(t, "", spa.cacheKey())
+	assert.True(t, spa.match("/app.js"))
+
+	spa = newSPAFallback(Options{})
+	assert.False(t, spa.match("/settings"))
+}
+
+var testSPAFS = fstest.MapFS{
+	"index.html":         &fstest.MapFile{Data: []byte("<!DOCTYPE html>app")},
+	"assets/app.1234.js": &fstest.MapFile{Data: []byte("app()")},
+}
+
+func TestSPAHandlers(t *testing.T) {
+	newHandlers := func(opt Options) map[string]http.Handler {
+		logger, _ := testLoggerOne()
+		c := common{
+			Logger:       logger,
+			CacheControl: "max-age=31536000, immutable",
+			spa:          newSPAFallback(opt),
+		}
+		cache := &cacheHandler{common: c}
+		cache.Update(testSPAFS, time.Now())
+		return map[string]http.Handler{
+			"file":  &fileHandler{common: c, fsys: http.FS(testSPAFS)},
+			"cache": cache,
+		}
+	}
+	tf := func(t *testing.T, hand http.Handler, url string, code int, cacheControl, body string) {
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+url, nil))
+		assert.Equal(t, code, w.Code, url)
+		assert.Equal(t, cacheControl, w.Header().Get("Cache-Control"), url)
+		if body != "" {
+			assert.Equal(t, body, w.Body.String(), url)
+		}
+	}
+
+	for name, hand := range newHandlers(Options{Fallback: "/index.html", FallbackCache: "no-cache"}) {
+		t.Run(name, func(t *testing.T) {
+			tf(t, hand, "/assets/app.1234.js", 200, "max-age=31536000, immutable", "app()")
+			tf(t, hand, "/app/settings", 200, "no-cache", "<!DOCTYPE html>app")
+			tf(t, hand, "/app/settings/", 200, "no-cache", "<!DOCTYPE html>app")
+			tf(t, hand, "/assets/app.5678.js", 404, "max-age=31536000, immutable", "")
+		})
+	}
+	for name, hand := range newHandlers(Options{Fallback: "/index.html", FallbackAny: true}) {
+		t.Run(name+"_any", func(t *testing.T) {
+			tf(t, hand, "/assets/app.5678.js", 200, "max-age=31536000, immutable", "<!DOCTYPE html>app")
+		})
+	}
+	for name, hand := range newHandlers(Options{Fallback: "/missing.html"}) {
+		t.Run(name+"_missing", func(t *testing.T) {
+			tf(t, hand, "/app/settings", 404, "max-age=31536000, immutable", "")
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Policies for the symbolic links in the file and cache handlers.
+const (
+	// Follow all links, even outside the root.
+	SymlinkFollow = "follow"
+	// Follow the links only if the target is in the root.
+	SymlinkWithinRoot = "follow_within_root"
+	// Never follow a link, on every path component.
+	SymlinkDeny = "deny"
+)
+
+// A link out of the policy is served like a missing file.
+var errSymlink = fmt.Errorf("%w (symbolic link denied or outside the root)", fs.ErrNotExist)
+
+// Get the filesystem of root with the symbolic link policy.
+func rootFS(root, policy string) fs.FS {
+	switch policy {
+	case SymlinkWithinRoot:
+		return confinedFS{root: root}
+	case SymlinkDeny:
+		return confinedFS{root: root, noSymlink: true}
+	}
+	return os.DirFS(root)
+}
+
+func symlinkError(name string) error {
+	return &fs.PathError{Op: "open", Path: name, Err: errSymlink}
+}
+
+// Get the filesystem path of the URL path p in root, for a modification.
+// The existing components can not be symbolic links.
+func noSymlinkPath(root, p string) (string, error) {
+	full := filepath.Join(root, filepath.FromSlash(p))
+	component := root
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		component = filepath.Join(component, name)
+		info, err := os.Lstat(component)
+		if errors.Is(err, fs.ErrNotExist) {
+			return full, nil
+		} else if err != nil {
+			return "", err
+		} else if info.Mode()&fs.ModeSymlink != 0 {
+			return "", symlinkError(p)
+		}
+	}
+	return full, nil
+}
@@ -0,0 +1,57 @@
+//go:build !go1.24
+
+package handlers
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A filesystem where the paths are resolved in root.
+// With noSymlink, no path component can be a symbolic link.
+//
+// Without os.Root, the checks are done before the open, so a component
+// replaced between the check and the open is not detected.
+type confinedFS struct {
+	root      string
+	noSymlink bool
+}
+
+func (fsys confinedFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	full := filepath.Join(fsys.root, filepath.FromSlash(name))
+
+	if fsys.noSymlink {
+		p := fsys.root
+		for _, component := range strings.Split(name, "/") {
+			if component == "." {
+				continue
+			}
+			p = filepath.Join(p, component)
+			info, err := os.Lstat(p)
+			if err != nil {
+				return nil, err
+			} else if info.Mode()&fs.ModeSymlink != 0 {
+				return nil, symlinkError(name)
+			}
+		}
+		return os.Open(full)
+	}
+
+	root, err := filepath.EvalSymlinks(fsys.root)
+	if err != nil {
+		return nil, err
+	}
+	target, err := filepath.EvalSymlinks(full)
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(root, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, symlinkError(name)
+	}
+	return os.Open(target)
+}
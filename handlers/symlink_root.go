@@ -0,0 +1,90 @@
+//go:build go1.24
+
+package handlers
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// A filesystem where the paths are resolved in root with os.Root.
+// With noSymlink, no path component can be a symbolic link.
+type confinedFS struct {
+	root      string
+	noSymlink bool
+}
+
+func (fsys confinedFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	root, err := os.OpenRoot(fsys.root)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { root.Close() }()
+
+	if !fsys.noSymlink {
+		file, err := root.Open(name)
+		if errno := syscall.Errno(0); err != nil && !errors.As(err, &errno) {
+			// The target is outside the root.
+			return nil, symlinkError(name)
+		}
+		return file, err
+	} else if name == "." {
+		return root.Open(name)
+	}
+
+	// Each directory is opened relative to the previous one, and compared
+	// to its lstat, so a component replaced after its check is never used.
+	components := strings.Split(name, "/")
+	for _, component := range components[:len(components)-1] {
+		info, err := lstatNoSymlink(root, name, component)
+		if err != nil {
+			return nil, err
+		}
+		sub, err := root.OpenRoot(component)
+		if err != nil {
+			return nil, err
+		}
+		root.Close()
+		root = sub
+		if stat, err := root.Stat("."); err != nil {
+			return nil, err
+		} else if !os.SameFile(info, stat) {
+			return nil, symlinkError(name)
+		}
+	}
+
+	last := components[len(components)-1]
+	info, err := lstatNoSymlink(root, name, last)
+	if err != nil {
+		return nil, err
+	}
+	file, err := root.Open(last)
+	if err != nil {
+		return nil, err
+	}
+	if stat, err := file.Stat(); err != nil {
+		file.Close()
+		return nil, err
+	} else if !os.SameFile(info, stat) {
+		file.Close()
+		return nil, symlinkError(name)
+	}
+	return file, nil
+}
+
+// Lstat the component and reject a symbolic link.
+func lstatNoSymlink(root *os.Root, name, component string) (fs.FileInfo, error) {
+	info, err := root.Lstat(component)
+	if err != nil {
+		return nil, err
+	} else if info.Mode()&fs.ModeSymlink != 0 {
+		return nil, symlinkError(name)
+	}
+	return info, nil
+}
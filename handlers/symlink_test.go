@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Create a root with links, and a secret file outside.
+func testSymlinkRoot(t *testing.T) string {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "sub"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "file.txt"), []byte("file"), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "sub", "sub.txt"), []byte("sub"), 0o644))
+	for link, target := range map[string]string{
+		"escape":   filepath.Join("..", "secret"),
+		"absolute": filepath.Join(dir, "secret"),
+		"outdir":   dir,
+		"inside":   "file.txt",
+		"subdir":   "sub",
+		"sub/up":   filepath.Join("..", "file.txt"),
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skip("symlink:", err)
+		}
+	}
+	return root
+}
+
+func TestRootFS(t *testing.T) {
+	root := testSymlinkRoot(t)
+	tf := func(t *testing.T, fsys fs.FS, name, expected string) {
+		data, err := fs.ReadFile(fsys, name)
+		if expected == "" {
+			assert.ErrorIs(t, err, fs.ErrNotExist, name)
+		} else {
+			assert.NoError(t, err, name)
+			assert.Equal(t, expected, string(data), name)
+		}
+	}
+
+	t.Run(SymlinkFollow, func(t *testing.T) {
+		fsys := rootFS(root, SymlinkFollow)
+		tf(t, fsys, "file.txt", "file")
+		tf(t, fsys, "escape", "secret")
+		tf(t, fsys, "outdir/secret", "secret")
+		tf(t, fsys, "inside", "file")
+	})
+	t.Run(SymlinkWithinRoot, func(t *testing.T) {
+		fsys := rootFS(root, SymlinkWithinRoot)
+		tf(t, fsys, "file.txt", "file")
+		tf(t, fsys, "escape", "")
+		tf(t, fsys, "absolute", "")
+		tf(t, fsys, "outdir/secret", "")
+		tf(t, fsys, "inside", "file")
+		tf(t, fsys, "subdir/sub.txt", "sub")
+		tf(t, fsys, "sub/up", "file")
+		tf(t, fsys, "nothing", "")
+	})
+	t.Run(SymlinkDeny, func(t *testing.T) {
+		fsys := rootFS(root, SymlinkDeny)
+		tf(t, fsys, "file.txt", "file")
+		tf(t, fsys, "sub/sub.txt", "sub")
+		tf(t, fsys, "escape", "")
+		tf(t, fsys, "outdir/secret", "")
+		tf(t, fsys, "inside", "")
+		tf(t, fsys, "subdir/sub.txt", "")
+		tf(t, fsys, "sub/up", "")
+		entries, err := fs.ReadDir(fsys, ".")
+		assert.NoError(t, err)
+		assert.Len(t, entries, 7)
+	})
+}
+
+func TestSymlinkHandlers(t *testing.T) {
+	root := testSymlinkRoot(t)
+	logger, _ := testLoggerOne()
+	file := FileOptions(logger, root, "", Options{Symlinks: SymlinkWithinRoot})
+	cache := &cacheHandler{common: common{Logger: logger}}
+	cache.Update(rootFS(root, SymlinkWithinRoot), time.Now())
+
+	for name, hand := range map[string]http.Handler{"file": file, "cache": cache} {
+		t.Run(name, func(t *testing.T) {
+			tf := func(url string, code int, body string) {
+				w := httptest.NewRecorder()
+				hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+url, nil))
+				assert.Equal(t, code, w.Code, url)
+				if body != "" {
+					assert.Equal(t, body, w.Body.String(), url)
+				}
+			}
+			tf("/file.txt", 200, "file")
+			tf("/inside", 200, "file")
+			tf("/subdir/sub.txt", 200, "sub")
+			tf("/escape", 404, "")
+			tf("/absolute", 404, "")
+			tf("/outdir/secret", 404, "")
+		})
+	}
+}
+
+func TestSymlinkCacheLoop(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "file.txt"), []byte("file"), 0o644))
+	assert.NoError(t, os.Symlink(".", filepath.Join(root, "self")))
+	assert.NoError(t, os.Symlink("file.txt", filepath.Join(root, "link.txt")))
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "dir", "sub"), 0o755))
+	assert.NoError(t, os.Symlink("..", filepath.Join(root, "dir", "sub", "up")))
+	assert.NoError(t, os.Symlink("dir", filepath.Join(root, "alias")))
+
+	logger, _ := testLoggerOne()
+	hand := &cacheHandler{common: common{Logger: logger}}
+	hand.Update(rootFS(root, SymlinkFollow), time.Now())
+	files := hand.snapshot.Load().files
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	assert.Equal(t, []string{"", "alias", "alias/sub", "dir", "dir/sub", "file.txt", "link.txt"}, keys)
+}
+
+// Swap a directory with a link to the outside while reading in it.
+func TestSymlinkSwap(t *testing.T) {
+	root := testSymlinkRoot(t)
+	swap := filepath.Join(root, "swap")
+	assert.NoError(t, os.Mkdir(swap, 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(swap, "secret"), []byte("public"), 0o644))
+	outside := filepath.Dir(root)
+
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tmpDir, tmpLink := swap+".dir", swap+".link"
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			os.Rename(swap, tmpDir)
+			os.Symlink(outside, tmpLink)
+			os.Rename(tmpLink, swap)
+			time.Sleep(time.Microsecond)
+			os.Remove(swap)
+			os.Rename(tmpDir, swap)
+			time.Sleep(time.Microsecond)
+		}
+	}()
+
+	for _, policy := range []string{SymlinkWithinRoot, SymlinkDeny} {
+		fsys := rootFS(root, policy)
+		for i := 0; i < 2000; i++ {
+			f, err := fsys.Open("swap/secret")
+			if err != nil {
+				continue
+			}
+			data, _ := io.ReadAll(f)
+			f.Close()
+			if string(data) != "public" {
+				t.Errorf("%s: read outside the root: %q", policy, data)
+				break
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
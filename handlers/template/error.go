@@ -3,12 +3,16 @@ package template
 import (
 	"bytes"
 	_ "embed"
+	"encoding/json"
+	"net/http"
 	"regexp"
+	"strconv"
 )
 
 var (
 	//go:embed error.html
 	errorRaw []byte
+	error403 []byte
 	error404 []byte
 	error405 []byte
 	error500 []byte
@@ -17,17 +21,64 @@ var (
 
 func init() {
 	errorRaw = minify(errorRaw)
+	error403 = bytes.ReplaceAll(errorRaw, []byte("TITLE"), []byte("403 Forbidden"))
 	error404 = bytes.ReplaceAll(errorRaw, []byte("TITLE"), []byte("404 Not Found"))
 	error405 = bytes.ReplaceAll(errorRaw, []byte("TITLE"), []byte("405 Method Not Allowed"))
 	error500 = bytes.ReplaceAll(errorRaw, []byte("TITLE"), []byte("500 Internal Error"))
 	error502 = bytes.ReplaceAll(errorRaw, []byte("TITLE"), []byte("502 Bad Gateway"))
 }
 
+func Error403(path string) []byte { return errorMake(path, error403) }
 func Error404(path string) []byte { return errorMake(path, error404) }
 func Error405(path string) []byte { return errorMake(path, error405) }
 func Error500(path string) []byte { return errorMake(path, error500) }
 func Error502(path string) []byte { return errorMake(path, error502) }
 
+// Get the title of the status code, "Error" for an unknown status.
+func errorTitle(status int) string {
+	if status == http.StatusInternalServerError {
+		return "Internal Error"
+	} else if title := http.StatusText(status); title != "" {
+		return title
+	}
+	return "Error"
+}
+
+// Get the error as problem details JSON (RFC 9457),
+// for the media type "application/problem+json".
+func ErrorJSON(status int, path string) []byte {
+	title := errorTitle(status)
+	data, _ := json.Marshal(struct {
+		Type     string `json:"type"`
+		Title    string `json:"title"`
+		Status   int    `json:"status"`
+		Instance string `json:"instance"`
+	}{"about:blank", title, status, path})
+	return data
+}
+
+// Get the error as plain text.
+func ErrorText(status int, path string) []byte {
+	return []byte(strconv.Itoa(status) + " " + errorTitle(status) + "\n" + path + "\n")
+}
+
+// Get the built-in error page of the status code.
+func Error(status int, path string) []byte {
+	switch status {
+	case 403:
+		return Error403(path)
+	case 404:
+		return Error404(path)
+	case 405:
+		return Error405(path)
+	case 500:
+		return Error500(path)
+	case 502:
+		return Error502(path)
+	}
+	return errorMake(path, bytes.ReplaceAll(errorRaw, []byte("TITLE"), []byte(strconv.Itoa(status)+" "+errorTitle(status))))
+}
+
 func errorMake(path string, template []byte) []byte {
 	buff := bytes.Buffer{}
 	buff.Write(template)
@@ -1,11 +1,42 @@
 package template
 
 import (
+	"strings"
 	"testing"
 )
 
 // Tests to prevent regression.
 
+func TestError(t *testing.T) {
+	assertString(t, string(Error403("/f")), string(Error(403, "/f")))
+	assertString(t, string(Error404("/f")), string(Error(404, "/f")))
+	assertString(t, string(Error405("/f")), string(Error(405, "/f")))
+	assertString(t, string(Error500("/f")), string(Error(500, "/f")))
+	assertString(t, string(Error502("/f")), string(Error(502, "/f")))
+	assertString(t, strings.ReplaceAll(string(Error403("/f")), "403 Forbidden", "418 I'm a teapot"), string(Error(418, "/f")))
+	assertString(t, strings.ReplaceAll(string(Error403("/f")), "403 Forbidden", "599 Error"), string(Error(599, "/f")))
+	assertString(t, strings.ReplaceAll(string(Error403("/f")), "403 Forbidden", "507 Insufficient Storage"), string(Error(507, "/f")))
+}
+
+func TestErrorJSON(t *testing.T) {
+	assertString(t, `{"type":"about:blank","title":"Not Found","status":404,"instance":"/file/"}`, string(ErrorJSON(404, "/file/")))
+	assertString(t, `{"type":"about:blank","title":"Bad Gateway","status":502,"instance":"/api/\u003cx\u003e"}`, string(ErrorJSON(502, "/api/<x>")))
+	assertString(t, `{"type":"about:blank","title":"I'm a teapot","status":418,"instance":"/"}`, string(ErrorJSON(418, "/")))
+	assertString(t, `{"type":"about:blank","title":"Internal Error","status":500,"instance":"/"}`, string(ErrorJSON(500, "/")))
+}
+
+func TestErrorText(t *testing.T) {
+	assertString(t, "405 Method Not Allowed\n/file/\n", string(ErrorText(405, "/file/")))
+	assertString(t, "418 I'm a teapot\n/\n", string(ErrorText(418, "/")))
+	assertString(t, "500 Internal Error\n/\n", string(ErrorText(500, "/")))
+	assertString(t, "423 Locked\n/file\n", string(ErrorText(423, "/file")))
+}
+
+func TestError403(t *testing.T) {
+	expected := `<!DOCTYPE html><html lang=en><head><meta charset=utf-8><meta name=viewport content="width=device-width,initial-scale=1.0"><title>403 Forbidden</title><style>body{max-width:60ex;margin:20vh auto 0;font-family:monospace;font-size:xx-large;background:#eae5dc;border:dodgerblue solid 0.3ex;border-style:solid none;padding:2ex 0}h1,#p{display:table;padding:0.2em 0.5em;background:#FFF}a{color:#06C;text-decoration:none}a:hover{color:#00B;text-decoration:underline}</style></head><body><h1>403 Forbidden</h1><div id=p><a href="/">/</a><a href="/file/">file/</a></div>`
+	assertString(t, expected, string(Error403("/file/")))
+}
+
 func TestError404(t *testing.T) {
 	expected := `<!DOCTYPE html><html lang=en><head><meta charset=utf-8><meta name=viewport content="width=device-width,initial-scale=1.0"><title>404 Not Found</title><style>body{max-width:60ex;margin:20vh auto 0;font-family:monospace;font-size:xx-large;background:#eae5dc;border:dodgerblue solid 0.3ex;border-style:solid none;padding:2ex 0}h1,#p{display:table;padding:0.2em 0.5em;background:#FFF}a{color:#06C;text-decoration:none}a:hover{color:#00B;text-decoration:underline}</style></head><body><h1>404 Not Found</h1><div id=p><a href="/">/</a><a href="/file/">file/</a></div>`
 	assertString(t, expected, string(Error404("/file/")))
@@ -5,9 +5,7 @@ import (
 	_ "embed"
 	"html"
 	"io/fs"
-	"slices"
-	"sort"
-	"strings"
+	"strconv"
 	"time"
 )
 
@@ -26,31 +24,24 @@ func init() {
 	indexRaw2 = minify(indexRaw2)
 }
 
+// Render the index with the default theme.
 func Index(path string, entrys []fs.FileInfo) []byte {
-	// Prepare files for index
-	entrys = slices.DeleteFunc(entrys, func(entry fs.FileInfo) bool {
-		return strings.HasPrefix(entry.Name(), ".")
-	})
-	sort.Slice(entrys, func(i, j int) bool {
-		if entrys[i].IsDir() != entrys[j].IsDir() {
-			return entrys[i].IsDir()
-		}
-		return entrys[i].Name() < entrys[j].Name()
-	})
+	return indexMake(NewIndexData(path, entrys))
+}
 
+func indexMake(data *IndexData) []byte {
 	// Headers
 	buff := bytes.Buffer{}
 	buff.Write(indexRaw0)
-	htmlPath(&buff, path)
+	htmlPath(&buff, data.Path)
 	buff.Write(indexRaw1)
 
 	// File list
-	readme := ""
-	for _, entry := range entrys {
-		if entry.IsDir() {
+	for _, entry := range data.Entries {
+		if entry.IsDir {
 			buff.WriteString(`<br><div>-</div>`)
 		} else {
-			t := entry.ModTime().UTC().Format(time.RFC3339)
+			t := entry.ModTime.Format(time.RFC3339)
 			buff.WriteString(`<time datetime="`)
 			buff.WriteString(t)
 			buff.WriteString(`">`)
@@ -58,32 +49,56 @@ func Index(path string, entrys []fs.FileInfo) []byte {
 			buff.WriteString(`</time>`)
 
 			buff.WriteString(`<div>`)
-			htmlSize(&buff, entry.Size())
+			htmlSize(&buff, entry.Size)
 			buff.WriteString(`</div>`)
 		}
 
-		name := entry.Name()
-		if entry.IsDir() {
-			name += "/"
-		}
-		nameEscaped := html.EscapeString(name)
-		switch strings.ToLower(name) {
-		case "readme", "readme.md", "readme.txt":
-			readme = nameEscaped
-		}
+		nameEscaped := html.EscapeString(entry.Name)
 		buff.WriteString(`<a href="`)
 		buff.WriteString(nameEscaped)
 		buff.WriteString(`">`)
 		buff.WriteString(nameEscaped)
 		buff.WriteString(`</a>`)
-		buff.WriteString(``)
 	}
 	buff.WriteString(`</div>`)
 
+	// Pagination
+	if data.Pages > 1 {
+		buff.WriteString(`<p id=g>`)
+		if data.Page > 1 {
+			buff.WriteString(`<a href="`)
+			buff.WriteString(html.EscapeString(data.PageURL(data.Page - 1)))
+			buff.WriteString(`">&lt;</a> `)
+		}
+		buff.WriteString(strconv.Itoa(data.Page))
+		buff.WriteByte('/')
+		buff.WriteString(strconv.Itoa(data.Pages))
+		if data.Page < data.Pages {
+			buff.WriteString(` <a href="`)
+			buff.WriteString(html.EscapeString(data.PageURL(data.Page + 1)))
+			buff.WriteString(`">&gt;</a>`)
+		}
+		buff.WriteString(`</p>`)
+	}
+
+	// Download
+	if data.Archive {
+		buff.WriteString(`<p id=d><a href="?download=zip">zip</a> <a href="?download=tar.gz">tar.gz</a></p>`)
+	}
+
+	// Upload
+	if data.Upload {
+		buff.WriteString(`<form id=u method=post enctype=multipart/form-data><input type=file name=file multiple required> <button>Upload</button></form>`)
+	}
+
 	// Readme
-	if readme != "" {
+	if data.ReadmeHTML != "" {
+		buff.WriteString(`<article id=m>`)
+		buff.WriteString(string(data.ReadmeHTML))
+		buff.WriteString(`</article>`)
+	} else if data.Readme != "" {
 		buff.WriteString(`<pre id=r>`)
-		buff.WriteString(readme)
+		buff.WriteString(html.EscapeString(data.Readme))
 		buff.WriteString(`</pre>`)
 	}
 
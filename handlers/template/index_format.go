@@ -0,0 +1,76 @@
+package template
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// An entry of the machine readable indexes.
+type indexEntryFormat struct {
+	XMLName xml.Name `json:"-"`
+	Name    string   `json:"name" xml:"name,attr"`
+	Type    string   `json:"type" xml:"-"`
+	Size    int64    `json:"size" xml:"size,attr"`
+	ModTime string   `json:"mtime" xml:"mtime,attr"`
+	URL     string   `json:"url" xml:"url,attr"`
+}
+
+func (data *IndexData) formatEntries() []indexEntryFormat {
+	entries := make([]indexEntryFormat, len(data.Entries))
+	for i, entry := range data.Entries {
+		e := indexEntryFormat{
+			XMLName: xml.Name{Local: "file"},
+			Name:    strings.TrimSuffix(entry.Name, "/"),
+			Type:    "file",
+			Size:    entry.Size,
+			ModTime: entry.ModTime.Format(time.RFC3339),
+			URL:     (&url.URL{Path: data.Path + entry.Name}).EscapedPath(),
+		}
+		if entry.IsDir {
+			e.XMLName.Local = "dir"
+			e.Type = "dir"
+		}
+		entries[i] = e
+	}
+	return entries
+}
+
+// Get the index as JSON, for the media type "application/json".
+func IndexJSON(data *IndexData) []byte {
+	j, _ := json.Marshal(struct {
+		Path    string             `json:"path"`
+		Dirs    int                `json:"dirs"`
+		Files   int                `json:"files"`
+		Size    int64              `json:"size"`
+		Page    int                `json:"page"`
+		Pages   int                `json:"pages"`
+		Entries []indexEntryFormat `json:"entries"`
+	}{data.Path, data.Dirs, data.Files, data.Size, data.Page, data.Pages, data.formatEntries()})
+	return j
+}
+
+// Get the index as XML, for the media type "application/xml".
+func IndexXML(data *IndexData) []byte {
+	x, _ := xml.Marshal(struct {
+		XMLName xml.Name           `xml:"index"`
+		Path    string             `xml:"path,attr"`
+		Page    int                `xml:"page,attr"`
+		Pages   int                `xml:"pages,attr"`
+		Entries []indexEntryFormat `xml:"entries"`
+	}{Path: data.Path, Page: data.Page, Pages: data.Pages, Entries: data.formatEntries()})
+	return append([]byte(xml.Header), x...)
+}
+
+// Get the index as plain text, one name by line,
+// the directory names end with a slash.
+func IndexText(data *IndexData) []byte {
+	buff := strings.Builder{}
+	for _, entry := range data.Entries {
+		buff.WriteString(entry.Name)
+		buff.WriteByte('\n')
+	}
+	return []byte(buff.String())
+}
@@ -0,0 +1,36 @@
+package template
+
+import (
+	"io/fs"
+	"testing"
+	"time"
+)
+
+func testIndexFormatData() *IndexData {
+	modTime := time.Date(2023, 12, 2, 13, 23, 25, 0, time.UTC)
+	return NewIndexData("/a b/", []fs.FileInfo{
+		&Info{modTime: modTime, name: "f&#.txt", size: 12},
+		&Info{modTime: modTime, name: "d", size: 0},
+		&Info{modTime: modTime, name: ".hide", size: 1},
+	})
+}
+
+func TestIndexJSON(t *testing.T) {
+	expected := `{"path":"/a b/","dirs":1,"files":1,"size":12,"page":1,"pages":1,"entries":[` +
+		`{"name":"d","type":"dir","size":0,"mtime":"2023-12-02T13:23:25Z","url":"/a%20b/d/"},` +
+		`{"name":"f\u0026#.txt","type":"file","size":12,"mtime":"2023-12-02T13:23:25Z","url":"/a%20b/f\u0026%23.txt"}]}`
+	assertString(t, expected, string(IndexJSON(testIndexFormatData())))
+}
+
+func TestIndexXML(t *testing.T) {
+	expected := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<index path="/a b/" page="1" pages="1">` +
+		`<dir name="d" size="0" mtime="2023-12-02T13:23:25Z" url="/a%20b/d/"></dir>` +
+		`<file name="f&amp;#.txt" size="12" mtime="2023-12-02T13:23:25Z" url="/a%20b/f&amp;%23.txt"></file>` +
+		`</index>`
+	assertString(t, expected, string(IndexXML(testIndexFormatData())))
+}
+
+func TestIndexText(t *testing.T) {
+	assertString(t, "d/\nf&#.txt\n", string(IndexText(testIndexFormatData())))
+}
@@ -0,0 +1,190 @@
+package template
+
+import (
+	"container/heap"
+	"io/fs"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Sort keys of the index entries, the directories are always first.
+const (
+	IndexSortName = ""
+	IndexSortSize = "size"
+	IndexSortTime = "mtime"
+)
+
+// The order and the pagination of the index entries.
+type IndexQuery struct {
+	// The sort key: IndexSortName, IndexSortSize or IndexSortTime.
+	Sort string
+	// Descending order.
+	Desc bool
+	// The page number from 1, 0 is the first page. It is limited so that
+	// Page*PageSize does not overflow.
+	Page int
+	// The number of entries by page, 0 for all the entries in one page.
+	PageSize int
+}
+
+// Build an index data from a stream of entries. Only the entries before
+// the end of the requested page are kept, so the memory is bounded.
+type IndexBuilder struct {
+	data  *IndexData
+	query IndexQuery
+	heap  indexHeap
+}
+
+func NewIndexBuilder(path string, query IndexQuery) *IndexBuilder {
+	if query.Page < 1 {
+		query.Page = 1
+	} else if query.PageSize > 0 {
+		query.Page = min(query.Page, math.MaxInt/query.PageSize)
+	}
+	return &IndexBuilder{
+		data: &IndexData{
+			Path:       path,
+			Breadcrumb: breadcrumb(path),
+			Sort:       query.Sort,
+			Desc:       query.Desc,
+			Page:       query.Page,
+		},
+		query: query,
+		heap:  indexHeap{less: query.less},
+	}
+}
+
+// Add an entry, skip the names beginning with a dot.
+func (builder *IndexBuilder) Add(info fs.FileInfo) {
+	if strings.HasPrefix(info.Name(), ".") {
+		return
+	}
+	entry := IndexEntry{
+		Name:    info.Name(),
+		IsDir:   info.IsDir(),
+		ModTime: info.ModTime().UTC(),
+	}
+	if entry.IsDir {
+		entry.Name += "/"
+	} else {
+		entry.Size = info.Size()
+	}
+	builder.add(entry)
+}
+
+func (builder *IndexBuilder) add(entry IndexEntry) {
+	data := builder.data
+	if entry.IsDir {
+		data.Dirs++
+	} else {
+		data.Files++
+		data.Size += entry.Size
+	}
+	if rank := readmeRank(entry.Name); rank >= 0 {
+		current := readmeRank(data.Readme)
+		if current < 0 || rank < current || rank == current && entry.Name > data.Readme {
+			data.Readme = entry.Name
+		}
+	}
+
+	heap.Push(&builder.heap, entry)
+	if limit := builder.query.Page * builder.query.PageSize; limit > 0 && builder.heap.Len() > limit {
+		heap.Pop(&builder.heap)
+	}
+}
+
+// The readme names by preference, a README.md is rendered before a README.txt.
+var readmeNames = []string{"readme.md", "readme.txt", "readme"}
+
+// Get the preference of a readme name, -1 if it's not a readme.
+func readmeRank(name string) int {
+	return slices.Index(readmeNames, strings.ToLower(name))
+}
+
+// Get the index data with the entries of the page.
+func (builder *IndexBuilder) Data() *IndexData {
+	data := builder.data
+	entries := builder.heap.entries
+	slices.SortFunc(entries, func(a, b IndexEntry) int {
+		if builder.query.less(a, b) {
+			return -1
+		} else if builder.query.less(b, a) {
+			return 1
+		}
+		return 0
+	})
+
+	data.Pages = 1
+	if size := builder.query.PageSize; size > 0 {
+		data.Pages = max(1, (data.Dirs+data.Files+size-1)/size)
+		entries = entries[min(len(entries), (data.Page-1)*size):]
+	}
+	data.Entries = entries
+	return data
+}
+
+// Get the index data of a query, from the data with all the entries.
+func (data *IndexData) Query(query IndexQuery) *IndexData {
+	builder := NewIndexBuilder(data.Path, query)
+	for _, entry := range data.Entries {
+		builder.add(entry)
+	}
+	page := builder.Data()
+	page.ReadmeHTML = data.ReadmeHTML
+	page.Archive = data.Archive
+	page.Upload = data.Upload
+	return page
+}
+
+// Get the URL of a page, with the same sort.
+func (data *IndexData) PageURL(page int) string {
+	values := url.Values{}
+	if data.Sort != IndexSortName {
+		values.Set("sort", data.Sort)
+	}
+	if data.Desc {
+		values.Set("order", "desc")
+	}
+	values.Set("page", strconv.Itoa(page))
+	return "?" + values.Encode()
+}
+
+// Test if a is before b, the directories are first.
+func (query IndexQuery) less(a, b IndexEntry) bool {
+	if a.IsDir != b.IsDir {
+		return a.IsDir
+	}
+	if query.Desc {
+		a, b = b, a
+	}
+	switch query.Sort {
+	case IndexSortSize:
+		if a.Size != b.Size {
+			return a.Size < b.Size
+		}
+	case IndexSortTime:
+		if !a.ModTime.Equal(b.ModTime) {
+			return a.ModTime.Before(b.ModTime)
+		}
+	}
+	return strings.TrimSuffix(a.Name, "/") < strings.TrimSuffix(b.Name, "/")
+}
+
+// A max heap of entries, to keep the smallest entries.
+type indexHeap struct {
+	entries []IndexEntry
+	less    func(a, b IndexEntry) bool
+}
+
+func (h *indexHeap) Len() int           { return len(h.entries) }
+func (h *indexHeap) Less(i, j int) bool { return h.less(h.entries[j], h.entries[i]) }
+func (h *indexHeap) Swap(i, j int)      { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+func (h *indexHeap) Push(x any)         { h.entries = append(h.entries, x.(IndexEntry)) }
+func (h *indexHeap) Pop() any {
+	last := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	return last
+}
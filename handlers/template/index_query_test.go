@@ -0,0 +1,95 @@
+package template
+
+import (
+	"io/fs"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testIndexQueryEntries() []fs.FileInfo {
+	modTime := time.Date(2023, 12, 2, 13, 23, 25, 0, time.UTC)
+	return []fs.FileInfo{
+		&Info{modTime: modTime, name: "c", size: 1},
+		&Info{modTime: modTime.Add(time.Hour), name: "a", size: 3},
+		&Info{modTime: modTime.Add(-time.Hour), name: "b", size: 2},
+		&Info{modTime: modTime, name: "dir", size: 0},
+		&Info{modTime: modTime, name: "dir-b", size: 0},
+		&Info{modTime: modTime, name: ".hide", size: 4},
+	}
+}
+
+func testIndexQueryNames(data *IndexData) string {
+	names := make([]string, len(data.Entries))
+	for i, entry := range data.Entries {
+		names[i] = entry.Name
+	}
+	return strings.Join(names, " ")
+}
+
+func TestIndexBuilder(t *testing.T) {
+	tf := func(query IndexQuery, expected string, page, pages int) {
+		builder := NewIndexBuilder("/", query)
+		for _, entry := range testIndexQueryEntries() {
+			builder.Add(entry)
+			if limit := max(1, query.Page) * query.PageSize; limit > 0 && builder.heap.Len() > limit {
+				t.Errorf("%+v: the heap is too big: %d", query, builder.heap.Len())
+			}
+		}
+		data := builder.Data()
+		assertString(t, expected, testIndexQueryNames(data))
+		if data.Page != page || data.Pages != pages || data.Dirs != 2 || data.Files != 3 || data.Size != 6 {
+			t.Errorf("%+v: wrong data: %+v", query, data)
+		}
+	}
+
+	tf(IndexQuery{}, "dir/ dir-b/ a b c", 1, 1)
+	tf(IndexQuery{Desc: true}, "dir-b/ dir/ c b a", 1, 1)
+	tf(IndexQuery{Sort: IndexSortSize}, "dir/ dir-b/ c b a", 1, 1)
+	tf(IndexQuery{Sort: IndexSortSize, Desc: true}, "dir-b/ dir/ a b c", 1, 1)
+	tf(IndexQuery{Sort: IndexSortTime}, "dir/ dir-b/ b c a", 1, 1)
+	tf(IndexQuery{PageSize: 2}, "dir/ dir-b/", 1, 3)
+	tf(IndexQuery{PageSize: 2, Page: 2}, "a b", 2, 3)
+	tf(IndexQuery{PageSize: 2, Page: 3}, "c", 3, 3)
+	tf(IndexQuery{PageSize: 2, Page: 4}, "", 4, 3)
+	tf(IndexQuery{PageSize: 2, Page: math.MaxInt}, "", math.MaxInt/2, 3)
+	tf(IndexQuery{PageSize: 2, Page: 2, Sort: IndexSortTime, Desc: true}, "a c", 2, 3)
+}
+
+func TestIndexBuilderReadme(t *testing.T) {
+	tf := func(expected string, names ...string) {
+		builder := NewIndexBuilder("/", IndexQuery{})
+		for _, name := range names {
+			builder.Add(&Info{name: name, size: 1})
+		}
+		assertString(t, expected, builder.Data().Readme)
+	}
+
+	tf("", "a.md", "readme.rst")
+	tf("README", "README", "a.txt")
+	tf("README.txt", "README", "README.txt")
+	tf("README.md", "README.txt", "README.md", "README")
+	tf("README.md", "README.md", "README.txt")
+	tf("readme.md", "README.md", "readme.md")
+}
+
+func TestIndexDataQuery(t *testing.T) {
+	data := NewIndexData("/", testIndexQueryEntries())
+	page := data.Query(IndexQuery{Sort: IndexSortSize, Desc: true, Page: 2, PageSize: 3})
+	assertString(t, "b c", testIndexQueryNames(page))
+	assertString(t, "dir/ dir-b/ a b c", testIndexQueryNames(data))
+
+	assertString(t, "?order=desc&page=3&sort=size", page.PageURL(3))
+	assertString(t, "?page=1", data.PageURL(1))
+}
+
+func TestIndexPagination(t *testing.T) {
+	data := NewIndexData("/file/", testIndexQueryEntries()).Query(IndexQuery{Page: 2, PageSize: 2, Desc: true})
+	expected := indexBegin +
+		`<time datetime="2023-12-02T13:23:25Z">2023-12-02T13:23:25Z</time><div>1 B</div><a href="c">c</a>` +
+		`<time datetime="2023-12-02T12:23:25Z">2023-12-02T12:23:25Z</time><div>2 B</div><a href="b">b</a>` +
+		`</div><p id=g><a href="?order=desc&amp;page=1">&lt;</a> 2/3 <a href="?order=desc&amp;page=3">&gt;</a></p>` +
+		indexEnd
+	assertString(t, expected, string(indexMake(data)))
+}
@@ -36,6 +36,28 @@ func TestIndexSome(t *testing.T) {
 	assertString(t, expected, string(Index("/file/", entrys)))
 }
 
+func TestIndexReadmeHTML(t *testing.T) {
+	expected := indexBegin +
+		`<time datetime="2023-12-02T12:23:25Z">2023-12-02T12:23:25Z</time><div>3 B</div><a href="README.md">README.md</a>` +
+		`</div><article id=m><h1>A</h1>` + "\n" + `</article>` + indexEnd
+
+	modTime := time.Date(2023, 12, 2, 13, 23, 25, 123, time.FixedZone("Paris", +1*3600))
+	data := NewIndexData("/file/", []fs.FileInfo{&Info{modTime: modTime, name: "README.md", size: 3}})
+	data.ReadmeHTML = ReadmeHTML(data.Readme, []byte("# A"))
+	assertString(t, expected, string(indexMake(data)))
+	assertString(t, string(data.ReadmeHTML), string(data.Query(IndexQuery{Page: 1, PageSize: 10}).ReadmeHTML))
+}
+
+func TestIndexUpload(t *testing.T) {
+	expected := indexBegin + `</div><form id=u method=post enctype=multipart/form-data><input type=file name=file multiple required> <button>Upload</button></form>` + indexEnd
+	data := NewIndexData("/file/", nil)
+	data.Upload = true
+	assertString(t, expected, string(indexMake(data)))
+	if !data.Query(IndexQuery{Page: 1}).Upload {
+		t.Error("the query loses Upload")
+	}
+}
+
 // Implement fs.Info and fs.FileInfo
 type Info struct {
 	name    string
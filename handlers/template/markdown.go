@@ -0,0 +1,475 @@
+package template
+
+import (
+	"html"
+	htmltemplate "html/template"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Render the readme content: a Markdown file (".md") is converted to HTML,
+// the other files are escaped in a pre element.
+func ReadmeHTML(name string, content []byte) htmltemplate.HTML {
+	if strings.EqualFold(path.Ext(name), ".md") {
+		return htmltemplate.HTML(Markdown(content))
+	}
+	return htmltemplate.HTML("<pre>" + html.EscapeString(string(content)) + "</pre>")
+}
+
+// Render a Markdown document to HTML, with headings, paragraphs, lists,
+// block quotes, code blocks, tables, emphasis, code spans, links and images.
+// The raw HTML is escaped, the links and the images accept only relative,
+// http, https and mailto URLs.
+func Markdown(src []byte) string {
+	text := strings.ReplaceAll(string(src), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\t", "    ")
+	buff := strings.Builder{}
+	markdownBlocks(&buff, strings.Split(text, "\n"))
+	return buff.String()
+}
+
+var (
+	mdHeading   = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ ]+(.*?))?(?:[ ]+#+)?[ ]*$`)
+	mdRule      = regexp.MustCompile(`^ {0,3}(?:(?:-[ ]*){3,}|(?:\*[ ]*){3,}|(?:_[ ]*){3,})$`)
+	mdFence     = regexp.MustCompile("^ {0,3}(```+|~~~+)[ ]*([A-Za-z0-9_+-]*)")
+	mdQuote     = regexp.MustCompile(`^ {0,3}> ?`)
+	mdItem      = regexp.MustCompile(`^( {0,3})([-*+]|[0-9]{1,9}[.)])( +|$)`)
+	mdTableSep  = regexp.MustCompile(`^ *\|? *:?-+:? *(?:\| *:?-+:? *)*\|? *$`)
+	mdEscapable = "\\`*_{}[]()#+-.!|<>~"
+)
+
+func markdownBlocks(buff *strings.Builder, lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+
+		case mdFence.MatchString(line):
+			m := mdFence.FindStringSubmatch(line)
+			i++
+			code := []string{}
+			for ; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), m[1]) {
+					i++
+					break
+				}
+				code = append(code, lines[i])
+			}
+			if m[2] != "" {
+				buff.WriteString(`<pre><code class="language-` + m[2] + `">`)
+			} else {
+				buff.WriteString(`<pre><code>`)
+			}
+			for _, l := range code {
+				buff.WriteString(html.EscapeString(l))
+				buff.WriteByte('\n')
+			}
+			buff.WriteString("</code></pre>\n")
+
+		case mdHeading.MatchString(line):
+			m := mdHeading.FindStringSubmatch(line)
+			level := strconv.Itoa(len(m[1]))
+			buff.WriteString("<h" + level + ">")
+			markdownInline(buff, m[2])
+			buff.WriteString("</h" + level + ">\n")
+			i++
+
+		case mdRule.MatchString(line):
+			buff.WriteString("<hr>\n")
+			i++
+
+		case mdQuote.MatchString(line):
+			quote := []string{}
+			for ; i < len(lines) && mdQuote.MatchString(lines[i]); i++ {
+				quote = append(quote, mdQuote.ReplaceAllString(lines[i], ""))
+			}
+			buff.WriteString("<blockquote>\n")
+			markdownBlocks(buff, quote)
+			buff.WriteString("</blockquote>\n")
+
+		case mdItem.MatchString(line):
+			i = markdownList(buff, lines, i)
+
+		case i+1 < len(lines) && strings.Contains(line, "|") && mdTableSep.MatchString(lines[i+1]):
+			i = markdownTable(buff, lines, i)
+
+		default:
+			paragraph := []string{strings.TrimSpace(line)}
+			for i++; i < len(lines) && !markdownBlockStart(lines[i]); i++ {
+				paragraph = append(paragraph, strings.TrimSpace(lines[i]))
+			}
+			buff.WriteString("<p>")
+			markdownInline(buff, strings.Join(paragraph, "\n"))
+			buff.WriteString("</p>\n")
+		}
+	}
+}
+
+// Test if the line ends a paragraph.
+func markdownBlockStart(line string) bool {
+	return strings.TrimSpace(line) == "" ||
+		mdFence.MatchString(line) ||
+		mdHeading.MatchString(line) ||
+		mdRule.MatchString(line) ||
+		mdQuote.MatchString(line) ||
+		mdItem.MatchString(line)
+}
+
+// Render the list beginning at the line i, and return the next line.
+func markdownList(buff *strings.Builder, lines []string, i int) int {
+	first := mdItem.FindStringSubmatch(lines[i])
+	ordered := !strings.ContainsAny(first[2][:1], "-*+")
+	marker := first[2][len(first[2])-1:]
+	if ordered {
+		start, _ := strconv.Atoi(first[2][:len(first[2])-1])
+		if start != 1 {
+			buff.WriteString(`<ol start="` + strconv.Itoa(start) + `">` + "\n")
+		} else {
+			buff.WriteString("<ol>\n")
+		}
+	} else {
+		buff.WriteString("<ul>\n")
+	}
+
+	for i < len(lines) {
+		m := mdItem.FindStringSubmatch(lines[i])
+		if m == nil || len(m[1]) != len(first[1]) || m[2][len(m[2])-1:] != marker {
+			break
+		}
+		indent := len(m[0])
+		if m[3] == "" {
+			indent++
+		}
+		item := []string{lines[i][len(m[0]):]}
+		loose := false
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				// A blank line continues the item if the next line is indented.
+				if i+1 < len(lines) && markdownIndent(lines[i+1]) >= indent {
+					loose = true
+					item = append(item, "")
+					continue
+				}
+				break
+			} else if markdownIndent(line) >= indent {
+				item = append(item, line[indent:])
+			} else if !markdownBlockStart(line) && !loose {
+				item = append(item, strings.TrimSpace(line))
+			} else {
+				break
+			}
+		}
+		if i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+			i++
+		}
+
+		sub := strings.Builder{}
+		markdownBlocks(&sub, item)
+		content := sub.String()
+		if !loose {
+			// Tight item: remove the paragraph around the text.
+			content = strings.Replace(content, "<p>", "", 1)
+			content = strings.Replace(content, "</p>\n", "\n", 1)
+		}
+		buff.WriteString("<li>")
+		buff.WriteString(strings.TrimSuffix(content, "\n"))
+		buff.WriteString("</li>\n")
+	}
+
+	if ordered {
+		buff.WriteString("</ol>\n")
+	} else {
+		buff.WriteString("</ul>\n")
+	}
+	return i
+}
+
+// Count the spaces at the beginning of the line.
+func markdownIndent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// Render the table beginning at the line i, and return the next line.
+func markdownTable(buff *strings.Builder, lines []string, i int) int {
+	header := markdownCells(lines[i])
+	aligns := markdownCells(lines[i+1])
+	for j, align := range aligns {
+		switch {
+		case strings.HasPrefix(align, ":") && strings.HasSuffix(align, ":"):
+			aligns[j] = ` style="text-align:center"`
+		case strings.HasSuffix(align, ":"):
+			aligns[j] = ` style="text-align:right"`
+		case strings.HasPrefix(align, ":"):
+			aligns[j] = ` style="text-align:left"`
+		default:
+			aligns[j] = ""
+		}
+	}
+
+	row := func(tag string, cells []string) {
+		buff.WriteString("<tr>")
+		for j := range header {
+			buff.WriteString("<" + tag)
+			if j < len(aligns) {
+				buff.WriteString(aligns[j])
+			}
+			buff.WriteString(">")
+			if j < len(cells) {
+				markdownInline(buff, cells[j])
+			}
+			buff.WriteString("</" + tag + ">")
+		}
+		buff.WriteString("</tr>\n")
+	}
+
+	buff.WriteString("<table>\n<thead>\n")
+	row("th", header)
+	buff.WriteString("</thead>\n<tbody>\n")
+	for i += 2; i < len(lines) && strings.Contains(lines[i], "|") && strings.TrimSpace(lines[i]) != ""; i++ {
+		row("td", markdownCells(lines[i]))
+	}
+	buff.WriteString("</tbody>\n</table>\n")
+	return i
+}
+
+// Split a table row in trimmed cells.
+func markdownCells(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	cells := []string{}
+	cell := strings.Builder{}
+	for j := 0; j < len(line); j++ {
+		if line[j] == '\\' && j+1 < len(line) && line[j+1] == '|' {
+			cell.WriteByte('|')
+			j++
+		} else if line[j] == '|' {
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		} else {
+			cell.WriteByte(line[j])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+// Maximum nesting of the inline elements, the deeper text is only escaped.
+const markdownMaxDepth = 16
+
+// The inline rendering of a text. The failed searches of a delimiter are
+// kept to not scan the text again, so the rendering time is linear.
+type markdownInliner struct {
+	buff  *strings.Builder
+	text  string
+	depth int
+
+	// The position from which the search of a delimiter has failed.
+	missing map[string]int
+	// The position of the closing bracket of each opening bracket.
+	brackets map[int]int
+}
+
+// Render the inline elements, all the text is escaped.
+func markdownInline(buff *strings.Builder, text string) {
+	(&markdownInliner{buff: buff, text: text}).render()
+}
+
+// Render a part of the text in a new nested inliner.
+func (in *markdownInliner) nested(text string) {
+	(&markdownInliner{buff: in.buff, text: text, depth: in.depth + 1}).render()
+}
+
+func (in *markdownInliner) render() {
+	buff, text := in.buff, in.text
+	if in.depth > markdownMaxDepth {
+		buff.WriteString(html.EscapeString(text))
+		return
+	}
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte(mdEscapable, text[i+1]) >= 0:
+			buff.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			run := len(text[i:]) - len(strings.TrimLeft(text[i:], "`"))
+			delimiter := text[i : i+run]
+			if end := in.index(i+run, delimiter); end >= 0 {
+				code := strings.TrimSpace(strings.ReplaceAll(text[i+run:end], "\n", " "))
+				buff.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i = end + run
+				continue
+			}
+			buff.WriteString(delimiter)
+			i += run
+			continue
+
+		case c == '!' && strings.HasPrefix(text[i+1:], "["):
+			if label, url, end := in.link(i + 1); end > 0 && markdownSafeURL(url) {
+				buff.WriteString(`<img src="` + html.EscapeString(url) + `" alt="` + html.EscapeString(label) + `">`)
+				i = end
+				continue
+			}
+
+		case c == '[':
+			if label, url, end := in.link(i); end > 0 && markdownSafeURL(url) {
+				buff.WriteString(`<a href="` + html.EscapeString(url) + `">`)
+				in.nested(label)
+				buff.WriteString("</a>")
+				i = end
+				continue
+			}
+
+		case c == '<':
+			// The URL ends at the first '>', without space or '<' before.
+			if end := strings.IndexAny(text[i+1:], " \n<>"); end > 0 && text[i+1+end] == '>' {
+				url := text[i+1 : i+1+end]
+				if strings.Contains(url, ":") && markdownSafeURL(url) {
+					buff.WriteString(`<a href="` + html.EscapeString(url) + `">` + html.EscapeString(url) + "</a>")
+					i += end + 2
+					continue
+				}
+			}
+
+		case c == '*' || c == '_':
+			run := 1
+			if i+1 < len(text) && text[i+1] == c {
+				run = 2
+			}
+			delimiter := text[i : i+run]
+			wordBefore := i > 0 && markdownWordChar(text[i-1])
+			if !(c == '_' && wordBefore) && i+run < len(text) && text[i+run] != ' ' {
+				if end := in.closeEmphasis(i+run, delimiter); end > 0 {
+					tag := "em"
+					if run == 2 {
+						tag = "strong"
+					}
+					buff.WriteString("<" + tag + ">")
+					in.nested(text[i+run : end])
+					buff.WriteString("</" + tag + ">")
+					i = end + run
+					continue
+				}
+			}
+			buff.WriteString(delimiter)
+			i += run
+			continue
+		}
+
+		buff.WriteString(html.EscapeString(text[i : i+1]))
+		i++
+	}
+}
+
+// Get the position of the delimiter from the position i, or -1.
+func (in *markdownInliner) index(i int, delimiter string) int {
+	if p, ok := in.missing[delimiter]; ok && i >= p {
+		return -1
+	}
+	end := strings.Index(in.text[i:], delimiter)
+	if end < 0 {
+		in.fail(i, delimiter)
+		return -1
+	}
+	return i + end
+}
+
+// Keep that the delimiter is missing from the position i.
+func (in *markdownInliner) fail(i int, delimiter string) {
+	if in.missing == nil {
+		in.missing = make(map[string]int)
+	}
+	in.missing[delimiter] = i
+}
+
+// Parse a link "[label](url)" or "[label](url "title")" at the position i,
+// return the position after the link or 0 if it's not a link.
+func (in *markdownInliner) link(i int) (label, url string, end int) {
+	if in.brackets == nil {
+		in.brackets = markdownBrackets(in.text)
+	}
+	j, ok := in.brackets[i]
+	if !ok || !strings.HasPrefix(in.text[j+1:], "(") {
+		return "", "", 0
+	}
+	closing := in.index(j+2, ")")
+	if closing < 0 {
+		return "", "", 0
+	}
+	destination := strings.TrimSpace(in.text[j+2 : closing])
+	destination, _, _ = strings.Cut(destination, " ")
+	destination = strings.TrimSuffix(strings.TrimPrefix(destination, "<"), ">")
+	return in.text[i+1 : j], destination, closing + 1
+}
+
+// Match the brackets of the text, the escaped brackets are skipped.
+func markdownBrackets(text string) map[int]int {
+	brackets := make(map[int]int)
+	opening := []int{}
+	for j := 0; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '[':
+			opening = append(opening, j)
+		case ']':
+			if len(opening) > 0 {
+				brackets[opening[len(opening)-1]] = j
+				opening = opening[:len(opening)-1]
+			}
+		}
+	}
+	return brackets
+}
+
+// Find the closing emphasis delimiter after the position i, not preceded by
+// a space. Return its position or 0.
+func (in *markdownInliner) closeEmphasis(i int, delimiter string) int {
+	text := in.text
+	if p, ok := in.missing[delimiter]; ok && i >= p {
+		return 0
+	}
+	for j := i + 1; j+len(delimiter) <= len(text); j++ {
+		if text[j] == '`' {
+			// Skip the code spans.
+			if end := in.index(j+1, "`"); end >= 0 {
+				j = end
+				continue
+			}
+		}
+		if strings.HasPrefix(text[j:], delimiter) && text[j-1] != ' ' &&
+			(len(delimiter) == 2 || !strings.HasPrefix(text[j+1:], delimiter[:1])) {
+			if delimiter[0] == '_' && j+len(delimiter) < len(text) && markdownWordChar(text[j+len(delimiter)]) {
+				continue
+			}
+			return j
+		}
+	}
+	in.fail(i, delimiter)
+	return 0
+}
+
+func markdownWordChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// Test if the URL is relative or has a safe scheme (http, https or mailto).
+func markdownSafeURL(url string) bool {
+	i := strings.IndexAny(url, ":/?#")
+	if i < 0 || url[i] != ':' {
+		return true
+	}
+	switch strings.ToLower(url[:i]) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
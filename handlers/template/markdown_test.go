@@ -0,0 +1,91 @@
+package template
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarkdown(t *testing.T) {
+	src := "# Title #\n" +
+		"Some *em*, **strong**, `<code>` and snake_case_name.\n" +
+		"Second line with a [link](https://example.com/a?b=1&c=2 \"title\").\n" +
+		"\n" +
+		"- one\n" +
+		"- two\n" +
+		"  - nested\n" +
+		"\n" +
+		"3. three\n" +
+		"4. four\n" +
+		"\n" +
+		"> quote\n" +
+		"\n" +
+		"```go\n" +
+		"if a < b {}\n" +
+		"```\n" +
+		"\n" +
+		"| Name | Size |\n" +
+		"|:-----|-----:|\n" +
+		"| a\\|b | 1 |\n" +
+		"\n" +
+		"---\n" +
+		"![logo](logo.png)\n"
+
+	expected := "<h1>Title</h1>\n" +
+		"<p>Some <em>em</em>, <strong>strong</strong>, <code>&lt;code&gt;</code> and snake_case_name.\n" +
+		"Second line with a <a href=\"https://example.com/a?b=1&amp;c=2\">link</a>.</p>\n" +
+		"<ul>\n<li>one</li>\n<li>two\n<ul>\n<li>nested</li>\n</ul></li>\n</ul>\n" +
+		"<ol start=\"3\">\n<li>three</li>\n<li>four</li>\n</ol>\n" +
+		"<blockquote>\n<p>quote</p>\n</blockquote>\n" +
+		"<pre><code class=\"language-go\">if a &lt; b {}\n</code></pre>\n" +
+		"<table>\n<thead>\n<tr><th style=\"text-align:left\">Name</th><th style=\"text-align:right\">Size</th></tr>\n</thead>\n" +
+		"<tbody>\n<tr><td style=\"text-align:left\">a|b</td><td style=\"text-align:right\">1</td></tr>\n</tbody>\n</table>\n" +
+		"<hr>\n" +
+		"<p><img src=\"logo.png\" alt=\"logo\"></p>\n"
+
+	assertString(t, expected, Markdown([]byte(src)))
+}
+
+func TestMarkdownSanitize(t *testing.T) {
+	src := "<script>alert(1)</script>\n" +
+		"[js](javascript:alert(1)) [data](DATA:text/html,x) <https://example.com/\">\n" +
+		"![x](javascript:alert(1)) \\*not em\\*"
+
+	expected := "<p>&lt;script&gt;alert(1)&lt;/script&gt;\n" +
+		"[js](javascript:alert(1)) [data](DATA:text/html,x) <a href=\"https://example.com/&#34;\">https://example.com/&#34;</a>\n" +
+		"![x](javascript:alert(1)) *not em*</p>\n"
+
+	assertString(t, expected, Markdown([]byte(src)))
+}
+
+func TestMarkdownLinear(t *testing.T) {
+	for _, src := range []string{
+		strings.Repeat("*a ", 100_000),
+		strings.Repeat("_a ", 100_000),
+		strings.Repeat("[", 200_000),
+		strings.Repeat("[a](", 100_000),
+		strings.Repeat("<a", 100_000),
+		strings.Repeat("*", 200_000),
+		strings.Repeat("*a `", 100_000),
+		strings.Repeat("[", 50_000) + "x" + strings.Repeat("](u)", 50_000),
+	} {
+		start := time.Now()
+		Markdown([]byte(src))
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%q...: rendered in %s", src[:8], d)
+		}
+	}
+
+	// The too deep elements are escaped.
+	src := strings.Repeat("[", 20) + "x" + strings.Repeat("](u)", 20)
+	html := Markdown([]byte(src))
+	if strings.Count(html, "<a ") != markdownMaxDepth+1 || !strings.Contains(html, "[x](u)") {
+		t.Errorf("wrong nested links: %q", html)
+	}
+}
+
+func TestReadmeHTML(t *testing.T) {
+	assertString(t, "<h1>A</h1>\n", string(ReadmeHTML("README.md", []byte("# A"))))
+	assertString(t, "<pre># &lt;A&gt;</pre>", string(ReadmeHTML("README", []byte("# <A>"))))
+	assertString(t, "<pre># A</pre>", string(ReadmeHTML("readme.txt", []byte("# A"))))
+}
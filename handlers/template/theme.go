@@ -0,0 +1,174 @@
+package template
+
+import (
+	"bytes"
+	"errors"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A theme renders the directory index and the error pages.
+type Theme interface {
+	Index(w io.Writer, data *IndexData) error
+	Error(w io.Writer, data *ErrorData) error
+}
+
+// The data of a directory index page.
+type IndexData struct {
+	// The directory path, ending with a slash.
+	Path string
+	// The links to the parents, from the root to the directory.
+	Breadcrumb []Crumb
+	// The entries without the hidden names, directories first then by name.
+	Entries []IndexEntry
+	// The name of the readme file, "" if none.
+	Readme string
+	// The readme rendered by the server, see ReadmeHTML.
+	// If empty, the default theme loads the readme on the client side.
+	ReadmeHTML htmltemplate.HTML
+	// The number of directories and files.
+	Dirs, Files int
+	// The total size of the files.
+	Size int64
+
+	// The sort key, IndexSortName ("") by default.
+	Sort string
+	// The sort is descending.
+	Desc bool
+	// The page number from 1, and the number of pages.
+	Page, Pages int
+	// The directory can be downloaded as an archive
+	// with the query "download=zip" or "download=tar.gz".
+	Archive bool
+	// The files can be uploaded in the directory,
+	// with a multipart POST request of the field "file".
+	Upload bool
+}
+
+// A link of the breadcrumb.
+type Crumb struct {
+	Name string
+	Href string
+}
+
+// An entry of a directory index.
+type IndexEntry struct {
+	// The name, ending with a slash for a directory.
+	Name    string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+}
+
+// The data of an error page.
+type ErrorData struct {
+	// The status code, like 404.
+	Status int
+	// The status text, like "Not Found".
+	StatusText string
+	// The request path.
+	Path string
+	// The X-Request-Id header of the request, or a random ID.
+	RequestID string
+}
+
+// Create the index data with all the entries, hide the names beginning
+// with a dot.
+func NewIndexData(path string, entrys []fs.FileInfo) *IndexData {
+	builder := NewIndexBuilder(path, IndexQuery{})
+	for _, entry := range entrys {
+		builder.Add(entry)
+	}
+	return builder.Data()
+}
+
+// Get the links of the parents of path. The last link href is ""
+// if path is not a directory.
+func breadcrumb(path string) []Crumb {
+	crumbs := []Crumb{{"/", "/"}}
+	splits := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	end := splits[len(splits)-1]
+	href := "/"
+	for _, p := range splits[:len(splits)-1] {
+		href += p + "/"
+		crumbs = append(crumbs, Crumb{p + "/", href})
+	}
+	if end != "" {
+		crumbs = append(crumbs, Crumb{end, ""})
+	}
+	return crumbs
+}
+
+// The built-in theme.
+var DefaultTheme Theme = defaultTheme{}
+
+type defaultTheme struct{}
+
+func (defaultTheme) Index(w io.Writer, data *IndexData) error {
+	_, err := w.Write(indexMake(data))
+	return err
+}
+
+func (defaultTheme) Error(w io.Writer, data *ErrorData) error {
+	_, err := w.Write(Error(data.Status, data.Path))
+	return err
+}
+
+// A theme from html/template files, see LoadTheme.
+type templateTheme struct {
+	index *htmltemplate.Template
+	error *htmltemplate.Template
+}
+
+// Load a theme from a directory with the html/template files "index.html"
+// executed with *IndexData and "error.html" executed with *ErrorData.
+// A missing file is replaced by the DefaultTheme page.
+// The templates have the functions "size" (like "1 234 B") and "time" (RFC 3339).
+func LoadTheme(dir string) (Theme, error) {
+	theme := &templateTheme{}
+	for name, dst := range map[string]**htmltemplate.Template{
+		"index.html": &theme.index,
+		"error.html": &theme.error,
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		tmpl, err := htmltemplate.New(name).Funcs(themeFuncs).Parse(string(data))
+		if err != nil {
+			return nil, err
+		}
+		*dst = tmpl
+	}
+	return theme, nil
+}
+
+var themeFuncs = htmltemplate.FuncMap{
+	"size": func(size int64) string {
+		buff := bytes.Buffer{}
+		htmlSize(&buff, size)
+		return buff.String()
+	},
+	"time": func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}
+
+func (theme *templateTheme) Index(w io.Writer, data *IndexData) error {
+	if theme.index == nil {
+		return DefaultTheme.Index(w, data)
+	}
+	return theme.index.Execute(w, data)
+}
+
+func (theme *templateTheme) Error(w io.Writer, data *ErrorData) error {
+	if theme.error == nil {
+		return DefaultTheme.Error(w, data)
+	}
+	return theme.error.Execute(w, data)
+}
@@ -0,0 +1,86 @@
+package template
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewIndexData(t *testing.T) {
+	modTime := time.Date(2023, 12, 2, 13, 23, 25, 0, time.FixedZone("Paris", +1*3600))
+	data := NewIndexData("/a/b/", []fs.FileInfo{
+		&Info{modTime: modTime, name: "readme.txt", size: 10},
+		&Info{modTime: modTime, name: "file", size: 5},
+		&Info{modTime: modTime, name: "dir", size: 0},
+		&Info{modTime: modTime, name: ".hide", size: 7},
+	})
+
+	if data.Path != "/a/b/" || data.Readme != "readme.txt" || data.Dirs != 1 || data.Files != 2 || data.Size != 15 {
+		t.Errorf("wrong data: %+v", data)
+	}
+	expectedCrumbs := []Crumb{{"/", "/"}, {"a/", "/a/"}, {"b/", "/a/b/"}}
+	if len(data.Breadcrumb) != len(expectedCrumbs) {
+		t.Fatalf("wrong breadcrumb: %+v", data.Breadcrumb)
+	}
+	for i, crumb := range expectedCrumbs {
+		if data.Breadcrumb[i] != crumb {
+			t.Errorf("breadcrumb[%d]: %+v", i, data.Breadcrumb[i])
+		}
+	}
+	expectedEntries := []IndexEntry{
+		{Name: "dir/", IsDir: true, ModTime: modTime.UTC()},
+		{Name: "file", Size: 5, ModTime: modTime.UTC()},
+		{Name: "readme.txt", Size: 10, ModTime: modTime.UTC()},
+	}
+	if len(data.Entries) != len(expectedEntries) {
+		t.Fatalf("wrong entries: %+v", data.Entries)
+	}
+	for i, entry := range expectedEntries {
+		if data.Entries[i] != entry {
+			t.Errorf("entries[%d]: %+v", i, data.Entries[i])
+		}
+	}
+
+	crumbs := breadcrumb("/file.txt")
+	if len(crumbs) != 2 || crumbs[1] != (Crumb{"file.txt", ""}) {
+		t.Errorf("wrong breadcrumb: %+v", crumbs)
+	}
+}
+
+func TestDefaultTheme(t *testing.T) {
+	buff := bytes.Buffer{}
+	DefaultTheme.Index(&buff, NewIndexData("/file/", nil))
+	assertString(t, indexBegin+`</div>`+indexEnd, buff.String())
+
+	buff.Reset()
+	DefaultTheme.Error(&buff, &ErrorData{Status: 404, Path: "/file/"})
+	assertString(t, string(Error404("/file/")), buff.String())
+}
+
+func TestLoadTheme(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte(`{{.Path}}:{{range .Entries}} {{.Name}}={{size .Size}}@{{time .ModTime}}{{end}} total={{.Files}}`), 0o644)
+
+	theme, err := LoadTheme(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buff := bytes.Buffer{}
+	modTime := time.Date(2023, 12, 2, 13, 23, 25, 0, time.UTC)
+	theme.Index(&buff, NewIndexData("/<d>/", []fs.FileInfo{&Info{modTime: modTime, name: "a&b", size: 1234}}))
+	assertString(t, `/&lt;d&gt;/: a&amp;b=1 234 B@2023-12-02T13:23:25Z total=1`, buff.String())
+
+	// No error.html, use the default page.
+	buff.Reset()
+	theme.Error(&buff, &ErrorData{Status: 502, Path: "/api/"})
+	assertString(t, string(Error502("/api/")), buff.String())
+
+	os.WriteFile(filepath.Join(dir, "error.html"), []byte(`{{.Status`), 0o644)
+	if _, err := LoadTheme(dir); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+}
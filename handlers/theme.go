@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"bytes"
+	"log/slog"
+
+	"github.com/HuguesGuilleus/servHTTP/handlers/template"
+)
+
+// Load the theme directory, or get the default theme if dir is empty
+// or if the load fail.
+func loadTheme(logger *slog.Logger, dir string) template.Theme {
+	if dir == "" {
+		return template.DefaultTheme
+	}
+	theme, err := template.LoadTheme(dir)
+	if err != nil {
+		logger.Error("theme-load", "dir", dir, "err", err.Error())
+		return template.DefaultTheme
+	}
+	return theme
+}
+
+func themeOrDefault(theme template.Theme) template.Theme {
+	if theme == nil {
+		return template.DefaultTheme
+	}
+	return theme
+}
+
+// Render the directory index with the theme, nil for the default theme.
+func renderIndex(theme template.Theme, data *template.IndexData) ([]byte, error) {
+	buff := bytes.Buffer{}
+	if err := themeOrDefault(theme).Index(&buff, data); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/HuguesGuilleus/servHTTP/handlers/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTheme(t *testing.T) {
+	themeDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(themeDir, "index.html"), []byte(`index {{.Path}}{{range .Entries}} {{.Name}}{{end}}`), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(themeDir, "error.html"), []byte(`error {{.Status}} {{.Path}}`), 0o644))
+	root := t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(root, "dir"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "dir", "file.txt"), []byte("file"), 0o644))
+
+	logger, logBuffer := testLoggerOne()
+	hand := FileOptions(logger, root, "", Options{Theme: themeDir})
+
+	w := httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/dir/", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "index /dir/ file.txt", w.Body.String())
+
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/missing", nil))
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, "error 404 /missing", w.Body.String())
+
+	logBuffer.Reset()
+	assert.Equal(t, template.DefaultTheme, loadTheme(logger, ""))
+	assert.Empty(t, logBuffer.String())
+	assert.Equal(t, template.DefaultTheme, loadTheme(logger, filepath.Join(root, "dir", "file.txt")))
+	assert.Contains(t, logBuffer.String(), "level=ERROR msg=theme-load")
+}
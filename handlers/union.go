@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// The whiteout files of the union roots: in a layer, ".wh.name" hides name
+// in the lower layers, and ".wh..wh..opq" hides all the lower entries of its
+// directory. The whiteout files are never served.
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// Get the filesystem of the root, merged with the roots opt.Under,
+// with the symbolic link policy.
+func unionRootFS(root string, opt Options) fs.FS {
+	if len(opt.Under) == 0 {
+		return rootFS(root, opt.Symlinks)
+	}
+	fsys := unionFS{rootFS(root, opt.Symlinks)}
+	for _, under := range opt.Under {
+		fsys = append(fsys, rootFS(under, opt.Symlinks))
+	}
+	return fsys
+}
+
+// The union of filesystem layers, the first layer wins on conflicts.
+// The directories are merged, a file hides the lower directories.
+type unionFS []fs.FS
+
+func (fsys unionFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	for _, component := range strings.Split(name, "/") {
+		if strings.HasPrefix(component, whiteoutPrefix) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+	}
+
+	for i, layer := range fsys {
+		file, err := layer.Open(name)
+		if err == nil {
+			info, err := file.Stat()
+			if err != nil {
+				file.Close()
+				return nil, err
+			} else if info.IsDir() {
+				return &unionDir{File: file, layers: fsys[i:], name: name}, nil
+			}
+			return file, nil
+		} else if errors.Is(err, syscall.ENOTDIR) {
+			// A parent is a file in this layer.
+			break
+		} else if !errors.Is(err, fs.ErrNotExist) || errors.Is(err, errSymlink) {
+			return nil, err
+		} else if whiteout(layer, name) {
+			break
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// Test if the layer hides name in the lower layers: a whiteout file of name
+// or of a parent, or an opaque parent directory.
+func whiteout(layer fs.FS, name string) bool {
+	dir := "."
+	for _, component := range strings.Split(name, "/") {
+		if component == "." {
+			break
+		} else if exists(layer, path.Join(dir, whiteoutPrefix+component)) || exists(layer, path.Join(dir, whiteoutOpaque)) {
+			return true
+		}
+		dir = path.Join(dir, component)
+	}
+	return false
+}
+
+func exists(fsys fs.FS, name string) bool {
+	_, err := fs.Stat(fsys, name)
+	return err == nil
+}
+
+// A directory of an union, the entries of all the layers are merged.
+type unionDir struct {
+	// The directory of the first layer.
+	fs.File
+	// The layers from the first layer where the directory exists.
+	layers unionFS
+	name   string
+
+	// The merged entries, read on the first ReadDir.
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (dir *unionDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !dir.read {
+		entries, err := dir.layers.merge(dir.name)
+		if err != nil {
+			return nil, err
+		}
+		dir.entries, dir.read = entries, true
+	}
+
+	if n <= 0 {
+		entries := dir.entries
+		dir.entries = nil
+		return entries, nil
+	} else if len(dir.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(dir.entries))
+	entries := dir.entries[:n]
+	dir.entries = dir.entries[n:]
+	return entries, nil
+}
+
+// Merge the entries of the directory name, sorted by name.
+// The first layer must have the directory.
+func (fsys unionFS) merge(name string) ([]fs.DirEntry, error) {
+	hidden := make(map[string]bool)
+	entries := []fs.DirEntry{}
+	for i, layer := range fsys {
+		list, err := fs.ReadDir(layer, name)
+		if err != nil && (i == 0 || !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, syscall.ENOTDIR)) {
+			return nil, err
+		} else if errors.Is(err, syscall.ENOTDIR) {
+			// A file hides the lower directories.
+			break
+		}
+
+		opaque := false
+		for _, entry := range list {
+			switch n := entry.Name(); {
+			case n == whiteoutOpaque:
+				opaque = true
+			case strings.HasPrefix(n, whiteoutPrefix):
+				hidden[strings.TrimPrefix(n, whiteoutPrefix)] = true
+			case !hidden[n]:
+				hidden[n] = true
+				entries = append(entries, entry)
+			}
+		}
+		if opaque || i+1 < len(fsys) && whiteout(layer, name) {
+			break
+		}
+	}
+
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, nil
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Create the layers site over theme, and return the union filesystem.
+func testUnion(t *testing.T) fs.FS {
+	site, theme := t.TempDir(), t.TempDir()
+	write := func(root, name, content string) {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		assert.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	}
+	write(site, "a.txt", "site a")
+	write(site, "dir/x.txt", "site x")
+	write(site, ".wh.hidden.txt", "")
+	write(site, ".wh.gone", "")
+	write(site, "opaque/.wh..wh..opq", "")
+	write(site, "opaque/own.txt", "own")
+	write(site, "conflict/in.txt", "in")
+	write(site, "shadow", "site shadow")
+
+	write(theme, "a.txt", "theme a")
+	write(theme, "b.txt", "theme b")
+	write(theme, "dir/y.txt", "theme y")
+	write(theme, "hidden.txt", "hidden")
+	write(theme, "gone/g.txt", "g")
+	write(theme, "opaque/lower.txt", "lower")
+	write(theme, "conflict", "theme conflict")
+	write(theme, "shadow/s.txt", "s")
+
+	return unionRootFS(site, Options{Under: []string{theme}})
+}
+
+func TestUnionFS(t *testing.T) {
+	fsys := testUnion(t)
+
+	readDir := func(name string) (names []string) {
+		entries, err := fs.ReadDir(fsys, name)
+		assert.NoError(t, err, name)
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return
+	}
+	assert.Equal(t, []string{"a.txt", "b.txt", "conflict", "dir", "opaque", "shadow"}, readDir("."))
+	assert.Equal(t, []string{"x.txt", "y.txt"}, readDir("dir"))
+	assert.Equal(t, []string{"own.txt"}, readDir("opaque"))
+	assert.Equal(t, []string{"in.txt"}, readDir("conflict"))
+
+	readFile := func(name, expected string) {
+		data, err := fs.ReadFile(fsys, name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, expected, string(data), name)
+	}
+	readFile("a.txt", "site a")
+	readFile("b.txt", "theme b")
+	readFile("dir/y.txt", "theme y")
+	readFile("shadow", "site shadow")
+
+	for _, name := range []string{"hidden.txt", "gone", "gone/g.txt", "opaque/lower.txt", "shadow/s.txt", ".wh.gone"} {
+		_, err := fsys.Open(name)
+		assert.ErrorIs(t, err, fs.ErrNotExist, name)
+	}
+
+	// Read the directory by chunks.
+	dir, err := fsys.Open(".")
+	assert.NoError(t, err)
+	defer dir.Close()
+	entries, err := dir.(fs.ReadDirFile).ReadDir(4)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+	entries, err = dir.(fs.ReadDirFile).ReadDir(4)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	_, err = dir.(fs.ReadDirFile).ReadDir(4)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestUnionHandlers(t *testing.T) {
+	fsys := testUnion(t)
+	logger, _ := testLoggerOne()
+	cache := &cacheHandler{common: common{Logger: logger, deny: newDenyPolicy(Options{AllowDotfiles: true})}}
+	cache.Update(fsys, time.Now())
+	handlers := map[string]http.Handler{
+		"file": &fileHandler{
+			common: common{Logger: logger, deny: newDenyPolicy(Options{AllowDotfiles: true})},
+			fsys:   http.FS(fsys),
+		},
+		"cache": cache,
+	}
+
+	for name, hand := range handlers {
+		t.Run(name, func(t *testing.T) {
+			serve := func(url string) *httptest.ResponseRecorder {
+				w := httptest.NewRecorder()
+				hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+url, nil))
+				return w
+			}
+			assert.Equal(t, "site a", serve("/a.txt").Body.String())
+			assert.Equal(t, "theme b", serve("/b.txt").Body.String())
+			assert.Equal(t, "theme y", serve("/dir/y.txt").Body.String())
+			assert.Equal(t, 404, serve("/hidden.txt").Code)
+			assert.Equal(t, 404, serve("/.wh.hidden.txt").Code)
+			assert.Equal(t, 404, serve("/gone/g.txt").Code)
+
+			index := serve("/dir/").Body.String()
+			assert.Contains(t, index, `<a href="x.txt">x.txt</a>`)
+			assert.Contains(t, index, `<a href="y.txt">y.txt</a>`)
+			index = serve("/").Body.String()
+			assert.Contains(t, index, `<a href="b.txt">b.txt</a>`)
+			assert.NotContains(t, index, "hidden.txt")
+			assert.NotContains(t, index, "gone")
+		})
+	}
+}
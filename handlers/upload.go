@@ -0,0 +1,658 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// The default maximal size of an uploaded file.
+	uploadDefaultMax = 1 << 30
+	// The version of the tus protocol.
+	uploadTusVersion = "1.0.0"
+	// The media type of the PATCH requests.
+	uploadPatchMIME = "application/offset+octet-stream"
+	// The prefix of the incomplete files, a dotfile so denied by default.
+	uploadTempPrefix = ".upload-"
+	// The interval between two removals of the expired resumable uploads.
+	uploadCleanInterval = time.Hour
+)
+
+// The incomplete resumable uploads are removed after this duration
+// without modification.
+var UploadExpiration = 24 * time.Hour
+
+var (
+	errUploadTooLarge = errors.New("upload too large")
+	errUploadQuota    = errors.New("upload quota exceeded")
+)
+
+// Serve the files of root like the file handler, and save the files
+// uploaded in its directories:
+//   - POST of a multipart/form-data on a directory, with the file fields;
+//     redirected (303) to the directory
+//   - PUT of the content on the file URL; Location gives the saved file
+//   - resumable uploads of the tus protocol (https://tus.io), with the
+//     creation extension: POST on a directory with the header Upload-Length
+//     and the filename in Upload-Metadata creates an upload at Location,
+//     then HEAD gives its Upload-Offset and PATCH appends a chunk at
+//     Upload-Offset.
+//
+// The file names are sanitized, and the files are written in a temporary
+// dotfile then linked at once to their name when complete. An existing file
+// is never replaced, a suffix is added to the name like "name-1.ext".
+// All the responses are sandboxed (Content-Security-Policy): the files can
+// not run script, and the indexes run their script in a unique origin, so
+// an uploaded HTML page, even an index.html, can not run script on the site.
+//
+// The uploads need the basic authentication of a user of the option Users,
+// or the option Anonymous. With Quota, the total size of the files is
+// limited (507 Insufficient Storage), walked once then followed with the
+// uploads. The expired resumable uploads are removed all hour.
+func Upload(logger *slog.Logger, root, cacheControl string, opt Options) http.Handler {
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	maxSize := opt.UploadMax
+	if maxSize == 0 {
+		maxSize = uploadDefaultMax
+	}
+	users := newBasicUsers(logger, opt.Users)
+	if len(users.hashes) == 0 && !opt.Anonymous {
+		logger.Warn("upload-no-user")
+	}
+	file := FileFS(logger, rootFS(root, SymlinkDeny), cacheControl, opt).(*fileHandler)
+	file.uploadForm = opt.UploadForm
+	hand := &uploadHandler{
+		fileHandler: file,
+		root:        root,
+		users:       users,
+		anonymous:   opt.Anonymous,
+		maxSize:     maxSize,
+		quota:       opt.Quota,
+		parts:       make(map[string]int64),
+	}
+	go hand.run()
+	return hand
+}
+
+type uploadHandler struct {
+	// Serve GET and HEAD requests.
+	*fileHandler
+	root      string
+	users     *basicUsers
+	anonymous bool
+	// The maximal size of a file, negative for no limit.
+	maxSize int64
+	// The resumable uploads in progress, by id.
+	patching sync.Map
+
+	// The maximal total size of the files, 0 for no limit.
+	quota int64
+	// The total size of the files if usageKnown, without the incomplete
+	// files of the previous runs.
+	usageMutex sync.Mutex
+	usage      int64
+	usageKnown bool
+	// The bytes of the resumable uploads counted in usage, by base path.
+	parts map[string]int64
+}
+
+// The metadata of a resumable upload, saved beside the data.
+type uploadInfo struct {
+	Name   string `json:"name"`
+	Length int64  `json:"length"`
+}
+
+func (hand *uploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hand.sandbox(w, r)
+	id := r.URL.Query().Get("upload")
+	switch r.Method {
+	case "GET":
+		hand.fileHandler.ServeHTTP(w, r)
+		return
+	case "HEAD":
+		if id == "" {
+			hand.fileHandler.ServeHTTP(w, r)
+			return
+		}
+	case "OPTIONS":
+		w.Header().Set("Tus-Version", uploadTusVersion)
+		w.Header().Set("Tus-Extension", "creation")
+		if hand.maxSize >= 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(hand.maxSize, 10))
+		}
+		hand.serveStatus(w, r, http.StatusNoContent)
+		return
+	case "POST", "PUT", "PATCH":
+	default:
+		hand.serveStatus(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !hand.anonymous && !hand.users.authorized(r) {
+		hand.serveUnauthorized(w, r)
+		return
+	} else if hand.deny.denied(r.URL.Path) {
+		hand.serveStatus(w, r, hand.deny.statusCode())
+		return
+	}
+
+	p := path.Clean("/" + r.URL.Path)
+	switch {
+	case r.Method == "PUT":
+		hand.servePut(w, r, p)
+	case r.Method == "POST" && r.Header.Get("Upload-Length") != "":
+		w.Header().Set("Tus-Resumable", uploadTusVersion)
+		hand.serveCreate(w, r, p)
+	case r.Method == "POST":
+		hand.serveMultipart(w, r, p)
+	default:
+		w.Header().Set("Tus-Resumable", uploadTusVersion)
+		hand.serveResume(w, r, p, id)
+	}
+}
+
+// Serve all the responses in a sandbox: an uploaded page can not run script
+// with the site origin. The indexes, maybe an uploaded index.html, keep
+// their script and form in a unique origin.
+func (hand *uploadHandler) sandbox(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/") {
+		w.Header().Set("Content-Security-Policy", "sandbox allow-scripts allow-forms")
+	} else {
+		w.Header().Set("Content-Security-Policy", "sandbox")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
+// Get the free size of the quota, -1 for no limit.
+func (hand *uploadHandler) available() (int64, error) {
+	if hand.quota <= 0 {
+		return -1, nil
+	}
+	hand.usageMutex.Lock()
+	defer hand.usageMutex.Unlock()
+	if !hand.usageKnown {
+		size, err := dirSize(hand.root, uploadTemp)
+		if err != nil {
+			return 0, err
+		}
+		hand.usage, hand.usageKnown = size, true
+	}
+	return max(hand.quota-hand.usage, 0), nil
+}
+
+// Count n written bytes in the usage, or return errUploadQuota if they
+// exceed the quota.
+func (hand *uploadHandler) reserve(n int64) error {
+	available, err := hand.available()
+	if err != nil || available < 0 {
+		return err
+	}
+	hand.usageMutex.Lock()
+	defer hand.usageMutex.Unlock()
+	if hand.usage+n > hand.quota {
+		return errUploadQuota
+	}
+	hand.usage += n
+	return nil
+}
+
+// Count the n bytes appended at offset to the resumable upload base, with
+// its bytes written before a restart.
+func (hand *uploadHandler) reservePart(base string, offset, n int64) error {
+	hand.usageMutex.Lock()
+	counted := hand.parts[base]
+	hand.usageMutex.Unlock()
+	if err := hand.reserve(offset - counted + n); err != nil {
+		return err
+	}
+	hand.usageMutex.Lock()
+	defer hand.usageMutex.Unlock()
+	if hand.quota > 0 {
+		hand.parts[base] = offset + n
+	}
+	return nil
+}
+
+// Forget the resumable upload base, its bytes are removed from the usage if
+// it's removed.
+func (hand *uploadHandler) releasePart(base string, removed bool) {
+	hand.usageMutex.Lock()
+	defer hand.usageMutex.Unlock()
+	if removed {
+		hand.usage -= hand.parts[base]
+	}
+	delete(hand.parts, base)
+}
+
+// Remove n bytes from the usage.
+func (hand *uploadHandler) release(n int64) {
+	hand.usageMutex.Lock()
+	defer hand.usageMutex.Unlock()
+	hand.usage -= n
+}
+
+// Get the filesystem path of the directory of the URL path p.
+func (hand *uploadHandler) dir(p string) (string, error) {
+	full, err := noSymlinkPath(hand.root, p)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(full)
+	if err != nil {
+		return "", err
+	} else if !info.IsDir() {
+		return "", &fs.PathError{Op: "upload", Path: p, Err: fs.ErrNotExist}
+	}
+	return full, nil
+}
+
+// Sanitize a file name from a client: keep the base name, remove the control
+// and reserved characters, the leading and trailing dots and spaces, and
+// limit the length to 255 bytes. Return "" if nothing remains.
+func uploadName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7F || r == utf8.RuneError || strings.ContainsRune(`<>:"|?*`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	for len(name) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return strings.Trim(name, ". ")
+}
+
+// Write the content in a temporary file in dir, then link it to name.
+// Return the final name.
+func (hand *uploadHandler) save(dir, name string, content io.Reader) (string, error) {
+	tmp, err := os.CreateTemp(dir, uploadTempPrefix+"*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	available, err := hand.available()
+	if err != nil {
+		return "", err
+	}
+	limit := hand.maxSize
+	if limit < 0 || available >= 0 && available < limit {
+		limit = available
+	}
+	if limit >= 0 {
+		content = io.LimitReader(content, limit+1)
+	}
+	n, err := io.Copy(tmp, content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	} else if hand.maxSize >= 0 && n > hand.maxSize {
+		return "", errUploadTooLarge
+	} else if available >= 0 && n > available {
+		return "", errUploadQuota
+	} else if err := hand.reserve(n); err != nil {
+		return "", err
+	}
+	final, err := uploadLink(tmp.Name(), dir, name)
+	if err != nil {
+		hand.release(n)
+	}
+	return final, err
+}
+
+// Link the complete file tmp to name in dir, or to "name-1.ext",
+// "name-2.ext"... if the name exists. Return the final name.
+func uploadLink(tmp, dir, name string) (string, error) {
+	if err := os.Chmod(tmp, 0o644); err != nil {
+		return "", err
+	}
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; i < 1000; i++ {
+		final := name
+		if i > 0 {
+			final = base + "-" + strconv.Itoa(i) + ext
+		}
+		err := os.Link(tmp, filepath.Join(dir, final))
+		if err == nil {
+			cacheInvalidate(dir, false)
+			return final, nil
+		} else if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free name for %q", name)
+}
+
+// Send the status of a save error.
+func (hand *uploadHandler) serveSaveError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errUploadTooLarge) {
+		hand.serveStatus(w, r, http.StatusRequestEntityTooLarge)
+	} else if errors.Is(err, errUploadQuota) {
+		hand.serveStatus(w, r, http.StatusInsufficientStorage)
+	} else {
+		hand.serveFSError(w, r, err)
+	}
+}
+
+func (hand *uploadHandler) servePut(w http.ResponseWriter, r *http.Request, p string) {
+	parent, name := path.Split(p)
+	name = uploadName(name)
+	if name == "" || strings.HasSuffix(r.URL.Path, "/") {
+		hand.serveStatus(w, r, http.StatusBadRequest)
+		return
+	} else if hand.deny.denied(path.Join(parent, name)) {
+		hand.serveStatus(w, r, hand.deny.statusCode())
+		return
+	} else if hand.maxSize >= 0 && r.ContentLength > hand.maxSize {
+		hand.serveStatus(w, r, http.StatusRequestEntityTooLarge)
+		return
+	} else if available, err := hand.available(); err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	} else if available >= 0 && r.ContentLength > available {
+		hand.serveStatus(w, r, http.StatusInsufficientStorage)
+		return
+	}
+	dir, err := hand.dir(parent)
+	if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+
+	final, err := hand.save(dir, name, r.Body)
+	if err != nil {
+		hand.serveSaveError(w, r, err)
+		return
+	}
+	hand.Logger.Info("upload", "file", path.Join(parent, final))
+	w.Header().Set("Location", (&url.URL{Path: path.Join(parent, final)}).EscapedPath())
+	hand.serveStatus(w, r, http.StatusCreated)
+}
+
+func (hand *uploadHandler) serveMultipart(w http.ResponseWriter, r *http.Request, p string) {
+	dir, err := hand.dir(p)
+	if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		hand.serveStatus(w, r, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	saved := 0
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			hand.serveStatus(w, r, http.StatusBadRequest)
+			return
+		} else if part.FileName() == "" {
+			continue
+		}
+
+		name := uploadName(part.FileName())
+		if name == "" {
+			hand.serveStatus(w, r, http.StatusBadRequest)
+			return
+		} else if hand.deny.denied(path.Join(p, name)) {
+			hand.serveStatus(w, r, hand.deny.statusCode())
+			return
+		}
+		final, err := hand.save(dir, name, part)
+		if err != nil {
+			hand.serveSaveError(w, r, err)
+			return
+		}
+		hand.Logger.Info("upload", "file", path.Join(p, final))
+		saved++
+	}
+	if saved == 0 {
+		hand.serveStatus(w, r, http.StatusBadRequest)
+		return
+	}
+
+	LogRequest(hand.Logger, http.StatusSeeOther, r)
+	http.Redirect(w, r, strings.TrimSuffix(p, "/")+"/", http.StatusSeeOther)
+}
+
+// Create a resumable upload.
+func (hand *uploadHandler) serveCreate(w http.ResponseWriter, r *http.Request, p string) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	name := uploadName(uploadMetadata(r.Header.Get("Upload-Metadata")))
+	if err != nil || length < 0 || name == "" {
+		hand.serveStatus(w, r, http.StatusBadRequest)
+		return
+	} else if hand.maxSize >= 0 && length > hand.maxSize {
+		hand.serveStatus(w, r, http.StatusRequestEntityTooLarge)
+		return
+	} else if hand.deny.denied(path.Join(p, name)) {
+		hand.serveStatus(w, r, hand.deny.statusCode())
+		return
+	}
+	dir, err := hand.dir(p)
+	if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+	if available, err := hand.available(); err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	} else if available >= 0 && length > available {
+		hand.serveStatus(w, r, http.StatusInsufficientStorage)
+		return
+	}
+
+	random := [16]byte{}
+	rand.Read(random[:])
+	id := hex.EncodeToString(random[:])
+	info, _ := json.Marshal(uploadInfo{Name: name, Length: length})
+	base := filepath.Join(dir, uploadTempPrefix+id)
+	if err := os.WriteFile(base+".part", nil, 0o644); err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	} else if err := os.WriteFile(base+".info", info, 0o644); err != nil {
+		os.Remove(base + ".part")
+		hand.serveFSError(w, r, err)
+		return
+	}
+
+	if length == 0 {
+		if err := hand.complete(p, base, name); err != nil {
+			hand.serveFSError(w, r, err)
+			return
+		}
+	}
+	w.Header().Set("Location", (&url.URL{Path: strings.TrimSuffix(p, "/") + "/", RawQuery: "upload=" + id}).String())
+	w.Header().Set("Upload-Offset", "0")
+	hand.serveStatus(w, r, http.StatusCreated)
+}
+
+// Get the file name from the tus Upload-Metadata header,
+// like "filename d29ybGQ=,type dGV4dC9wbGFpbg==".
+func uploadMetadata(header string) string {
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "filename" || key == "name" {
+			name, _ := base64.StdEncoding.DecodeString(value)
+			return string(name)
+		}
+	}
+	return ""
+}
+
+// Serve HEAD and PATCH requests of a resumable upload.
+func (hand *uploadHandler) serveResume(w http.ResponseWriter, r *http.Request, p, id string) {
+	w.Header().Set(headerCacheControl, "no-store")
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 32 {
+		hand.serveStatus(w, r, http.StatusNotFound)
+		return
+	}
+	dir, err := hand.dir(p)
+	if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+	base := filepath.Join(dir, uploadTempPrefix+id)
+
+	// Only one request by upload.
+	if _, patching := hand.patching.LoadOrStore(base, true); patching {
+		hand.serveStatus(w, r, http.StatusConflict)
+		return
+	}
+	defer hand.patching.Delete(base)
+
+	var info uploadInfo
+	if data, err := os.ReadFile(base + ".info"); err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	} else if err := json.Unmarshal(data, &info); err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+	stat, err := os.Stat(base + ".part")
+	if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+	offset := stat.Size()
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+
+	if r.Method == "HEAD" {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		hand.serveStatus(w, r, http.StatusOK)
+		return
+	} else if r.Header.Get(headerContentType) != uploadPatchMIME {
+		hand.serveStatus(w, r, http.StatusUnsupportedMediaType)
+		return
+	} else if r.Header.Get("Upload-Offset") != strconv.FormatInt(offset, 10) {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		hand.serveStatus(w, r, http.StatusConflict)
+		return
+	}
+
+	file, err := os.OpenFile(base+".part", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+	available, err := hand.available()
+	if err != nil {
+		file.Close()
+		hand.serveFSError(w, r, err)
+		return
+	}
+	remaining := info.Length - offset
+	n, err := io.Copy(file, io.LimitReader(r.Body, remaining+1))
+	if n > remaining {
+		// Keep only the announced length.
+		file.Truncate(offset)
+		file.Close()
+		hand.serveStatus(w, r, http.StatusRequestEntityTooLarge)
+		return
+	} else if available >= 0 && n > available || hand.reservePart(base, offset, n) != nil {
+		file.Truncate(offset)
+		file.Close()
+		hand.serveStatus(w, r, http.StatusInsufficientStorage)
+		return
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// The written part is kept, the client gets the offset with HEAD.
+		hand.serveFSError(w, r, err)
+		return
+	}
+
+	offset += n
+	if offset == info.Length {
+		if err := hand.complete(p, base, info.Name); err != nil {
+			hand.serveFSError(w, r, err)
+			return
+		}
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	hand.serveStatus(w, r, http.StatusNoContent)
+}
+
+// Link the complete resumable upload to its name, and remove its files.
+func (hand *uploadHandler) complete(p, base, name string) error {
+	final, err := uploadLink(base+".part", filepath.Dir(base), name)
+	if err != nil {
+		return err
+	}
+	os.Remove(base + ".part")
+	os.Remove(base + ".info")
+	hand.releasePart(base, false)
+	hand.Logger.Info("upload", "file", path.Join(p, final))
+	return nil
+}
+
+// Test if the name is an incomplete file of an upload.
+func uploadTemp(name string) bool {
+	return strings.HasPrefix(name, uploadTempPrefix)
+}
+
+// Remove the expired resumable uploads all uploadCleanInterval.
+func (hand *uploadHandler) run() {
+	for range time.Tick(uploadCleanInterval) {
+		hand.cleanAll()
+	}
+}
+
+// Remove the expired resumable uploads of all the directories.
+func (hand *uploadHandler) cleanAll() {
+	filepath.WalkDir(hand.root, func(full string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.IsDir() {
+			hand.clean(full)
+		}
+		return nil
+	})
+}
+
+// Remove the expired resumable uploads of dir.
+func (hand *uploadHandler) clean(dir string) {
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !uploadTemp(name) || !strings.HasSuffix(name, ".part") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > UploadExpiration {
+			base := filepath.Join(dir, strings.TrimSuffix(name, ".part"))
+			if os.Remove(base+".part") == nil {
+				hand.releasePart(base, true)
+			}
+			os.Remove(base + ".info")
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadName(t *testing.T) {
+	assert.Equal(t, "a.txt", uploadName("a.txt"))
+	assert.Equal(t, "evil.sh", uploadName("../../bin/evil.sh"))
+	assert.Equal(t, "report.pdf", uploadName(`C:\Users\me\report.pdf`))
+	assert.Equal(t, "htaccess", uploadName(".htaccess"))
+	assert.Equal(t, "ab.txt", uploadName("a\x00<b>.txt\n"))
+	assert.Equal(t, "été 2024.txt", uploadName(" été 2024.txt. "))
+	assert.Equal(t, "", uploadName(".."))
+	assert.Equal(t, "", uploadName("dir/"))
+	assert.Equal(t, strings.Repeat("é", 127), uploadName(strings.Repeat("é", 200)))
+}
+
+func testUploadMultipart(files ...string) (body *bytes.Buffer, contentType string) {
+	body = &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	mw.WriteField("comment", "ignored")
+	for i := 0; i+1 < len(files); i += 2 {
+		w, _ := mw.CreateFormFile("file", files[i])
+		io.WriteString(w, files[i+1])
+	}
+	mw.Close()
+	return body, mw.FormDataContentType()
+}
+
+func TestUpload(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "dir"), 0o755)
+	logger, _ := testLoggerOne()
+	hand := Upload(logger, root, "", Options{Anonymous: true, UploadForm: true, UploadMax: 10, Deny: []string{"*.exe"}})
+	serve := func(method, url string, body io.Reader, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "http://example.com"+url, body)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, r)
+		return w
+	}
+	get := func(url string) string { return serve("GET", url, nil).Body.String() }
+
+	assert.Contains(t, get("/dir/"), `<form id=u method=post enctype=multipart/form-data>`)
+
+	// Multipart
+	body, contentType := testUploadMultipart("a.txt", "a1", "../b.txt", "b")
+	w := serve("POST", "/dir/", body, "Content-Type", contentType)
+	assert.Equal(t, 303, w.Code)
+	assert.Equal(t, "/dir/", w.Header().Get("Location"))
+	assert.Equal(t, "a1", get("/dir/a.txt"))
+	assert.Equal(t, "b", get("/dir/b.txt"))
+	body, contentType = testUploadMultipart("a.txt", "a2")
+	assert.Equal(t, 303, serve("POST", "/dir", body, "Content-Type", contentType).Code)
+	assert.Equal(t, "a1", get("/dir/a.txt"))
+	assert.Equal(t, "a2", get("/dir/a-1.txt"))
+	body, contentType = testUploadMultipart("big.txt", "01234567890")
+	assert.Equal(t, 413, serve("POST", "/dir/", body, "Content-Type", contentType).Code)
+	body, contentType = testUploadMultipart("x.exe", "x")
+	assert.Equal(t, 404, serve("POST", "/dir/", body, "Content-Type", contentType).Code)
+	body, contentType = testUploadMultipart()
+	assert.Equal(t, 400, serve("POST", "/dir/", body, "Content-Type", contentType).Code)
+	body, contentType = testUploadMultipart("a.txt", "a")
+	assert.Equal(t, 404, serve("POST", "/missing/", body, "Content-Type", contentType).Code)
+	assert.Equal(t, 415, serve("POST", "/dir/", strings.NewReader("a")).Code)
+
+	// PUT
+	w = serve("PUT", "/dir/c%20d.txt", strings.NewReader("c"))
+	assert.Equal(t, 201, w.Code)
+	assert.Equal(t, "/dir/c%20d.txt", w.Header().Get("Location"))
+	assert.Equal(t, "/dir/c%20d-1.txt", serve("PUT", "/dir/c%20d.txt", strings.NewReader("c")).Header().Get("Location"))
+	assert.Equal(t, "/dir/ab.txt", serve("PUT", "/dir/a%3Cb%3E.txt", strings.NewReader("c")).Header().Get("Location"))
+	assert.Equal(t, 404, serve("PUT", "/..txt", strings.NewReader("c")).Code)
+	assert.Equal(t, 413, serve("PUT", "/dir/big.txt", strings.NewReader("01234567890")).Code)
+	assert.Equal(t, 413, serve("PUT", "/dir/big.txt", io.MultiReader(strings.NewReader("012345"), strings.NewReader("67890"))).Code)
+	assert.Equal(t, 400, serve("PUT", "/dir/", strings.NewReader("c")).Code)
+	assert.Equal(t, 404, serve("PUT", "/missing/c.txt", strings.NewReader("c")).Code)
+	assert.Equal(t, 404, serve("PUT", "/dir/.env", strings.NewReader("c")).Code)
+	assert.Equal(t, 405, serve("DELETE", "/dir/a.txt", nil).Code)
+
+	entries, _ := os.ReadDir(filepath.Join(root, "dir"))
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"a-1.txt", "a.txt", "ab.txt", "b.txt", "c d-1.txt", "c d.txt"}, names, "no temporary file")
+}
+
+func TestUploadResumable(t *testing.T) {
+	root := t.TempDir()
+	logger, _ := testLoggerOne()
+	hand := Upload(logger, root, "", Options{Anonymous: true, UploadMax: 10})
+	serve := func(method, url, body string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "http://example.com"+url, strings.NewReader(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("OPTIONS", "/", "")
+	assert.Equal(t, 204, w.Code)
+	assert.Equal(t, "1.0.0", w.Header().Get("Tus-Version"))
+	assert.Equal(t, "10", w.Header().Get("Tus-Max-Size"))
+
+	// "YS50eHQ=" is "a.txt"
+	assert.Equal(t, 413, serve("POST", "/", "", "Upload-Length", "11", "Upload-Metadata", "filename YS50eHQ=").Code)
+	assert.Equal(t, 400, serve("POST", "/", "", "Upload-Length", "8").Code)
+	w = serve("POST", "/", "", "Upload-Length", "8", "Upload-Metadata", "type dGV4dA==,filename YS50eHQ=")
+	assert.Equal(t, 201, w.Code)
+	assert.Equal(t, "1.0.0", w.Header().Get("Tus-Resumable"))
+	upload := strings.TrimPrefix(w.Header().Get("Location"), "http://example.com")
+	assert.Regexp(t, `^/\?upload=[0-9a-f]{32}$`, upload)
+
+	w = serve("HEAD", upload, "")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "0", w.Header().Get("Upload-Offset"))
+	assert.Equal(t, "8", w.Header().Get("Upload-Length"))
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	patch := func(offset, body string) *httptest.ResponseRecorder {
+		return serve("PATCH", upload, body, "Upload-Offset", offset, "Content-Type", "application/offset+octet-stream")
+	}
+	assert.Equal(t, 415, serve("PATCH", upload, "abc", "Upload-Offset", "0").Code)
+	w = patch("0", "abc")
+	assert.Equal(t, 204, w.Code)
+	assert.Equal(t, "3", w.Header().Get("Upload-Offset"))
+	w = patch("0", "abc")
+	assert.Equal(t, 409, w.Code)
+	assert.Equal(t, "3", w.Header().Get("Upload-Offset"))
+	assert.Equal(t, 413, patch("3", "defghijk").Code)
+	assert.Equal(t, "3", serve("HEAD", upload, "").Header().Get("Upload-Offset"))
+	assert.Equal(t, 404, serve("GET", "/a.txt", "").Code)
+	assert.NotContains(t, serve("GET", "/", "").Body.String(), "upload")
+
+	w = patch("3", "defgh")
+	assert.Equal(t, 204, w.Code)
+	assert.Equal(t, "8", w.Header().Get("Upload-Offset"))
+	assert.Equal(t, "abcdefgh", serve("GET", "/a.txt", "").Body.String())
+	assert.Equal(t, 404, serve("HEAD", upload, "").Code)
+	assert.Equal(t, 404, patch("8", "").Code)
+	assert.Equal(t, 404, serve("HEAD", "/?upload=../../x", "").Code)
+
+	// Empty file
+	w = serve("POST", "/", "", "Upload-Length", "0", "Upload-Metadata", "filename YS50eHQ=")
+	assert.Equal(t, 201, w.Code)
+	assert.Equal(t, "", serve("GET", "/a-1.txt", "").Body.String())
+
+	// Expiration, in all the directories.
+	os.Mkdir(filepath.Join(root, "sub"), 0o755)
+	w = serve("POST", "/", "", "Upload-Length", "1", "Upload-Metadata", "filename YS50eHQ=")
+	wSub := serve("POST", "/sub/", "", "Upload-Length", "1", "Upload-Metadata", "filename YS50eHQ=")
+	old := time.Now().Add(-UploadExpiration - time.Minute)
+	parts, _ := filepath.Glob(filepath.Join(root, "*", ".upload-*.part"))
+	rootParts, _ := filepath.Glob(filepath.Join(root, ".upload-*.part"))
+	parts = append(parts, rootParts...)
+	assert.Len(t, parts, 2)
+	for _, part := range parts {
+		os.Chtimes(part, old, old)
+	}
+	serve("POST", "/", "", "Upload-Length", "1", "Upload-Metadata", "filename YS50eHQ=")
+	hand.(*uploadHandler).cleanAll()
+	assert.Equal(t, 404, serve("HEAD", strings.TrimPrefix(w.Header().Get("Location"), "http://example.com"), "").Code)
+	assert.Equal(t, 404, serve("HEAD", strings.TrimPrefix(wSub.Header().Get("Location"), "http://example.com"), "").Code)
+	parts, _ = filepath.Glob(filepath.Join(root, ".upload-*"))
+	assert.Len(t, parts, 2)
+	parts, _ = filepath.Glob(filepath.Join(root, "sub", ".upload-*"))
+	assert.Empty(t, parts)
+}
+
+func TestUploadUsers(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0o644)
+	logger, _ := testLoggerOne()
+	hand := Upload(logger, root, "", Options{Users: testWebDAVUsers()})
+
+	w := httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/a.txt", nil))
+	assert.Equal(t, "a", w.Body.String())
+	assert.NotContains(t, w.Body.String(), "<form")
+
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("PUT", "http://example.com/b.txt", strings.NewReader("b")))
+	assert.Equal(t, 401, w.Code)
+	assert.Equal(t, `Basic realm="servHTTP", charset="UTF-8"`, w.Header().Get("WWW-Authenticate"))
+
+	r := httptest.NewRequest("PUT", "http://example.com/b.txt", strings.NewReader("b"))
+	r.SetBasicAuth("alice", "secret")
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, r)
+	assert.Equal(t, 201, w.Code)
+}
+
+func TestUploadAnonymous(t *testing.T) {
+	root := t.TempDir()
+	logger, log := testLoggerOne()
+	hand := Upload(logger, root, "", Options{})
+	assert.Contains(t, log.String(), `msg=upload-no-user`)
+	w := httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("PUT", "http://example.com/a.html", strings.NewReader("<script>")))
+	assert.Equal(t, 401, w.Code)
+
+	hand = Upload(logger, root, "", Options{Anonymous: true})
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("PUT", "http://example.com/a.html", strings.NewReader("<script>")))
+	assert.Equal(t, 201, w.Code)
+
+	// The files are sandboxed, the indexes keep their script in a unique origin.
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/a.html", nil))
+	assert.Equal(t, "<script>", w.Body.String())
+	assert.Equal(t, "sandbox", w.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "sandbox allow-scripts allow-forms", w.Header().Get("Content-Security-Policy"))
+
+	// An uploaded index.html is also sandboxed.
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("PUT", "http://example.com/index.html", strings.NewReader("<script>")))
+	assert.Equal(t, 201, w.Code)
+	assert.Equal(t, "sandbox", w.Header().Get("Content-Security-Policy"))
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+	assert.Equal(t, "<script>", w.Body.String())
+	assert.Equal(t, "sandbox allow-scripts allow-forms", w.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+}
+
+func TestUploadQuota(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("aaaa"), 0o644)
+	logger, _ := testLoggerOne()
+	hand := Upload(logger, root, "", Options{Anonymous: true, Quota: 10})
+	serve := func(method, url string, body io.Reader, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "http://example.com"+url, body)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, 201, serve("PUT", "/b.txt", strings.NewReader("bbb")).Code)
+	assert.Equal(t, 507, serve("PUT", "/c.txt", strings.NewReader("cccc")).Code)
+	assert.Equal(t, 507, serve("PUT", "/c.txt", io.MultiReader(strings.NewReader("cc"), strings.NewReader("cc"))).Code)
+	body, contentType := testUploadMultipart("c.txt", "cccc")
+	assert.Equal(t, 507, serve("POST", "/", body, "Content-Type", contentType).Code)
+
+	// "Yy50eHQ=" is "c.txt"
+	assert.Equal(t, 507, serve("POST", "/", nil, "Upload-Length", "4", "Upload-Metadata", "filename Yy50eHQ=").Code)
+	w := serve("POST", "/", nil, "Upload-Length", "3", "Upload-Metadata", "filename Yy50eHQ=")
+	assert.Equal(t, 201, w.Code)
+	upload := strings.TrimPrefix(w.Header().Get("Location"), "http://example.com")
+	assert.Equal(t, 201, serve("PUT", "/d.txt", strings.NewReader("dd")).Code)
+	assert.Equal(t, 507, serve("PATCH", upload, strings.NewReader("ccc"), "Upload-Offset", "0", "Content-Type", "application/offset+octet-stream").Code)
+	assert.Equal(t, "0", serve("HEAD", upload, nil).Header().Get("Upload-Offset"))
+
+	entries, _ := os.ReadDir(root)
+	assert.Len(t, entries, 5, "a.txt, b.txt, d.txt and the resumable upload")
+}
+
+func TestUploadQuotaRestart(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("aaaa"), 0o644)
+	logger, _ := testLoggerOne()
+	newServe := func() func(method, url string, body string, header ...string) *httptest.ResponseRecorder {
+		hand := Upload(logger, root, "", Options{Anonymous: true, Quota: 10})
+		return func(method, url string, body string, header ...string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(method, "http://example.com"+url, strings.NewReader(body))
+			for i := 0; i+1 < len(header); i += 2 {
+				r.Header.Set(header[i], header[i+1])
+			}
+			w := httptest.NewRecorder()
+			hand.ServeHTTP(w, r)
+			return w
+		}
+	}
+	serve := newServe()
+	// "Yy50eHQ=" is "c.txt"
+	w := serve("POST", "/", "", "Upload-Length", "4", "Upload-Metadata", "filename Yy50eHQ=")
+	assert.Equal(t, 201, w.Code)
+	upload := strings.TrimPrefix(w.Header().Get("Location"), "http://example.com")
+	assert.Equal(t, 204, serve("PATCH", upload, "cc", "Upload-Offset", "0", "Content-Type", "application/offset+octet-stream").Code)
+
+	// The part is not walked, its bytes are counted with the next PATCH.
+	serve = newServe()
+	assert.Equal(t, 507, serve("PUT", "/b.txt", "bbbbbbb").Code)
+	assert.Equal(t, 204, serve("PATCH", upload, "cc", "Upload-Offset", "2", "Content-Type", "application/offset+octet-stream").Code)
+	assert.Equal(t, "cccc", serve("GET", "/c.txt", "").Body.String())
+	assert.Equal(t, 507, serve("PUT", "/b.txt", "bbb").Code)
+	assert.Equal(t, 201, serve("PUT", "/b.txt", "bb").Code)
+}
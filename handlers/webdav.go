@@ -0,0 +1,537 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Serve and modify the files of root with WebDAV (RFC 4918): the methods of
+// the file handler (GET, HEAD) and OPTIONS, PROPFIND, PROPPATCH, MKCOL, PUT,
+// DELETE, COPY, MOVE, LOCK and UNLOCK.
+// All the requests need the basic authentication of a user of the option
+// Users. With ReadOnly, the modifications are forbidden; with Quota, the
+// total size of the files is limited (507 Insufficient Storage). The size is
+// walked once, then followed with the modifications of this handler.
+//
+// The symbolic links are never followed, and the denied names (Deny and the
+// dotfiles) can be neither read nor written. The properties are read-only,
+// the locks are exclusive and kept in memory. The cache handlers serving
+// the root are updated after each modification.
+func WebDAV(logger *slog.Logger, root, cacheControl string, opt Options) http.Handler {
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	if opt.ETag == "" {
+		opt.ETag = ETagMeta
+	}
+	users := newBasicUsers(logger, opt.Users)
+	if len(users.hashes) == 0 {
+		logger.Warn("webdav-no-user")
+	}
+	return &webdavHandler{
+		fileHandler: FileFS(logger, rootFS(root, SymlinkDeny), cacheControl, opt).(*fileHandler),
+		root:        root,
+		users:       users,
+		readOnly:    opt.ReadOnly,
+		quota:       opt.Quota,
+		locks:       webdavLocks{locks: make(map[string]*webdavLock)},
+	}
+}
+
+type webdavHandler struct {
+	// Serve GET and HEAD requests.
+	*fileHandler
+	root     string
+	users    *basicUsers
+	readOnly bool
+	// The maximal total size of the files, 0 for no limit.
+	quota int64
+
+	// Serialize the modifications, for the quota and the locks.
+	// The PUT bodies are written before the lock.
+	writeMutex sync.Mutex
+	locks      webdavLocks
+	// The total size of the files if usageKnown, for the quota.
+	usage      int64
+	usageKnown bool
+}
+
+func (hand *webdavHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !hand.users.authorized(r) {
+		hand.serveUnauthorized(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "GET", "HEAD":
+		hand.fileHandler.ServeHTTP(w, r)
+		return
+	case "OPTIONS":
+		hand.serveOptions(w, r)
+		return
+	}
+
+	p := path.Clean("/" + r.URL.Path)
+	if hand.deny.denied(r.URL.Path) {
+		hand.serveStatus(w, r, hand.deny.statusCode())
+		return
+	}
+	full, err := noSymlinkPath(hand.root, p)
+	if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+
+	if r.Method == "PROPFIND" {
+		hand.servePropfind(w, r, p, full)
+		return
+	}
+
+	switch r.Method {
+	case "PROPPATCH", "MKCOL", "PUT", "DELETE", "COPY", "MOVE", "LOCK", "UNLOCK":
+	default:
+		hand.serveStatus(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	if hand.readOnly || webdavTemp(path.Base(p)) {
+		hand.serveStatus(w, r, http.StatusForbidden)
+		return
+	} else if r.Method == "PUT" {
+		hand.servePut(w, r, p, full)
+		return
+	}
+
+	hand.writeMutex.Lock()
+	defer hand.writeMutex.Unlock()
+	switch r.Method {
+	case "PROPPATCH":
+		hand.serveProppatch(w, r, p, full)
+	case "MKCOL":
+		hand.serveMkcol(w, r, p, full)
+	case "DELETE":
+		hand.serveDelete(w, r, p, full)
+	case "COPY", "MOVE":
+		hand.serveCopy(w, r, p, full)
+	case "LOCK":
+		hand.serveLock(w, r, p, full)
+	case "UNLOCK":
+		hand.serveUnlock(w, r, p)
+	}
+}
+
+// Get the total size of the files, walked on the first call.
+// Called with writeMutex.
+func (hand *webdavHandler) used() (int64, error) {
+	if !hand.usageKnown {
+		size, err := webdavSize(hand.root)
+		if err != nil {
+			return 0, err
+		}
+		hand.usage, hand.usageKnown = size, true
+	}
+	return hand.usage, nil
+}
+
+// Add delta to the total size if no error, else walk it again on the next
+// call of used. Called with writeMutex.
+func (hand *webdavHandler) addUsage(delta int64, err error) {
+	if err != nil {
+		hand.usageKnown = false
+	} else {
+		hand.usage += delta
+	}
+}
+
+// The names of the temporary files of the PUT requests, the clients can not
+// write them.
+const webdavTempPattern = ".webdav-*.tmp"
+
+func webdavTemp(name string) bool {
+	return strings.HasPrefix(name, ".webdav-") && strings.HasSuffix(name, ".tmp")
+}
+
+// Test if the parent directory of full exists.
+func webdavParentExists(full string) bool {
+	info, err := os.Stat(filepath.Dir(full))
+	return err == nil && info.IsDir()
+}
+
+func (hand *webdavHandler) serveOptions(w http.ResponseWriter, r *http.Request) {
+	allow := "OPTIONS, GET, HEAD, PROPFIND"
+	if !hand.readOnly {
+		allow += ", PROPPATCH, MKCOL, PUT, DELETE, COPY, MOVE, LOCK, UNLOCK"
+	}
+	w.Header().Set("Allow", allow)
+	w.Header().Set("DAV", "1, 2")
+	w.Header().Set("MS-Author-Via", "DAV")
+	w.Header().Set(headerContentLength, "0")
+	hand.serveStatus(w, r, http.StatusOK)
+}
+
+func (hand *webdavHandler) serveMkcol(w http.ResponseWriter, r *http.Request, p, full string) {
+	if r.ContentLength > 0 || len(r.TransferEncoding) > 0 {
+		hand.serveStatus(w, r, http.StatusUnsupportedMediaType)
+		return
+	} else if !webdavParentExists(full) {
+		hand.serveStatus(w, r, http.StatusConflict)
+		return
+	} else if !hand.locks.allowed(r, p, false) {
+		hand.serveStatus(w, r, http.StatusLocked)
+		return
+	}
+
+	if err := os.Mkdir(full, 0o755); errors.Is(err, fs.ErrExist) {
+		hand.serveStatus(w, r, http.StatusMethodNotAllowed)
+		return
+	} else if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+	cacheInvalidate(filepath.Dir(full), false)
+	hand.serveStatus(w, r, http.StatusCreated)
+}
+
+// Serve a PUT request: the body is written in a temporary file, then the
+// checks are done again under writeMutex to rename it.
+func (hand *webdavHandler) servePut(w http.ResponseWriter, r *http.Request, p, full string) {
+	if _, _, ok := hand.checkPut(w, r, p, full); !ok {
+		return
+	}
+
+	body := io.Reader(r.Body)
+	available := int64(-1)
+	if hand.quota > 0 {
+		hand.writeMutex.Lock()
+		used, err := hand.used()
+		hand.writeMutex.Unlock()
+		if err != nil {
+			hand.serveFSError(w, r, err)
+			return
+		}
+		// The replaced file is counted later.
+		available = hand.quota - used
+		if info, err := os.Stat(full); err == nil {
+			available += info.Size()
+		}
+		if r.ContentLength > available {
+			hand.serveStatus(w, r, http.StatusInsufficientStorage)
+			return
+		}
+		body = io.LimitReader(r.Body, available+1)
+	}
+
+	// Write a temporary file in the same directory, then rename it.
+	tmp, err := os.CreateTemp(filepath.Dir(full), webdavTempPattern)
+	if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	} else if available >= 0 && n > available {
+		hand.serveStatus(w, r, http.StatusInsufficientStorage)
+		return
+	}
+
+	hand.writeMutex.Lock()
+	defer hand.writeMutex.Unlock()
+	info, exists, ok := hand.checkPut(w, r, p, full)
+	if !ok {
+		return
+	}
+	var oldSize int64
+	if exists {
+		oldSize = info.Size()
+	}
+	if hand.quota > 0 {
+		used, err := hand.used()
+		if err != nil {
+			hand.serveFSError(w, r, err)
+			return
+		} else if used-oldSize+n > hand.quota {
+			hand.serveStatus(w, r, http.StatusInsufficientStorage)
+			return
+		}
+	}
+
+	mode := fs.FileMode(0o644)
+	if exists {
+		mode = info.Mode().Perm()
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	} else if err := os.Rename(tmp.Name(), full); err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+	hand.addUsage(n-oldSize, nil)
+	cacheInvalidate(filepath.Dir(full), false)
+
+	if exists {
+		hand.serveStatus(w, r, http.StatusNoContent)
+	} else {
+		hand.serveStatus(w, r, http.StatusCreated)
+	}
+}
+
+// Check the target, the preconditions and the locks of a PUT request,
+// or serve the error and return false.
+func (hand *webdavHandler) checkPut(w http.ResponseWriter, r *http.Request, p, full string) (info fs.FileInfo, exists, ok bool) {
+	info, err := os.Stat(full)
+	exists = err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		hand.serveFSError(w, r, err)
+		return nil, false, false
+	} else if exists && info.IsDir() || strings.HasSuffix(r.URL.Path, "/") {
+		hand.serveStatus(w, r, http.StatusMethodNotAllowed)
+		return nil, false, false
+	} else if !webdavParentExists(full) {
+		hand.serveStatus(w, r, http.StatusConflict)
+		return nil, false, false
+	}
+
+	if match := r.Header.Get("If-Match"); match != "" && (!exists || match != "*" && !strings.Contains(match, hand.fileETag(p, full, info))) {
+		hand.serveStatus(w, r, http.StatusPreconditionFailed)
+		return nil, false, false
+	} else if exists && r.Header.Get("If-None-Match") == "*" {
+		hand.serveStatus(w, r, http.StatusPreconditionFailed)
+		return nil, false, false
+	} else if !hand.locks.allowed(r, p, false) {
+		hand.serveStatus(w, r, http.StatusLocked)
+		return nil, false, false
+	}
+	return info, exists, true
+}
+
+func (hand *webdavHandler) serveDelete(w http.ResponseWriter, r *http.Request, p, full string) {
+	info, err := os.Lstat(full)
+	if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	} else if p == "/" {
+		hand.serveStatus(w, r, http.StatusForbidden)
+		return
+	} else if !hand.locks.allowed(r, p, true) {
+		hand.serveStatus(w, r, http.StatusLocked)
+		return
+	}
+
+	var size int64
+	if hand.quota > 0 && hand.usageKnown {
+		if size, err = webdavSize(full); err != nil {
+			hand.usageKnown = false
+		}
+	}
+	err = os.RemoveAll(full)
+	hand.addUsage(-size, err)
+	if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+	hand.locks.remove(p)
+	cacheInvalidate(filepath.Dir(full), info.IsDir())
+	hand.serveStatus(w, r, http.StatusNoContent)
+}
+
+// Serve COPY and MOVE requests.
+func (hand *webdavHandler) serveCopy(w http.ResponseWriter, r *http.Request, p, full string) {
+	move := r.Method == "MOVE"
+	destination, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || destination.Path == "" {
+		hand.serveStatus(w, r, http.StatusBadRequest)
+		return
+	} else if destination.Host != "" && destination.Host != r.Host {
+		hand.serveStatus(w, r, http.StatusBadGateway)
+		return
+	}
+	dest := path.Clean("/" + destination.Path)
+	if hand.deny.denied(destination.Path) {
+		hand.serveStatus(w, r, hand.deny.statusCode())
+		return
+	}
+	destFull, err := noSymlinkPath(hand.root, dest)
+	if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+
+	info, err := os.Lstat(full)
+	if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+	recursive := true
+	switch r.Header.Get("Depth") {
+	case "", "infinity":
+	case "0":
+		recursive = !info.IsDir()
+	default:
+		hand.serveStatus(w, r, http.StatusBadRequest)
+		return
+	}
+	if move && !recursive {
+		hand.serveStatus(w, r, http.StatusBadRequest)
+		return
+	} else if p == dest || p == "/" && move || dest == "/" || strings.HasPrefix(dest, strings.TrimSuffix(p, "/")+"/") || webdavTemp(path.Base(dest)) {
+		hand.serveStatus(w, r, http.StatusForbidden)
+		return
+	} else if !webdavParentExists(destFull) {
+		hand.serveStatus(w, r, http.StatusConflict)
+		return
+	}
+
+	destInfo, err := os.Lstat(destFull)
+	exists := err == nil
+	if exists && r.Header.Get("Overwrite") == "F" {
+		hand.serveStatus(w, r, http.StatusPreconditionFailed)
+		return
+	} else if move && !hand.locks.allowed(r, p, true) || !hand.locks.allowed(r, dest, true) {
+		hand.serveStatus(w, r, http.StatusLocked)
+		return
+	}
+
+	// The size of the replaced files, and of the copied files.
+	var destSize, size int64
+	if hand.quota > 0 {
+		used, err := hand.used()
+		if err == nil && exists {
+			destSize, err = webdavSize(destFull)
+		}
+		if err == nil && !move && recursive {
+			size, err = webdavSize(full)
+		}
+		if err != nil {
+			hand.serveFSError(w, r, err)
+			return
+		} else if !move && used-destSize+size > hand.quota {
+			hand.serveStatus(w, r, http.StatusInsufficientStorage)
+			return
+		}
+	}
+
+	if exists {
+		err := os.RemoveAll(destFull)
+		hand.addUsage(-destSize, err)
+		if err != nil {
+			hand.serveFSError(w, r, err)
+			return
+		}
+		hand.locks.remove(dest)
+	}
+	if move {
+		err = os.Rename(full, destFull)
+		hand.locks.remove(p)
+		cacheInvalidate(filepath.Dir(full), info.IsDir())
+	} else {
+		err = webdavCopy(full, destFull, recursive)
+		hand.addUsage(size, err)
+	}
+	cacheInvalidate(filepath.Dir(destFull), info.IsDir() || exists && destInfo.IsDir())
+	if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+
+	if exists {
+		hand.serveStatus(w, r, http.StatusNoContent)
+	} else {
+		hand.serveStatus(w, r, http.StatusCreated)
+	}
+}
+
+// Copy the directory (with its content if recursive) or the regular file
+// src to dst. The other files, like the symbolic links, are skipped.
+func webdavCopy(src, dst string, recursive bool) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		if err := os.Mkdir(dst, info.Mode().Perm()); err != nil || !recursive {
+			return err
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := webdavCopy(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name()), true); err != nil {
+				return err
+			}
+		}
+		return nil
+	} else if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Get the total size of the regular files in full, without the temporary
+// files of the PUT requests.
+func webdavSize(full string) (int64, error) {
+	return dirSize(full, webdavTemp)
+}
+
+// Get the total size of the regular files in full, without the files
+// matched by temp.
+func dirSize(full string, temp func(name string) bool) (size int64, err error) {
+	err = filepath.WalkDir(full, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if entry.Type().IsRegular() && !temp(entry.Name()) {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+// Get the ETag of the file of the URL path p, like the GET responses.
+func (hand *webdavHandler) fileETag(p, full string, info fs.FileInfo) string {
+	if hand.etagMode != ETagHash {
+		etag, _ := hand.etag(p, nil, info)
+		return etag
+	}
+	file, err := os.Open(full)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	etag, _ := hand.etag(p, file, info)
+	return etag
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The maximal and default timeout of the WebDAV locks.
+var WebDAVLockTimeout = time.Hour
+
+// An exclusive write lock.
+type webdavLock struct {
+	token string
+	// The locked URL path, cleaned.
+	path string
+	// Lock also the sub resources.
+	infinite bool
+	// The raw XML owner given by the client.
+	owner   string
+	timeout time.Duration
+	expires time.Time
+}
+
+// The locks by token.
+type webdavLocks struct {
+	mutex sync.Mutex
+	locks map[string]*webdavLock
+}
+
+// Test if the lock is on p.
+func (lock *webdavLock) covers(p string) bool {
+	return lock.path == p || lock.infinite && strings.HasPrefix(p, strings.TrimSuffix(lock.path, "/")+"/")
+}
+
+// Get the active locks on p; with recursive, also the locks on the sub
+// resources of p.
+func (locks *webdavLocks) find(p string, recursive bool) (found []*webdavLock) {
+	locks.mutex.Lock()
+	defer locks.mutex.Unlock()
+	now := time.Now()
+	for token, lock := range locks.locks {
+		if now.After(lock.expires) {
+			delete(locks.locks, token)
+		} else if lock.covers(p) || recursive && strings.HasPrefix(lock.path, strings.TrimSuffix(p, "/")+"/") {
+			found = append(found, lock)
+		}
+	}
+	return
+}
+
+// Test if the If header of the request contains the tokens of the locks on p.
+func (locks *webdavLocks) allowed(r *http.Request, p string, recursive bool) bool {
+	header := r.Header.Get("If")
+	for _, lock := range locks.find(p, recursive) {
+		if !strings.Contains(header, "<"+lock.token+">") {
+			return false
+		}
+	}
+	return true
+}
+
+// Remove the locks on p and its sub resources, after a deletion.
+func (locks *webdavLocks) remove(p string) {
+	locks.mutex.Lock()
+	defer locks.mutex.Unlock()
+	for token, lock := range locks.locks {
+		if lock.path == p || strings.HasPrefix(lock.path, strings.TrimSuffix(p, "/")+"/") {
+			delete(locks.locks, token)
+		}
+	}
+}
+
+func (locks *webdavLocks) create(p string, infinite bool, owner string, timeout time.Duration) *webdavLock {
+	id := [16]byte{}
+	rand.Read(id[:])
+	id[6] = id[6]&0x0F | 0x40
+	id[8] = id[8]&0x3F | 0x80
+	h := hex.EncodeToString(id[:])
+
+	lock := &webdavLock{
+		token:    "urn:uuid:" + h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:],
+		path:     p,
+		infinite: infinite,
+		owner:    owner,
+		timeout:  timeout,
+		expires:  time.Now().Add(timeout),
+	}
+	locks.mutex.Lock()
+	defer locks.mutex.Unlock()
+	locks.locks[lock.token] = lock
+	return lock
+}
+
+// Refresh the lock on p from the If header, nil if not found.
+// The lock is replaced by a copy, so the found locks are not modified.
+func (locks *webdavLocks) refresh(header, p string, timeout time.Duration) *webdavLock {
+	for _, lock := range locks.find(p, false) {
+		if strings.Contains(header, "<"+lock.token+">") {
+			refreshed := *lock
+			refreshed.timeout = timeout
+			refreshed.expires = time.Now().Add(timeout)
+			locks.mutex.Lock()
+			defer locks.mutex.Unlock()
+			locks.locks[lock.token] = &refreshed
+			return &refreshed
+		}
+	}
+	return nil
+}
+
+// Remove the lock of the token on p, return false if not found.
+func (locks *webdavLocks) unlock(token, p string) bool {
+	locks.mutex.Lock()
+	defer locks.mutex.Unlock()
+	lock := locks.locks[token]
+	if lock == nil || !lock.covers(p) || time.Now().After(lock.expires) {
+		return false
+	}
+	delete(locks.locks, token)
+	return true
+}
+
+// Write the activelock element.
+func (lock *webdavLock) write(buff *bytes.Buffer) {
+	depth := "0"
+	if lock.infinite {
+		depth = "infinity"
+	}
+	buff.WriteString(`<D:activelock><D:locktype><D:write/></D:locktype><D:lockscope><D:exclusive/></D:lockscope>`)
+	buff.WriteString(`<D:depth>` + depth + `</D:depth>`)
+	if lock.owner != "" {
+		buff.WriteString(`<D:owner>` + lock.owner + `</D:owner>`)
+	}
+	buff.WriteString(`<D:timeout>Second-` + strconv.Itoa(int(lock.timeout/time.Second)) + `</D:timeout>`)
+	buff.WriteString(`<D:locktoken><D:href>` + lock.token + `</D:href></D:locktoken>`)
+	buff.WriteString(`<D:lockroot><D:href>` + webdavEscape((&url.URL{Path: lock.path}).EscapedPath()) + `</D:href></D:lockroot>`)
+	buff.WriteString(`</D:activelock>`)
+}
+
+// Get the lock timeout from the Timeout header, like "Second-600".
+func webdavTimeout(header string) time.Duration {
+	for _, value := range strings.Split(header, ",") {
+		seconds, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(value), "Second-"), 10, 64)
+		if err == nil && seconds > 0 && time.Duration(seconds) <= WebDAVLockTimeout/time.Second {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return WebDAVLockTimeout
+}
+
+func (hand *webdavHandler) serveLock(w http.ResponseWriter, r *http.Request, p, full string) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, webdavBodyMax))
+	if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+	timeout := webdavTimeout(r.Header.Get("Timeout"))
+
+	// Refresh a lock.
+	if len(bytes.TrimSpace(body)) == 0 {
+		lock := hand.locks.refresh(r.Header.Get("If"), p, timeout)
+		if lock == nil {
+			hand.serveStatus(w, r, http.StatusPreconditionFailed)
+			return
+		}
+		hand.serveLockDiscovery(w, r, http.StatusOK, lock)
+		return
+	}
+
+	var request struct {
+		Exclusive *struct{} `xml:"DAV: lockscope>exclusive"`
+		Owner     struct {
+			Inner string `xml:",innerxml"`
+		} `xml:"DAV: owner"`
+	}
+	if err := xml.Unmarshal(body, &request); err != nil {
+		hand.serveStatus(w, r, http.StatusBadRequest)
+		return
+	} else if request.Exclusive == nil {
+		// Only the exclusive locks are supported.
+		hand.serveStatus(w, r, http.StatusForbidden)
+		return
+	}
+	infinite := true
+	switch r.Header.Get("Depth") {
+	case "", "infinity":
+	case "0":
+		infinite = false
+	default:
+		hand.serveStatus(w, r, http.StatusBadRequest)
+		return
+	}
+	if len(hand.locks.find(p, infinite)) > 0 {
+		hand.serveStatus(w, r, http.StatusLocked)
+		return
+	}
+
+	// Lock an unmapped URL: create an empty file.
+	status := http.StatusOK
+	if _, err := os.Lstat(full); errors.Is(err, fs.ErrNotExist) {
+		if !webdavParentExists(full) {
+			hand.serveStatus(w, r, http.StatusConflict)
+			return
+		}
+		file, err := os.OpenFile(full, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			hand.serveFSError(w, r, err)
+			return
+		}
+		file.Close()
+		cacheInvalidate(filepath.Dir(full), false)
+		status = http.StatusCreated
+	} else if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+
+	lock := hand.locks.create(p, infinite, strings.TrimSpace(request.Owner.Inner), timeout)
+	w.Header().Set("Lock-Token", "<"+lock.token+">")
+	hand.serveLockDiscovery(w, r, status, lock)
+}
+
+func (hand *webdavHandler) serveLockDiscovery(w http.ResponseWriter, r *http.Request, status int, lock *webdavLock) {
+	buff := bytes.Buffer{}
+	buff.WriteString(webdavXMLHeader + `<D:prop xmlns:D="DAV:"><D:lockdiscovery>`)
+	lock.write(&buff)
+	buff.WriteString(`</D:lockdiscovery></D:prop>`)
+	LogRequest(hand.Logger, status, r)
+	servBody(w, status, webdavMIME, buff.Bytes())
+}
+
+func (hand *webdavHandler) serveUnlock(w http.ResponseWriter, r *http.Request, p string) {
+	token := strings.Trim(r.Header.Get("Lock-Token"), "<> ")
+	if !hand.locks.unlock(token, p) {
+		hand.serveStatus(w, r, http.StatusConflict)
+		return
+	}
+	hand.serveStatus(w, r, http.StatusNoContent)
+}
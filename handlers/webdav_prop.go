@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	webdavNS        = "DAV:"
+	webdavMIME      = "application/xml; charset=utf-8"
+	webdavXMLHeader = `<?xml version="1.0" encoding="utf-8"?>` + "\n"
+	webdavMulti     = webdavXMLHeader + `<D:multistatus xmlns:D="DAV:">`
+)
+
+// The maximal size of the XML request bodies.
+const webdavBodyMax = 64 * 1024
+
+// The properties of allprop, the quota properties are only sent if asked.
+var webdavAllProps = []string{
+	"resourcetype",
+	"displayname",
+	"getcontentlength",
+	"getlastmodified",
+	"getcontenttype",
+	"getetag",
+	"supportedlock",
+	"lockdiscovery",
+}
+
+// A list of property names, in a PROPFIND or PROPPATCH body.
+type webdavPropNames struct {
+	Names []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+func (hand *webdavHandler) servePropfind(w http.ResponseWriter, r *http.Request, p, full string) {
+	var request struct {
+		AllProp  *struct{}        `xml:"DAV: allprop"`
+		PropName *struct{}        `xml:"DAV: propname"`
+		Prop     *webdavPropNames `xml:"DAV: prop"`
+	}
+	if body, err := io.ReadAll(io.LimitReader(r.Body, webdavBodyMax)); err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	} else if len(bytes.TrimSpace(body)) == 0 {
+		request.AllProp = &struct{}{}
+	} else if err := xml.Unmarshal(body, &request); err != nil {
+		hand.serveStatus(w, r, http.StatusBadRequest)
+		return
+	}
+
+	names := []xml.Name{}
+	if request.Prop != nil && request.AllProp == nil {
+		for _, name := range request.Prop.Names {
+			names = append(names, name.XMLName)
+		}
+	} else {
+		for _, name := range webdavAllProps {
+			names = append(names, xml.Name{Space: webdavNS, Local: name})
+		}
+	}
+
+	var depth int
+	switch r.Header.Get("Depth") {
+	case "0":
+	case "1":
+		depth = 1
+	default:
+		LogRequest(hand.Logger, http.StatusForbidden, r)
+		servBody(w, http.StatusForbidden, webdavMIME, []byte(webdavXMLHeader+`<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>`))
+		return
+	}
+
+	info, err := os.Stat(full)
+	if err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	}
+
+	// The quota properties.
+	used := int64(-1)
+	if hand.quota > 0 {
+		for _, name := range names {
+			if name.Space == webdavNS && strings.HasPrefix(name.Local, "quota-") {
+				hand.writeMutex.Lock()
+				used, err = hand.used()
+				hand.writeMutex.Unlock()
+				if err != nil {
+					hand.serveFSError(w, r, err)
+					return
+				}
+				break
+			}
+		}
+	}
+
+	buff := bytes.Buffer{}
+	buff.WriteString(webdavMulti)
+	hand.propResponse(&buff, p, full, info, names, request.PropName != nil, used)
+	if depth == 1 && info.IsDir() {
+		entries, err := os.ReadDir(full)
+		if err != nil {
+			hand.serveFSError(w, r, err)
+			return
+		}
+		for _, entry := range entries {
+			child := path.Join(p, entry.Name())
+			if entry.Type()&fs.ModeSymlink != 0 || hand.deny.denied(child) {
+				continue
+			}
+			childInfo, err := entry.Info()
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			} else if err != nil {
+				hand.serveFSError(w, r, err)
+				return
+			}
+			hand.propResponse(&buff, child, filepath.Join(full, entry.Name()), childInfo, names, request.PropName != nil, used)
+		}
+	}
+	buff.WriteString(`</D:multistatus>`)
+
+	LogRequest(hand.Logger, http.StatusMultiStatus, r)
+	servBody(w, http.StatusMultiStatus, webdavMIME, buff.Bytes())
+}
+
+// Write the response element of a resource. With onlyNames, the properties
+// are empty. The quota properties are missing if used is negative.
+func (hand *webdavHandler) propResponse(buff *bytes.Buffer, p, full string, info fs.FileInfo, names []xml.Name, onlyNames bool, used int64) {
+	href := p
+	if info.IsDir() && !strings.HasSuffix(href, "/") {
+		href += "/"
+	}
+	buff.WriteString(`<D:response><D:href>` + webdavEscape((&url.URL{Path: href}).EscapedPath()) + `</D:href>`)
+
+	found, missing := bytes.Buffer{}, bytes.Buffer{}
+	for _, name := range names {
+		value, ok := hand.prop(name, p, full, info, used)
+		if !ok {
+			missing.WriteString(webdavElement(name, ""))
+		} else if onlyNames {
+			found.WriteString(webdavElement(name, ""))
+		} else {
+			found.WriteString(webdavElement(name, value))
+		}
+	}
+	if found.Len() > 0 {
+		buff.WriteString(`<D:propstat><D:prop>` + found.String() + `</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>`)
+	}
+	if missing.Len() > 0 {
+		buff.WriteString(`<D:propstat><D:prop>` + missing.String() + `</D:prop><D:status>HTTP/1.1 404 Not Found</D:status></D:propstat>`)
+	}
+	buff.WriteString(`</D:response>`)
+}
+
+// Get the raw XML value of a live property, ok is false if it's missing.
+func (hand *webdavHandler) prop(name xml.Name, p, full string, info fs.FileInfo, used int64) (value string, ok bool) {
+	if name.Space != webdavNS {
+		return "", false
+	}
+
+	switch name.Local {
+	case "resourcetype":
+		if info.IsDir() {
+			return `<D:collection/>`, true
+		}
+		return "", true
+	case "displayname":
+		if p == "/" {
+			return "", false
+		}
+		return webdavEscape(path.Base(p)), true
+	case "getcontentlength":
+		return strconv.FormatInt(info.Size(), 10), !info.IsDir()
+	case "getlastmodified":
+		return info.ModTime().UTC().Format(http.TimeFormat), true
+	case "getcontenttype":
+		contentType := mime.TypeByExtension(path.Ext(p))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		return webdavEscape(contentType), !info.IsDir()
+	case "getetag":
+		if info.IsDir() {
+			return "", false
+		}
+		etag := hand.fileETag(p, full, info)
+		return webdavEscape(etag), etag != ""
+	case "supportedlock":
+		return `<D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>`, true
+	case "lockdiscovery":
+		buff := bytes.Buffer{}
+		for _, lock := range hand.locks.find(p, false) {
+			lock.write(&buff)
+		}
+		return buff.String(), true
+	case "quota-used-bytes":
+		return strconv.FormatInt(used, 10), used >= 0
+	case "quota-available-bytes":
+		return strconv.FormatInt(max(hand.quota-used, 0), 10), used >= 0
+	}
+	return "", false
+}
+
+// The properties can not be modified, answer 403 for each property.
+func (hand *webdavHandler) serveProppatch(w http.ResponseWriter, r *http.Request, p, full string) {
+	var request struct {
+		Set []struct {
+			Prop webdavPropNames `xml:"DAV: prop"`
+		} `xml:"DAV: set"`
+		Remove []struct {
+			Prop webdavPropNames `xml:"DAV: prop"`
+		} `xml:"DAV: remove"`
+	}
+	if body, err := io.ReadAll(io.LimitReader(r.Body, webdavBodyMax)); err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	} else if err := xml.Unmarshal(body, &request); err != nil {
+		hand.serveStatus(w, r, http.StatusBadRequest)
+		return
+	} else if _, err := os.Stat(full); err != nil {
+		hand.serveFSError(w, r, err)
+		return
+	} else if !hand.locks.allowed(r, p, false) {
+		hand.serveStatus(w, r, http.StatusLocked)
+		return
+	}
+
+	props := bytes.Buffer{}
+	for _, update := range append(request.Set, request.Remove...) {
+		for _, name := range update.Prop.Names {
+			props.WriteString(webdavElement(name.XMLName, ""))
+		}
+	}
+
+	buff := bytes.Buffer{}
+	buff.WriteString(webdavMulti)
+	buff.WriteString(`<D:response><D:href>` + webdavEscape((&url.URL{Path: p}).EscapedPath()) + `</D:href>`)
+	buff.WriteString(`<D:propstat><D:prop>` + props.String() + `</D:prop><D:status>HTTP/1.1 403 Forbidden</D:status></D:propstat>`)
+	buff.WriteString(`</D:response></D:multistatus>`)
+
+	LogRequest(hand.Logger, http.StatusMultiStatus, r)
+	servBody(w, http.StatusMultiStatus, webdavMIME, buff.Bytes())
+}
+
+// Get the XML element of a property with its raw value.
+func webdavElement(name xml.Name, value string) string {
+	if name.Space == webdavNS {
+		return "<D:" + name.Local + ">" + value + "</D:" + name.Local + ">"
+	} else if name.Space == "" {
+		return "<" + name.Local + ` xmlns="">` + value + "</" + name.Local + ">"
+	}
+	return `<x:` + name.Local + ` xmlns:x="` + webdavEscape(name.Space) + `">` + value + `</x:` + name.Local + `>`
+}
+
+func webdavEscape(s string) string {
+	buff := strings.Builder{}
+	xml.EscapeText(&buff, []byte(s))
+	return buff.String()
+}
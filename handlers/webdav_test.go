@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testWebDAVUsers() map[string]string {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	return map[string]string{"alice": string(hash)}
+}
+
+// Return a function to send an authenticated request to hand,
+// the headers are key value pairs.
+func testWebDAVServe(hand http.Handler) func(method, url, body string, header ...string) *httptest.ResponseRecorder {
+	return func(method, url, body string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "http://example.com"+url, strings.NewReader(body))
+		r.SetBasicAuth("alice", "secret")
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, r)
+		return w
+	}
+}
+
+func TestWebDAV(t *testing.T) {
+	root := t.TempDir()
+	logger, _ := testLoggerOne()
+	hand := WebDAV(logger, root, "", Options{Users: testWebDAVUsers()})
+	serve := testWebDAVServe(hand)
+
+	// Authentication
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	w := httptest.NewRecorder()
+	hand.ServeHTTP(w, r)
+	assert.Equal(t, 401, w.Code)
+	assert.Equal(t, `Basic realm="servHTTP", charset="UTF-8"`, w.Header().Get("WWW-Authenticate"))
+	r.SetBasicAuth("alice", "wrong")
+	w = httptest.NewRecorder()
+	hand.ServeHTTP(w, r)
+	assert.Equal(t, 401, w.Code)
+
+	w = serve("OPTIONS", "/", "")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "1, 2", w.Header().Get("DAV"))
+	assert.Contains(t, w.Header().Get("Allow"), "PUT")
+
+	// PUT and MKCOL
+	assert.Equal(t, 201, serve("PUT", "/a.txt", "a v1").Code)
+	assert.Equal(t, "a v1", serve("GET", "/a.txt", "").Body.String())
+	assert.Equal(t, 412, serve("PUT", "/a.txt", "a v2", "If-None-Match", "*").Code)
+	assert.Equal(t, 412, serve("PUT", "/a.txt", "a v2", "If-Match", `"other"`).Code)
+	assert.Equal(t, 204, serve("PUT", "/a.txt", "a v2", "If-Match", serve("GET", "/a.txt", "").Header().Get("ETag")).Code)
+	assert.Equal(t, "a v2", serve("GET", "/a.txt", "").Body.String())
+	assert.Equal(t, 409, serve("PUT", "/missing/a.txt", "a").Code)
+	assert.Equal(t, 201, serve("MKCOL", "/dir", "").Code)
+	assert.Equal(t, 405, serve("MKCOL", "/dir", "").Code)
+	assert.Equal(t, 409, serve("MKCOL", "/missing/dir", "").Code)
+	assert.Equal(t, 405, serve("PUT", "/dir", "x").Code)
+	assert.Equal(t, 201, serve("PUT", "/dir/b%20c.txt", "b").Code)
+	assert.Equal(t, 404, serve("PUT", "/.hidden", "x").Code)
+	assert.Equal(t, 405, serve("POST", "/a.txt", "").Code)
+	entries, _ := os.ReadDir(root)
+	assert.Len(t, entries, 2, "no temporary file")
+
+	// PROPFIND
+	w = serve("PROPFIND", "/dir/", "", "Depth", "1")
+	assert.Equal(t, 207, w.Code)
+	assert.Equal(t, "application/xml; charset=utf-8", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.Contains(t, body, `<D:response><D:href>/dir/</D:href><D:propstat><D:prop><D:resourcetype><D:collection/></D:resourcetype><D:displayname>dir</D:displayname>`)
+	assert.Contains(t, body, `<D:response><D:href>/dir/b%20c.txt</D:href><D:propstat><D:prop><D:resourcetype></D:resourcetype><D:displayname>b c.txt</D:displayname><D:getcontentlength>1</D:getcontentlength>`)
+	assert.Contains(t, body, `<D:getcontenttype>text/plain; charset=utf-8</D:getcontenttype>`)
+	assert.Contains(t, body, `<D:getetag>`+webdavEscape(serve("GET", "/dir/b%20c.txt", "").Header().Get("ETag"))+`</D:getetag>`)
+	assert.Equal(t, 403, serve("PROPFIND", "/", "").Code)
+	w = serve("PROPFIND", "/a.txt", `<?xml version="1.0"?><propfind xmlns="DAV:" xmlns:x="urn:x"><prop><getcontentlength/><x:color/><quota-used-bytes/></prop></propfind>`, "Depth", "0")
+	assert.Equal(t, `<?xml version="1.0" encoding="utf-8"?>`+"\n"+`<D:multistatus xmlns:D="DAV:"><D:response><D:href>/a.txt</D:href>`+
+		`<D:propstat><D:prop><D:getcontentlength>4</D:getcontentlength></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>`+
+		`<D:propstat><D:prop><x:color xmlns:x="urn:x"></x:color><D:quota-used-bytes></D:quota-used-bytes></D:prop><D:status>HTTP/1.1 404 Not Found</D:status></D:propstat>`+
+		`</D:response></D:multistatus>`, w.Body.String())
+	w = serve("PROPFIND", "/a.txt", `<propfind xmlns="DAV:"><propname/></propfind>`, "Depth", "0")
+	assert.Contains(t, w.Body.String(), `<D:getcontentlength></D:getcontentlength>`)
+	assert.Equal(t, 404, serve("PROPFIND", "/missing", "", "Depth", "0").Code)
+	assert.Equal(t, 400, serve("PROPFIND", "/a.txt", "<propfind", "Depth", "0").Code)
+	w = serve("PROPPATCH", "/a.txt", `<propertyupdate xmlns="DAV:"><set><prop><displayname>A</displayname></prop></set></propertyupdate>`)
+	assert.Equal(t, 207, w.Code)
+	assert.Contains(t, w.Body.String(), `<D:prop><D:displayname></D:displayname></D:prop><D:status>HTTP/1.1 403 Forbidden</D:status>`)
+
+	// COPY and MOVE
+	assert.Equal(t, 201, serve("COPY", "/dir", "", "Destination", "http://example.com/dir2").Code)
+	assert.Equal(t, "b", serve("GET", "/dir2/b%20c.txt", "").Body.String())
+	assert.Equal(t, 412, serve("COPY", "/dir", "", "Destination", "/dir2", "Overwrite", "F").Code)
+	assert.Equal(t, 204, serve("COPY", "/dir", "", "Destination", "/dir2", "Depth", "0").Code)
+	assert.Equal(t, 404, serve("GET", "/dir2/b%20c.txt", "").Code)
+	assert.Equal(t, 403, serve("COPY", "/dir", "", "Destination", "/dir/sub").Code)
+	assert.Equal(t, 409, serve("COPY", "/dir", "", "Destination", "/missing/dir").Code)
+	assert.Equal(t, 502, serve("COPY", "/dir", "", "Destination", "http://example.org/dir3").Code)
+	assert.Equal(t, 201, serve("MOVE", "/a.txt", "", "Destination", "/dir/a.txt").Code)
+	assert.Equal(t, 404, serve("GET", "/a.txt", "").Code)
+	assert.Equal(t, "a v2", serve("GET", "/dir/a.txt", "").Body.String())
+	assert.Equal(t, 400, serve("MOVE", "/dir", "", "Destination", "/dir3", "Depth", "0").Code)
+	assert.Equal(t, 404, serve("MOVE", "/dir", "", "Destination", "/.dir").Code)
+	// The root is never replaced.
+	assert.Equal(t, 403, serve("MOVE", "/dir/a.txt", "", "Destination", "http://example.com/").Code)
+	assert.Equal(t, 403, serve("COPY", "/dir2", "", "Destination", "/", "Overwrite", "T").Code)
+	assert.Equal(t, "a v2", serve("GET", "/dir/a.txt", "").Body.String())
+
+	// DELETE
+	assert.Equal(t, 204, serve("DELETE", "/dir2", "").Code)
+	assert.Equal(t, 404, serve("DELETE", "/dir2", "").Code)
+	assert.Equal(t, 403, serve("DELETE", "/", "").Code)
+
+	// The symbolic links are never followed.
+	outside := t.TempDir()
+	assert.NoError(t, os.Symlink(outside, filepath.Join(root, "link")))
+	assert.Equal(t, 404, serve("PUT", "/link/x.txt", "x").Code)
+	assert.Equal(t, 404, serve("PROPFIND", "/link", "", "Depth", "0").Code)
+	assert.Equal(t, 404, serve("COPY", "/dir", "", "Destination", "/link/dir").Code)
+	assert.NotContains(t, serve("PROPFIND", "/", "", "Depth", "1").Body.String(), "link")
+	entries, _ = os.ReadDir(outside)
+	assert.Empty(t, entries)
+}
+
+func TestWebDAVLock(t *testing.T) {
+	root := t.TempDir()
+	logger, _ := testLoggerOne()
+	serve := testWebDAVServe(WebDAV(logger, root, "", Options{Users: testWebDAVUsers()}))
+	lockBody := `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype><D:owner><D:href>alice</D:href></D:owner></D:lockinfo>`
+
+	// Lock an unmapped URL.
+	w := serve("LOCK", "/f.txt", lockBody, "Timeout", "Second-600")
+	assert.Equal(t, 201, w.Code)
+	token := strings.Trim(w.Header().Get("Lock-Token"), "<>")
+	assert.Regexp(t, regexp.MustCompile(`^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), token)
+	assert.Equal(t, `<?xml version="1.0" encoding="utf-8"?>`+"\n"+`<D:prop xmlns:D="DAV:"><D:lockdiscovery><D:activelock>`+
+		`<D:locktype><D:write/></D:locktype><D:lockscope><D:exclusive/></D:lockscope><D:depth>infinity</D:depth>`+
+		`<D:owner><D:href>alice</D:href></D:owner><D:timeout>Second-600</D:timeout>`+
+		`<D:locktoken><D:href>`+token+`</D:href></D:locktoken><D:lockroot><D:href>/f.txt</D:href></D:lockroot>`+
+		`</D:activelock></D:lockdiscovery></D:prop>`, w.Body.String())
+	assert.Equal(t, "", serve("GET", "/f.txt", "").Body.String())
+
+	assert.Equal(t, 423, serve("LOCK", "/f.txt", lockBody).Code)
+	assert.Equal(t, 423, serve("PUT", "/f.txt", "f").Code)
+	assert.Equal(t, 423, serve("DELETE", "/f.txt", "").Code)
+	assert.Equal(t, 423, serve("MOVE", "/f.txt", "", "Destination", "/g.txt").Code)
+	assert.Equal(t, 204, serve("PUT", "/f.txt", "f", "If", "(<"+token+">)").Code)
+	assert.Contains(t, serve("PROPFIND", "/f.txt", "", "Depth", "0").Body.String(), "<D:locktoken><D:href>"+token+"</D:href></D:locktoken>")
+
+	// Refresh
+	assert.Equal(t, 412, serve("LOCK", "/f.txt", "").Code)
+	w = serve("LOCK", "/f.txt", "", "If", "(<"+token+">)", "Timeout", "Infinite")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "<D:timeout>Second-3600</D:timeout>")
+
+	// Unlock
+	assert.Equal(t, 409, serve("UNLOCK", "/f.txt", "", "Lock-Token", "<urn:uuid:other>").Code)
+	assert.Equal(t, 204, serve("UNLOCK", "/f.txt", "", "Lock-Token", "<"+token+">").Code)
+	assert.Equal(t, 204, serve("PUT", "/f.txt", "f2").Code)
+
+	// Lock a directory
+	assert.Equal(t, 201, serve("MKCOL", "/d", "").Code)
+	assert.Equal(t, 201, serve("PUT", "/d/x.txt", "x").Code)
+	w = serve("LOCK", "/d", lockBody)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 423, serve("PUT", "/d/x.txt", "y").Code)
+	assert.Equal(t, 423, serve("LOCK", "/d/x.txt", lockBody, "Depth", "0").Code)
+	assert.Equal(t, 423, serve("COPY", "/f.txt", "", "Destination", "/d/f.txt").Code)
+	assert.Equal(t, 201, serve("COPY", "/f.txt", "", "Destination", "/d/f.txt", "If", "<http://example.com/d> ("+w.Header().Get("Lock-Token")+")").Code)
+	assert.Equal(t, 204, serve("DELETE", "/d", "", "If", "("+w.Header().Get("Lock-Token")+")").Code)
+	assert.Equal(t, 201, serve("MKCOL", "/d", "").Code, "the locks are removed with the resource")
+
+	assert.Equal(t, 403, serve("LOCK", "/f.txt", `<lockinfo xmlns="DAV:"><lockscope><shared/></lockscope></lockinfo>`).Code)
+}
+
+func TestWebDAVLimits(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("aaaa"), 0o644)
+	logger, log := testLoggerOne()
+	users := testWebDAVUsers()
+	users["bob"] = "plain"
+	serve := testWebDAVServe(WebDAV(logger, root, "", Options{Users: users, ReadOnly: true}))
+	assert.Contains(t, log.String(), `msg=user-invalid user=bob`)
+
+	assert.Equal(t, "aaaa", serve("GET", "/a.txt", "").Body.String())
+	assert.Equal(t, 207, serve("PROPFIND", "/", "", "Depth", "1").Code)
+	assert.Equal(t, "OPTIONS, GET, HEAD, PROPFIND", serve("OPTIONS", "/", "").Header().Get("Allow"))
+	assert.Equal(t, 403, serve("PUT", "/b.txt", "b").Code)
+	assert.Equal(t, 403, serve("DELETE", "/a.txt", "").Code)
+	assert.Equal(t, 403, serve("LOCK", "/a.txt", "").Code)
+
+	serve = testWebDAVServe(WebDAV(logger, root, "", Options{Users: users, Quota: 10}))
+	assert.Equal(t, 201, serve("PUT", "/b.txt", "bbbb").Code)
+	assert.Equal(t, 507, serve("PUT", "/c.txt", "ccc").Code)
+	assert.Equal(t, 204, serve("PUT", "/b.txt", "bbbbbb").Code)
+	assert.Equal(t, 507, serve("COPY", "/a.txt", "", "Destination", "/c.txt").Code)
+	assert.Equal(t, 204, serve("COPY", "/a.txt", "", "Destination", "/b.txt").Code)
+
+	// Without Content-Length
+	r := httptest.NewRequest("PUT", "http://example.com/c.txt", io.MultiReader(strings.NewReader("cc"), strings.NewReader("c")))
+	r.SetBasicAuth("alice", "secret")
+	w := httptest.NewRecorder()
+	WebDAV(logger, root, "", Options{Users: users, Quota: 10}).ServeHTTP(w, r)
+	assert.Equal(t, 507, w.Code)
+	entries, _ := os.ReadDir(root)
+	assert.Len(t, entries, 2)
+
+	w = serve("PROPFIND", "/", `<propfind xmlns="DAV:"><prop><quota-used-bytes/><quota-available-bytes/></prop></propfind>`, "Depth", "0")
+	assert.Contains(t, w.Body.String(), `<D:prop><D:quota-used-bytes>8</D:quota-used-bytes><D:quota-available-bytes>2</D:quota-available-bytes></D:prop>`)
+
+	// The usage follows the modifications.
+	assert.Equal(t, 204, serve("DELETE", "/b.txt", "").Code)
+	assert.Equal(t, 201, serve("COPY", "/a.txt", "", "Destination", "/b.txt").Code)
+	assert.Equal(t, 201, serve("MOVE", "/b.txt", "", "Destination", "/c.txt").Code)
+	assert.Equal(t, 507, serve("PUT", "/d.txt", "ddd").Code)
+	assert.Equal(t, 201, serve("PUT", "/d.txt", "dd").Code)
+
+	// The temporary files are not counted, so they can not be written.
+	serve = testWebDAVServe(WebDAV(logger, root, "", Options{Users: users, Quota: 10, AllowDotfiles: true}))
+	assert.Equal(t, 403, serve("PUT", "/.webdav-x.tmp", "xxxx").Code)
+	assert.Equal(t, 403, serve("COPY", "/a.txt", "", "Destination", "/.webdav-x.tmp").Code)
+	assert.Equal(t, 403, serve("MOVE", "/a.txt", "", "Destination", "/.webdav-x.tmp").Code)
+	assert.Equal(t, 201, serve("PUT", "/.webdav", "").Code)
+	assert.NoFileExists(t, filepath.Join(root, ".webdav-x.tmp"))
+
+	serve = testWebDAVServe(WebDAV(logger, root, "", Options{}))
+	assert.Equal(t, 401, serve("GET", "/a.txt", "").Code)
+	assert.Contains(t, log.String(), `msg=webdav-no-user`)
+}
+
+// A slow PUT body does not block the other modifications.
+func TestWebDAVPutSlow(t *testing.T) {
+	root := t.TempDir()
+	logger, _ := testLoggerOne()
+	hand := WebDAV(logger, root, "", Options{Users: testWebDAVUsers(), Quota: 100})
+	serve := testWebDAVServe(hand)
+
+	body, writer := io.Pipe()
+	done := make(chan int)
+	go func() {
+		r := httptest.NewRequest("PUT", "http://example.com/slow.txt", body)
+		r.SetBasicAuth("alice", "secret")
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, r)
+		done <- w.Code
+	}()
+	writer.Write([]byte("slow"))
+
+	assert.Equal(t, 201, serve("MKCOL", "/dir", "").Code)
+	assert.Equal(t, 201, serve("PUT", "/fast.txt", "fast").Code)
+	writer.Close()
+	assert.Equal(t, 201, <-done)
+	assert.Equal(t, "slow", serve("GET", "/slow.txt", "").Body.String())
+
+	w := serve("PROPFIND", "/", `<propfind xmlns="DAV:"><prop><quota-used-bytes/></prop></propfind>`, "Depth", "0")
+	assert.Contains(t, w.Body.String(), `<D:quota-used-bytes>8</D:quota-used-bytes>`)
+}
+
+func TestWebDAVCache(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "sub"), 0o755)
+	logger, _ := testLoggerOne()
+	serve := testWebDAVServe(WebDAV(logger, root, "", Options{Users: testWebDAVUsers()}))
+
+	// Cache handlers without the watch nor the interval update.
+	newCache := func(root string) *cacheHandler {
+		fsys := os.DirFS(root)
+		hand := newCacheHandler(logger, fsys, "", Options{}, nil)
+		cacheRegister(hand, fsys, []string{root})
+		hand.Update(fsys, time.Now())
+		return hand
+	}
+	cache := newCache(root)
+	sub := newCache(filepath.Join(root, "sub"))
+	get := func(hand http.Handler, url string) string {
+		w := httptest.NewRecorder()
+		hand.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+url, nil))
+		if w.Code != 200 {
+			return http.StatusText(w.Code)
+		}
+		return w.Body.String()
+	}
+
+	assert.Equal(t, 201, serve("PUT", "/a.txt", "a").Code)
+	assert.Equal(t, "a", get(cache, "/a.txt"))
+	assert.Equal(t, 201, serve("MKCOL", "/sub/dir", "").Code)
+	assert.Equal(t, 201, serve("PUT", "/sub/dir/b.txt", "b").Code)
+	assert.Equal(t, "b", get(cache, "/sub/dir/b.txt"))
+	assert.Equal(t, "b", get(sub, "/dir/b.txt"))
+	assert.Equal(t, 201, serve("MOVE", "/sub", "", "Destination", "/moved").Code)
+	assert.Equal(t, "b", get(cache, "/moved/dir/b.txt"))
+	assert.Equal(t, 204, serve("DELETE", "/moved", "").Code)
+	assert.Equal(t, "Not Found", get(cache, "/moved/dir/b.txt"))
+}